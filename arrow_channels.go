@@ -0,0 +1,273 @@
+package control
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// Arrow separation constants
+const (
+	attachSpacing  = 40 // Maximum distance between arrow ends sharing a box side
+	channelSpacing = 10 // Distance between arrow segments sharing a corridor
+)
+
+// separateArrows moves apart arrows that would be drawn on top of each other.
+// Arrows are routed one at a time against boxes only, so routes through the
+// same gap often share a line. First, arrow ends on the same box side are
+// spread along that side instead of all meeting at its center. Then inner
+// segments that still overlap on the same line are moved into parallel
+// channels. Each move is kept only if the route stays clear of all boxes.
+// Routes through waypoints and merged routes stay where they are.
+func separateArrows(arrows []arrow, boxData map[string]placedBox, boxes *obstacleIndex) {
+	routes := make([][]int, len(arrows))
+	for i := range arrows {
+		arrows[i].Points = simplifyPolyline(arrows[i].Points)
+		routes[i] = arrows[i].Points
+	}
+	spreadArrowEnds(arrows, boxData, boxes)
+	separateChannels(arrows, boxes)
+	for i := range arrows {
+		arrows[i].Points = simplifyPolyline(arrows[i].Points)
+		if !slices.Equal(arrows[i].Points, routes[i]) {
+			syncArrowPoints(&arrows[i])
+		}
+	}
+}
+
+// isOrthogonal reports whether every segment of a polyline is horizontal or vertical
+func isOrthogonal(points []int) bool {
+	for i := 0; i+3 < len(points); i += 2 {
+		if points[i] != points[i+2] && points[i+1] != points[i+3] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrowEnd is one end of an arrow attached to a box side
+type arrowEnd struct {
+	arrow   int  // Index into the arrows slice
+	atStart bool // Start (source) or end (target) of the arrow
+	order   int  // Position of the arrow's far end along the side, for sorting
+}
+
+// spreadArrowEnds distributes arrow ends sharing a box side evenly around
+// the side's center, ordered by where the arrows are heading to avoid crossings.
+// The two ends of a self-loop on one side are spread like any others.
+func spreadArrowEnds(arrows []arrow, boxData map[string]placedBox, boxes *obstacleIndex) {
+	type sideKey struct{ boxID, side string }
+	ends := make(map[sideKey][]arrowEnd)
+	var keys []sideKey // first-seen order, for deterministic results
+	addEnd := func(key sideKey, end arrowEnd) {
+		if _, seen := ends[key]; !seen {
+			keys = append(keys, key)
+		}
+		ends[key] = append(ends[key], end)
+	}
+	for i, arrow := range arrows {
+		points := arrow.Points
+		if len(points) < 4 || !isOrthogonal(points) || arrow.pinned() {
+			continue // e.g., straight arrows between boxes of different widths
+		}
+		n := len(points)
+		startSide, endSide := exitSide(points), entrySide(points)
+		startOrder, endOrder := sideOrder(startSide, points[n-2], points[n-1]), sideOrder(endSide, points[0], points[1])
+		if arrow.FromBoxID == arrow.ToBoxID {
+			// Self-loop: keep both ends next to each other at the end of the
+			// side, so the loop does not enclose the ends of other arrows
+			startOrder, endOrder = math.MaxInt, math.MaxInt
+		}
+		addEnd(sideKey{arrow.FromBoxID, startSide}, arrowEnd{arrow: i, atStart: true, order: startOrder})
+		addEnd(sideKey{arrow.ToBoxID, endSide}, arrowEnd{arrow: i, atStart: false, order: endOrder})
+	}
+
+	for _, key := range keys {
+		group := ends[key]
+		box, ok := boxData[key.boxID]
+		if !ok || !sharedSide(group) {
+			continue
+		}
+		sort.SliceStable(group, func(a, b int) bool { return group[a].order < group[b].order })
+
+		length := box.Width
+		if key.side == SideLeft || key.side == SideRight {
+			length = box.Height
+		}
+		center := box.CenterX
+		if key.side == SideLeft || key.side == SideRight {
+			center = box.CenterY
+		}
+		step := min(length/(len(group)+1), attachSpacing)
+		for k, end := range group {
+			arrow := &arrows[end.arrow]
+			target := center + (2*k-(len(group)-1))*step/2
+			offset := target - endPosition(arrow.Points, end.atStart, key.side)
+			shifted := shiftArrowEnd(arrow.Points, end.atStart, offset)
+			if !boxes.pathCollides(shifted, arrow.FromBoxID, arrow.ToBoxID) {
+				arrow.Points = shifted
+			}
+		}
+	}
+}
+
+// sharedSide reports whether ends of more than one arrow meet on a box side
+// (both ends of a lone self-loop do not count)
+func sharedSide(group []arrowEnd) bool {
+	for _, end := range group[1:] {
+		if end.arrow != group[0].arrow {
+			return true
+		}
+	}
+	return false
+}
+
+// endPosition returns where an arrow end currently sits along its box side
+func endPosition(points []int, atStart bool, side string) int {
+	i := 0
+	if !atStart {
+		i = len(points) - 2
+	}
+	return sideOrder(side, points[i], points[i+1])
+}
+
+// sideOrder returns the coordinate of a point along the axis of a box side
+func sideOrder(side string, x, y int) int {
+	if side == SideLeft || side == SideRight {
+		return y
+	}
+	return x
+}
+
+// shiftArrowEnd moves one end of a route along its box side by offset and
+// returns the new polyline. The first (or last) segment leaves the side at a
+// right angle, so it moves along with its end. A single straight segment gets
+// a jog in its middle so the other end can stay in place.
+func shiftArrowEnd(points []int, atStart bool, offset int) []int {
+	if offset == 0 {
+		return points
+	}
+	shifted := append([]int(nil), points...)
+	if len(shifted) == 4 {
+		x1, y1, x2, y2 := shifted[0], shifted[1], shifted[2], shifted[3]
+		if x1 == x2 {
+			midY := (y1 + y2) / 2
+			shifted = []int{x1, y1, x1, midY, x2, midY, x2, y2}
+		} else {
+			midX := (x1 + x2) / 2
+			shifted = []int{x1, y1, midX, y1, midX, y2, x2, y2}
+		}
+	}
+
+	// The end point and its neighbour share the coordinate along the side
+	i, j := 0, 2
+	if !atStart {
+		i, j = len(shifted)-2, len(shifted)-4
+	}
+	axis := 0 // x for top and bottom sides
+	if shifted[i] != shifted[j] {
+		axis = 1 // the end segment is horizontal: left or right side
+	}
+	shifted[i+axis] += offset
+	shifted[j+axis] += offset
+	return shifted
+}
+
+// channelSegment is an inner segment of an arrow route
+type channelSegment struct {
+	arrow, index int  // Arrow index and segment index (from point index to index+1)
+	horizontal   bool // Orientation of the segment
+	line         int  // Fixed coordinate (y for horizontal, x for vertical segments)
+	lo, hi       int  // Extent along the line
+}
+
+// separateChannels moves inner segments of different arrows that overlap on
+// the same line into parallel channels channelSpacing apart
+func separateChannels(arrows []arrow, boxes *obstacleIndex) {
+	var segments []channelSegment
+	for i, arrow := range arrows {
+		if arrow.pinned() {
+			continue
+		}
+		points := arrow.Points
+		// Inner segments only: the first and last ones are attached to box sides
+		for s := 1; s < len(points)/2-2; s++ {
+			x1, y1, x2, y2 := points[2*s], points[2*s+1], points[2*s+2], points[2*s+3]
+			seg := channelSegment{arrow: i, index: s, horizontal: y1 == y2}
+			if seg.horizontal {
+				seg.line, seg.lo, seg.hi = y1, min(x1, x2), max(x1, x2)
+			} else {
+				seg.line, seg.lo, seg.hi = x1, min(y1, y2), max(y1, y2)
+			}
+			segments = append(segments, seg)
+		}
+	}
+	sort.SliceStable(segments, func(a, b int) bool {
+		sa, sb := segments[a], segments[b]
+		if sa.horizontal != sb.horizontal {
+			return sa.horizontal
+		}
+		if sa.line != sb.line {
+			return sa.line < sb.line
+		}
+		return sa.lo < sb.lo
+	})
+
+	// Clusters of segments on the same line that overlap each other
+	for start := 0; start < len(segments); {
+		end, hi := start+1, segments[start].hi
+		for end < len(segments) && segments[end].horizontal == segments[start].horizontal &&
+			segments[end].line == segments[start].line && segments[end].lo < hi {
+			hi = max(hi, segments[end].hi)
+			end++
+		}
+		cluster := segments[start:end]
+		start = end
+		if len(cluster) < 2 {
+			continue
+		}
+		sort.SliceStable(cluster, func(a, b int) bool { return cluster[a].arrow < cluster[b].arrow })
+		for k, seg := range cluster {
+			arrow := &arrows[seg.arrow]
+			offset := (2*k - (len(cluster) - 1)) * channelSpacing / 2
+			shifted := shiftSegment(arrow.Points, seg.index, seg.horizontal, offset)
+			if !boxes.pathCollides(shifted, arrow.FromBoxID, arrow.ToBoxID) {
+				arrow.Points = shifted
+			}
+		}
+	}
+}
+
+// shiftSegment moves an inner segment sideways by offset; its neighbours
+// stretch or shrink to stay connected
+func shiftSegment(points []int, index int, horizontal bool, offset int) []int {
+	if offset == 0 {
+		return points
+	}
+	shifted := append([]int(nil), points...)
+	axis := 0
+	if horizontal {
+		axis = 1
+	}
+	shifted[2*index+axis] += offset
+	shifted[2*index+2+axis] += offset
+	return shifted
+}
+
+// pinned reports whether the arrow's route was forced by waypoints or merged
+// into a bus, and must not move
+func (a *arrow) pinned() bool {
+	return a.RoutingStrategy == "waypoints" || a.Merged
+}
+
+// syncArrowPoints updates an arrow's endpoints and shape from its route polyline
+func syncArrowPoints(arrow *arrow) {
+	points := arrow.Points
+	if len(points) < 4 {
+		return
+	}
+	arrow.FromX, arrow.FromY = points[0], points[1]
+	arrow.ToX, arrow.ToY = points[len(points)-2], points[len(points)-1]
+	arrow.NumSegments = len(points)/2 - 1
+	arrow.VerticalFirst = points[0] == points[2]
+}
@@ -0,0 +1,234 @@
+package control
+
+import "fmt"
+
+// Builder assembles a Spec in code, as an alternative to the text format.
+// Boxes and arrows are checked the way the parser checks them when Spec is
+// called, so they can be added in any order:
+//
+//	b := control.NewBuilder()
+//	b.Frontmatter.ArrowFlow = control.FlowDown
+//	b.Box("ceo", 2, 1, "CEO").Style("p")
+//	b.Box("cto", 1, 3, "CTO").Group("Tech")
+//	b.Arrow("ceo", "cto").Label("hires").Options("dashed")
+//	spec, err := b.Spec()
+type Builder struct {
+	Frontmatter Frontmatter // Diagram-wide settings, as in the frontmatter of a file
+
+	boxes       []builderBox
+	arrows      []builderArrow
+	groupLabels map[string]string // Group name -> label
+}
+
+// builderBox is a box with the style codes still unresolved
+type builderBox struct {
+	spec  BoxSpec
+	style string
+}
+
+// builderArrow is an arrow with the options still unresolved
+type builderArrow struct {
+	spec    ArrowSpec
+	options string
+}
+
+// BoxRef refers to a box added with Builder.Box, to set its optional attributes
+type BoxRef struct {
+	b *Builder
+	i int
+}
+
+// ArrowRef refers to an arrow added with Builder.Arrow, to set its optional attributes
+type ArrowRef struct {
+	b *Builder
+	i int
+}
+
+// NewBuilder returns an empty builder
+func NewBuilder() *Builder {
+	return &Builder{groupLabels: make(map[string]string)}
+}
+
+// Color defines a custom color usable in box styles and arrow options ("blue" and "bluet")
+func (b *Builder) Color(name, hex string) *Builder {
+	if b.Frontmatter.Colors == nil {
+		b.Frontmatter.Colors = make(map[string]string)
+	}
+	b.Frontmatter.Colors[name] = hex
+	return b
+}
+
+// Box adds a box of the default size at grid position x,y
+func (b *Builder) Box(id string, x, y int, label string) BoxRef {
+	b.boxes = append(b.boxes, builderBox{spec: BoxSpec{
+		ID:         id,
+		GridX:      x,
+		GridY:      y,
+		GridWidth:  2.0,
+		GridHeight: 1,
+		Label:      label,
+	}})
+	return BoxRef{b: b, i: len(b.boxes) - 1}
+}
+
+// Size sets the box size in grid units
+func (r BoxRef) Size(width float64, height int) BoxRef {
+	r.b.boxes[r.i].spec.GridWidth = width
+	r.b.boxes[r.i].spec.GridHeight = height
+	return r
+}
+
+// Style sets the box style codes, e.g. "g-rb" (see the STYLES section of the help)
+func (r BoxRef) Style(style string) BoxRef {
+	r.b.boxes[r.i].style = style
+	return r
+}
+
+// Group puts the box in a group, like "@Name" in the text format
+func (r BoxRef) Group(name string) BoxRef {
+	r.b.boxes[r.i].spec.Group = name
+	return r
+}
+
+// GroupLabel sets the label drawn for a group (default: the group name)
+func (b *Builder) GroupLabel(name, label string) *Builder {
+	b.groupLabels[name] = label
+	return b
+}
+
+// Arrow adds an arrow between two boxes
+func (b *Builder) Arrow(fromID, toID string) ArrowRef {
+	b.arrows = append(b.arrows, builderArrow{spec: ArrowSpec{FromID: fromID, ToID: toID}})
+	return ArrowRef{b: b, i: len(b.arrows) - 1}
+}
+
+// Label sets the text drawn on the arrow
+func (r ArrowRef) Label(label string) ArrowRef {
+	r.b.arrows[r.i].spec.Label = label
+	return r
+}
+
+// Options sets the arrow options, e.g. "down, dashed-red" (see ARROW SYNTAX in the help)
+func (r ArrowRef) Options(options string) ArrowRef {
+	r.b.arrows[r.i].options = options
+	return r
+}
+
+// Ports sets the box sides the arrow leaves and enters (e.g., SideBottom; "" = any)
+func (r ArrowRef) Ports(fromSide, toSide string) ArrowRef {
+	r.b.arrows[r.i].spec.FromSide = fromSide
+	r.b.arrows[r.i].spec.ToSide = toSide
+	return r
+}
+
+// Connector sets how the arrow is drawn between its boxes: one of the
+// connectors of the text format ("->", "<-", "<->" or "--") or of the
+// Connector constants (e.g., ConnectorBidirectional). "<-" swaps the boxes.
+func (r ArrowRef) Connector(connector string) ArrowRef {
+	r.b.arrows[r.i].spec.Connector = connector
+	return r
+}
+
+// Spec checks the boxes and arrows and returns the finished Spec.
+// Errors are returned as Diagnostics, without positions; unknown style codes
+// and arrow options are warnings in Diagram.Diagnostics, as when parsing.
+func (b *Builder) Spec() (*Spec, error) {
+	var diags Diagnostics
+	report := func(severity Severity, code, format string, args ...any) {
+		diags = append(diags, Diagnostic{Severity: severity, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	colors := b.Frontmatter.Colors
+	for _, name := range invalidColors(colors) {
+		report(SeverityWarning, CodeStyle, "custom color '%s': '%s' is not a hex color or color name (ignored)", name, colors[name])
+	}
+
+	diagram := &DiagramSpec{
+		Boxes:  make([]BoxSpec, 0, len(b.boxes)),
+		Arrows: make([]ArrowSpec, 0, len(b.arrows)),
+	}
+	ids := make(map[string]bool)
+	var groupOrder []string
+	groupBoxIDs := make(map[string][]string)
+	for _, box := range b.boxes {
+		spec := box.spec
+		if !validBoxID(spec.ID) {
+			report(SeverityError, CodeInvalidID, "invalid ID '%s': must contain only alphanumeric characters, underscore, or hyphen", spec.ID)
+			continue
+		}
+		if ids[spec.ID] {
+			report(SeverityError, CodeInvalidID, "duplicate ID '%s'", spec.ID)
+			continue
+		}
+		ids[spec.ID] = true
+		if spec.GridX < 1 || spec.GridY < 1 {
+			report(SeverityError, CodeCoordinate, "box '%s': grid position %d,%d must be >= 1", spec.ID, spec.GridX, spec.GridY)
+		}
+		if spec.GridWidth < 0.2 {
+			report(SeverityError, CodeSize, "box '%s': GridWidth must be >= 0.2, got %.1f", spec.ID, spec.GridWidth)
+		}
+		if spec.GridHeight < 1 {
+			report(SeverityError, CodeSize, "box '%s': GridHeight must be >= 1, got %d", spec.ID, spec.GridHeight)
+		}
+
+		for _, code := range unknownStyleCodes(box.style, colors) {
+			report(SeverityWarning, CodeStyle, "box '%s': unknown style code '%s' (ignored)", spec.ID, code)
+		}
+		styles := parseBoxStyles(box.style, colors)
+		spec.Color = styles.BackgroundColor
+		spec.BorderColor = styles.BorderColor
+		spec.BorderWidth = styles.BorderWidth
+		spec.FontSize = styles.FontSize
+		spec.TextColor = styles.TextColor
+		diagram.Boxes = append(diagram.Boxes, spec)
+
+		if spec.Group != "" {
+			if _, seen := groupBoxIDs[spec.Group]; !seen {
+				groupOrder = append(groupOrder, spec.Group)
+			}
+			groupBoxIDs[spec.Group] = append(groupBoxIDs[spec.Group], spec.ID)
+		}
+	}
+
+	for _, arrow := range b.arrows {
+		spec := arrow.spec
+		connector, reversed, ok := parseConnector(spec.Connector)
+		if !ok {
+			report(SeverityError, CodeArrowReference, "arrow '%s -> %s': unknown connector '%s'", spec.FromID, spec.ToID, spec.Connector)
+		}
+		spec.Connector = connector
+		if reversed {
+			spec.FromID, spec.ToID = spec.ToID, spec.FromID
+			spec.FromSide, spec.ToSide = spec.ToSide, spec.FromSide
+		}
+		for _, id := range []string{spec.FromID, spec.ToID} {
+			if !ids[id] {
+				report(SeverityError, CodeArrowReference, "arrow '%s -> %s' references non-existent box label '%s'", spec.FromID, spec.ToID, id)
+			}
+		}
+		for _, side := range []string{spec.FromSide, spec.ToSide} {
+			switch side {
+			case "", SideTop, SideBottom, SideLeft, SideRight:
+			default:
+				report(SeverityError, CodeArrowReference, "arrow '%s -> %s': unknown port '%s'", spec.FromID, spec.ToID, side)
+			}
+		}
+		for _, code := range parseArrowOptions(arrow.options, &spec, colors) {
+			report(SeverityWarning, CodeStyle, "unknown arrow option '%s' (ignored)", code)
+		}
+		diagram.Arrows = append(diagram.Arrows, spec)
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	for _, name := range groupOrder {
+		label := name
+		if l, ok := b.groupLabels[name]; ok {
+			label = l
+		}
+		diagram.Groups = append(diagram.Groups, GroupDef{Name: name, Label: label, BoxIDs: groupBoxIDs[name]})
+	}
+	diagram.Diagnostics = diags
+	return &Spec{Frontmatter: b.Frontmatter, Diagram: diagram}, nil
+}
@@ -0,0 +1,207 @@
+package control
+
+import (
+	"math"
+	"slices"
+)
+
+// junctionRadius is the radius of the dot where a bus splits into branches
+const junctionRadius = 4
+
+// Arrow merge modes (diagramConfig.Merge)
+const (
+	MergeBus  = "bus"  // Arrows sharing a source or a target share a trunk
+	MergeNone = "none" // Every arrow keeps its own route
+)
+
+// junction is a dot drawn where a merged trunk splits into branches
+type junction struct {
+	X, Y  int
+	Color string // Fill color ("" = black)
+}
+
+// mergeBuses reroutes arrows that leave the same box side, are allowed to merge
+// and are drawn in the same style, through one shared trunk: from the middle of the side to a bus line,
+// along the bus and off it in branches to each target. Arrows entering the
+// same box side are merged the same way, with the trunk at the target. Fan-outs
+// are merged first; an arrow joins at most one bus. Returns the junctions where
+// the merged routes split.
+func mergeBuses(arrows []arrow, boxData map[string]placedBox, boxes *obstacleIndex) []junction {
+	var junctions []junction
+	for _, fanIn := range []bool{false, true} {
+		// Only arrows styled alike share a trunk
+		type busKey struct {
+			boxID, side              string
+			color, dash, head, shape string
+			width                    int
+		}
+		groups := make(map[busKey][]int)
+		var keys []busKey // first-seen order, for deterministic results
+		for i := range arrows {
+			arrow := &arrows[i]
+			if !arrow.Merge || arrow.Merged || arrow.FromBoxID == arrow.ToBoxID ||
+				len(arrow.Points) < 4 || !isOrthogonal(arrow.Points) {
+				continue
+			}
+			key := busKey{
+				boxID: arrow.FromBoxID, side: exitSide(arrow.Points),
+				color: arrow.Color, dash: arrow.Dash, head: arrow.Head, shape: arrow.Shape, width: arrow.StrokeWidth,
+			}
+			if fanIn {
+				key.boxID, key.side = arrow.ToBoxID, entrySide(arrow.Points)
+			}
+			if _, seen := groups[key]; !seen {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], i)
+		}
+
+		for _, key := range keys {
+			box, ok := boxData[key.boxID]
+			if !ok || len(groups[key]) < 2 {
+				continue
+			}
+			center := box.CenterX
+			if key.side == SideLeft || key.side == SideRight {
+				center = box.CenterY
+			}
+			junctions = append(junctions, mergeBus(arrows, groups[key], key.side, center, fanIn, boxes)...)
+		}
+	}
+	return junctions
+}
+
+// mergeBus merges one group of arrows sharing a box side into a bus. Routes
+// are handled in the frame of the side (see toFrame), running away from the
+// shared box: the trunk leaves the side at center, the bus runs halfway to the
+// nearest far end, and branches run straight to each far end. Arrows whose
+// far end is not entered head-on from the bus, or whose merged route would
+// cross a box, keep their own routes.
+func mergeBus(arrows []arrow, group []int, side string, center int, fanIn bool, boxes *obstacleIndex) []junction {
+	routes := make(map[int][]int)
+	var members []int
+	startV, nearest := 0, math.MaxInt
+	for _, i := range group {
+		points := append([]int(nil), simplifyPolyline(arrows[i].Points)...)
+		if fanIn {
+			reversePoints(points)
+		}
+		frame := toFrame(side, points)
+		n := len(frame)
+		if frame[n-2] != frame[n-4] || frame[n-1] <= frame[n-3] {
+			continue // The far end is not entered moving away from the shared box
+		}
+		startV = frame[1]
+		nearest = min(nearest, frame[n-1])
+		routes[i] = frame
+		members = append(members, i)
+	}
+	if len(members) < 2 || nearest-startV < 2*portStubLength {
+		return nil
+	}
+	busV := (startV + nearest) / 2
+
+	merged := make(map[int][]int)
+	for _, i := range members {
+		frame := routes[i]
+		endU, endV := frame[len(frame)-2], frame[len(frame)-1]
+		points := fromFrame(side, simplifyPolyline([]int{center, startV, center, busV, endU, busV, endU, endV}))
+		if fanIn {
+			reversePoints(points)
+		}
+		if !boxes.pathCollides(points, arrows[i].FromBoxID, arrows[i].ToBoxID) {
+			merged[i] = points
+		}
+	}
+	if len(merged) < 2 {
+		return nil
+	}
+
+	// Branch positions along the bus, for the junctions
+	lo, hi := center, center
+	var branches []int
+	straight := false
+	for _, i := range members {
+		points, ok := merged[i]
+		if !ok {
+			continue
+		}
+		arrows[i].Points = points
+		arrows[i].Merged = true
+		syncArrowPoints(&arrows[i])
+
+		endU := routes[i][len(routes[i])-2]
+		lo, hi = min(lo, endU), max(hi, endU)
+		if endU == center {
+			straight = true
+		} else {
+			branches = append(branches, endU)
+		}
+	}
+
+	// The bus splits where more than two lines meet: at the trunk if branches
+	// leave it in more than one direction, and at every branch inside the bus
+	color := arrows[members[0]].Color
+	var junctions []junction
+	addJunction := func(u int) {
+		xy := fromFrame(side, []int{u, busV})
+		junction := junction{X: xy[0], Y: xy[1], Color: color}
+		if !slices.Contains(junctions, junction) {
+			junctions = append(junctions, junction)
+		}
+	}
+	directions := 0
+	for _, leaves := range []bool{lo < center, hi > center, straight} {
+		if leaves {
+			directions++
+		}
+	}
+	if directions >= 2 {
+		addJunction(center)
+	}
+	for _, u := range branches {
+		if lo < u && u < hi {
+			addJunction(u)
+		}
+	}
+	return junctions
+}
+
+// toFrame maps a polyline [x0,y0,...] into the frame of a box side, where u
+// runs along the side and v grows away from the box. fromFrame is its inverse.
+func toFrame(side string, points []int) []int {
+	frame := make([]int, len(points))
+	for i := 0; i+1 < len(points); i += 2 {
+		x, y := points[i], points[i+1]
+		switch side {
+		case SideTop:
+			frame[i], frame[i+1] = x, -y
+		case SideLeft:
+			frame[i], frame[i+1] = y, -x
+		case SideRight:
+			frame[i], frame[i+1] = y, x
+		default:
+			frame[i], frame[i+1] = x, y
+		}
+	}
+	return frame
+}
+
+// fromFrame maps a polyline from the frame of a box side back to pixels
+func fromFrame(side string, frame []int) []int {
+	points := make([]int, len(frame))
+	for i := 0; i+1 < len(frame); i += 2 {
+		u, v := frame[i], frame[i+1]
+		switch side {
+		case SideTop:
+			points[i], points[i+1] = u, -v
+		case SideLeft:
+			points[i], points[i+1] = -v, u
+		case SideRight:
+			points[i], points[i+1] = v, u
+		default:
+			points[i], points[i+1] = u, v
+		}
+	}
+	return points
+}
@@ -0,0 +1,486 @@
+// Command control generates SVG diagrams from text specifications.
+// It is a thin command line wrapper around the control package.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/StephanSchmidt/control"
+	"github.com/alecthomas/kong"
+)
+
+var version = "dev"
+
+// stdio as a --diagram or --out value reads stdin or writes stdout
+const stdio = "-"
+
+type CLI struct {
+	Version     kong.VersionFlag `help:"Print version and exit"`
+	Diagram     string           `help:"Input diagram file (- for stdin)" type:"path" default:"examples/diagram.txt"`
+	Out         string           `help:"Output SVG file (- for stdout)" type:"path" default:"examples/diagram.svg"`
+	Stretch     float64          `help:"Horizontal stretch factor (1.0 = normal, 0.8 = 80% width)" default:"1.0"`
+	VerticalGap float64          `help:"Vertical gap between boxes in grid units" default:"0.5"`
+	Font        string           `help:"Custom font file (WOFF2 format) to embed in SVG" type:"path" optional:""`
+	Debug       string           `help:"Output debug information to JSON file" type:"path" optional:""`
+	MaxErrors   int              `help:"Stop reporting after this many errors (0 = unlimited)" default:"20"`
+
+	Render struct{} `cmd:"" default:"1" hidden:"" help:"Render the diagram to SVG (the default)"`
+	Check  struct{} `cmd:"" help:"Parse and lay out the diagram and report all problems, without writing anything"`
+}
+
+func printHelp() {
+	help := `control - Generate SVG diagrams from text specifications
+
+USAGE:
+  control --diagram <file> --out <output.svg> [options]
+  control --diagram - --out - < input.txt > output.svg
+  control check --diagram <file>
+
+COMMANDS:
+  check               Parse and lay out the diagram and report all problems
+                      without writing any file; exits with status 1 on errors
+
+OPTIONS:
+  --diagram <file>    Input diagram file, - for stdin
+                      (default: examples/diagram.txt)
+  --out <file>        Output SVG file, - for stdout
+                      (default: examples/diagram.svg)
+  --stretch <float>   Horizontal stretch factor, 1.0 = normal (default: 1.0)
+  --vertical-gap <f>  Vertical gap between boxes in grid units (default: 0.5)
+  --font <file>       Custom font file (WOFF2 format) to embed in SVG
+  --debug <file>      Output debug information to JSON file
+  --max-errors <n>    Stop reporting after n errors, 0 = unlimited (default: 20)
+
+FRONTMATTER:
+  Diagram files can include optional metadata at the top of the file,
+  enclosed between "---" delimiters.
+
+  Recognized keys:
+    font: <path>           Path to a custom font file (WOFF2 format)
+    x-label: <text>        X-axis label (omit to hide axes)
+    y-label: <text>        Y-axis label (omit to hide axes)
+    legend: <style> = <text>  Legend entry (repeatable)
+    color: <name> = <hex>  Custom color definition (repeatable)
+    arrow-flow: <flow>     Preferred routing: down, up, left, right or
+                           horizontal (see ARROW SYNTAX)
+    arrowhead: <shape>     Default arrowhead shape (see ARROW SYNTAX)
+    crossings: hop         Draw bridges where arrows cross (default: none)
+    arrow-shape: <shape>   Arrow line shape: sharp, rounded or curved
+                           (default: sharp)
+    arrow-merge: bus|none  Merge arrows sharing a source or target into
+                           one trunk (default: bus for arrow-flow down)
+
+  If neither x-label nor y-label is set, axes are not drawn.
+  Legend entries map style codes to descriptions, rendered top-right.
+  Comments (#) and blank lines are allowed within frontmatter.
+  CLI flags (e.g. --font) take precedence over frontmatter values.
+
+  Example:
+    ---
+    font: fonts/BerkeleyMono-Condensed.woff2
+    x-label: Time
+    y-label: Control
+    legend: p = In Progress
+    legend: g = Completed
+    color: green = #00FF00
+    ---
+
+  Custom colors can be used as style codes:
+    green       Use as background color
+    greent      Use as text color (append "t")
+    nbb-greent  Combine with other styles
+
+    1,1: OKRs
+    >+1,+1: Sprint Planning
+
+DIAGRAM FORMAT:
+  A diagram file contains box definitions and arrow definitions.
+  Arrow lines (containing "->") can appear anywhere in the file.
+  An optional "---" separator can be used for visual clarity.
+
+  Lines starting with "#" are comments.
+
+BOX SYNTAX:
+  [id:] x,y[,width[,height]]: Label[, style] [@Group]
+
+  - id        Optional identifier for referencing in arrows (alphanumeric, _, -)
+  - x,y       Grid coordinates (starting from 1)
+  - width     Grid width (default: 2, supports fractions like 1/2)
+  - height    Grid height (default: 1)
+  - Label     Display text inside the box
+  - style     Optional comma-separated style codes
+  - @Group    Optional group assignment (see GROUPS below)
+
+RELATIVE COORDINATES:
+  Use +N or -N for coordinates relative to the previous box.
+  Use 0 as shorthand for +0 (same position as previous box).
+
+  Example: if previous box is at 3,2:
+    +1,+1  -> resolves to 4,3
+    +2,-1  -> resolves to 5,1
+    0,+1   -> resolves to 3,3
+
+AUTO-ARROWS (> prefix):
+  Prefix coordinates with ">" to automatically create an arrow
+  from the previous box to this one.
+
+    1,1: Start
+    >+1,+1: Next       # creates arrow Start -> Next
+
+TOUCH-LEFT (| prefix):
+  Prefix coordinates with "|" to visually attach this box to the
+  previous one (no gap). Requires same row (Y=0) and positive
+  relative X.
+
+    1,1: First
+    |+2,0: Second      # Second touches First on its left side
+
+STYLES:
+  g     Gray background (#D3D3D3)
+  p     Purple background (#ecbae6)
+  lp    Light purple background (#f5dbf2)
+  rb    Red border (3px)
+  rt    Red text
+  nbb   No background, no border (for overlay text)
+  2t    Double text size (48px)
+
+  Combine with dashes:
+    rb-g      Red border + gray background (highlighted task)
+    rb-p      Red border + purple background (urgent in-progress)
+    lp-rb     Light purple + red border
+    nbb-2t    Invisible box, large text (section header)
+    nbb-rt    Invisible box, red text (warning label)
+    nbb-rt-2t Invisible box, red large text (floating overlay)
+
+GROUPS (@prefix):
+  Visually group boxes with a surrounding dashed rectangle.
+
+  Assign a box to a group by appending @GroupName to the box line:
+    1,2: Stephan @Team
+    >3,2: Stefanie, p @Team
+
+  Optionally define a group label:
+    @Team: Our Team
+
+  If no label line is defined, the group name is used as the label.
+
+CONTAINERS ([ ] blocks):
+  ID: x,y[: Label[, style]] [
+    ...boxes and arrows with coordinates relative to x,y...
+  ] [@Group]
+
+  Box IDs inside are scoped (X becomes ID.X). Containers can be nested.
+  A style part draws a frame around the container's boxes:
+    frame     Frame with default styling
+    dash      Dashed border
+    tc, tr    Title centered / right-aligned
+    g, p, lp  Frame fill (custom colors work too)
+
+    G: 3,2: Billing, frame-dash [
+        X: 0,0: Invoice
+    ]
+
+ARROW SYNTAX:
+  from_id -> to_id[: label] [| options]
+
+  Arrow lines can appear anywhere in the file (no separator needed).
+  Arrows route automatically using orthogonal segments (left-to-right).
+  If no simple route avoids all boxes, the arrow is routed around them.
+
+  Connectors:
+    a -> b     Arrowhead at b
+    a <- b     Arrowhead at a (same as b -> a)
+    a <-> b    Arrowheads at both ends
+    a -- b     Plain line without arrowheads (spaces required)
+  An optional label is drawn on the arrow's longest segment:
+    plan -> dev: approves
+  Ports fix the side an arrow leaves or enters a box
+  (.top, .bottom, .left, .right):
+    plan.bottom -> dev.left
+  Waypoints force bend points at grid cell centers:
+    plan -> ship via 4,3 via 6,3
+  A box pointing to itself gets a loop off one side:
+    review -> review: rework
+
+  Chains and comma-separated lists create several arrows at once;
+  label and options apply to every arrow of the line:
+    plan -> dev -> test       # plan -> dev, dev -> test
+    ceo -> vp1, vp2 | down    # fan-out
+    dev, test -> ship         # fan-in
+
+  Options (combine with "-", "," or spaces):
+    down, up            Prefer vertical routing (top-down, bottom-up)
+    left, right, horizontal
+                        Prefer horizontal routing
+    loop                Route around the outside of the diagram
+                        (default for arrows against the flow)
+    dashed, dotted      Line pattern
+    thick               Thick line (4px)
+    red, steelblue, ... Line and arrowhead color: a CSS color name or a
+                        custom color
+    chevron, triangle, hollow, diamond, circle, bar
+                        Arrowhead shape (default: chevron)
+    sharp, rounded, curved
+                        Line shape (default: sharp)
+
+    test -> dev: rework | dashed-red
+
+EXAMPLES:
+
+  Simple flow:
+    a: 1,1: Planning
+    b: >+2,0: Development
+    c: >+2,0: Review
+    ---
+    c -> a
+
+  Scrum board:
+    1,1: OKRs
+    >+1,+1: Business Initiatives
+    >+1,+1: Backlog Planning
+    >+1,+1: Sprint Planning
+    >+1,+1,1: Daily
+    >+2,+1: Developing, p
+    >+2,-1,1/2:
+    >0,+1: Developing, p
+    >+2,-1,2: Sprint Review
+    9,1: SCRUM, nbb-rt-2t
+
+  Boxes with IDs and manual arrows:
+    plan: 1,1: Plan
+    dev: 3,2: Develop
+    test: 5,2: Test
+    ship: 7,1: Ship
+    ---
+    plan -> dev
+    dev -> test
+    test -> ship
+    test -> dev
+
+  Visual groups:
+    1,2: Stephan @Team
+    >+2,0: Stefanie, p @Team
+    >+2,0: Me, g @Team
+    1,1: AI, nbb-2t
+    @Team: Our Team
+`
+	fmt.Print(help)
+}
+
+func main() {
+	// Print help when called without arguments
+	if len(os.Args) == 1 {
+		printHelp()
+		return
+	}
+
+	var cli CLI
+	ctx := kong.Parse(&cli, kong.Vars{"version": version})
+	if ctx.Command() == "check" {
+		os.Exit(check(cli))
+	}
+	os.Exit(render(cli))
+}
+
+// render writes the diagram as SVG, and as debug JSON if requested.
+// Returns the exit code.
+func render(cli CLI) int {
+	// Status messages go to stderr when the SVG goes to stdout
+	status := os.Stdout
+	if cli.Out == stdio {
+		status = os.Stderr
+	}
+
+	// Security check: prevent running as root
+	if err := checkNotRoot(); err != nil {
+		fmt.Fprintln(status, "Security error:", err)
+		return 1
+	}
+
+	// Open output file early (before dropping capabilities)
+	// This ensures we have write permission and get the file handle.
+	// The SVG goes to a temporary file that only replaces the output on success.
+	var out io.Writer = os.Stdout
+	var outFile *atomicFile
+	if cli.Out != stdio {
+		var err error
+		outFile, err = createAtomic(cli.Out)
+		if err != nil {
+			fmt.Fprintf(status, "Error creating output file '%s': %v\n", cli.Out, err)
+			return 1
+		}
+		defer outFile.Abort()
+		out = outFile
+	}
+
+	// Read diagram from file or stdin
+	diagramBytes, err := readDiagram(cli.Diagram)
+	if err != nil {
+		fmt.Fprintf(status, "Error reading file '%s': %v\n", cli.Diagram, err)
+		return 1
+	}
+
+	// Extract frontmatter (font path, etc.) before dropping capabilities
+	frontmatter, _ := control.ParseFrontmatter(string(diagramBytes))
+
+	// Determine font path: CLI flag takes precedence over frontmatter
+	fontPath := cli.Font
+	if fontPath == "" && frontmatter.Font != "" {
+		fontPath = frontmatter.Font
+	}
+
+	// Load custom font if specified (must happen before dropping capabilities)
+	var fontData *control.FontData
+	if fontPath != "" {
+		fontData, err = control.LoadCustomFont(fontPath)
+		if err != nil {
+			fmt.Fprintf(status, "Error loading font '%s': %v\n", fontPath, err)
+			return 1
+		}
+	}
+
+	// Drop capabilities now that we have file handles secured
+	if err := dropCapabilities(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to drop capabilities:", err)
+		return 1
+	}
+
+	spec, ok := parseDiagram(cli, diagramBytes)
+	if !ok {
+		return 1
+	}
+
+	// Render: stream the SVG to the already-opened file, keep the debug JSON
+	// until the SVG is in place
+	var debug bytes.Buffer
+	opts := renderOptions(cli, fontData)
+	if cli.Debug != "" {
+		opts.Debug = &debug
+	}
+	warnings, err := control.Render(spec, opts, out)
+	printDiagnostics(warnings)
+	if err != nil {
+		fmt.Fprintln(status, "Error writing file:", err)
+		return 1
+	}
+
+	if outFile != nil {
+		if err := outFile.Commit(); err != nil {
+			fmt.Fprintln(status, "Error writing file:", err)
+			return 1
+		}
+		fmt.Fprintf(status, "Diagram generated successfully: %s\n", cli.Out)
+	}
+
+	// Write debug output if requested
+	if cli.Debug != "" {
+		if err := os.WriteFile(cli.Debug, debug.Bytes(), 0600); err != nil {
+			fmt.Fprintf(status, "Error writing debug file '%s': %v\n", cli.Debug, err)
+			return 1
+		}
+		fmt.Fprintf(status, "Debug output written: %s\n", cli.Debug)
+	}
+	return 0
+}
+
+// check parses and lays out the diagram like render and reports all problems,
+// without writing any file. Returns the exit code: 1 if the diagram has errors.
+func check(cli CLI) int {
+	// Security check: prevent running as root
+	if err := checkNotRoot(); err != nil {
+		fmt.Fprintln(os.Stderr, "Security error:", err)
+		return 1
+	}
+
+	// Read diagram from file or stdin
+	diagramBytes, err := readDiagram(cli.Diagram)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file '%s': %v\n", cli.Diagram, err)
+		return 1
+	}
+
+	// Nothing else to open: drop capabilities before parsing
+	if err := dropCapabilities(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to drop capabilities:", err)
+		return 1
+	}
+
+	spec, ok := parseDiagram(cli, diagramBytes)
+	if !ok {
+		return 1
+	}
+	warnings, err := control.Render(spec, renderOptions(cli, nil), io.Discard)
+	printDiagnostics(warnings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering diagram:", err)
+		return 1
+	}
+	fmt.Printf("%s: no errors, %d warning(s)\n", displayPath(cli.Diagram), len(warnings))
+	return 0
+}
+
+// parseDiagram parses the diagram, printing all errors. Returns false if the
+// diagram has errors.
+func parseDiagram(cli CLI, diagramBytes []byte) (*control.Spec, bool) {
+	spec, err := control.ParseWithOptions(bytes.NewReader(diagramBytes), control.ParseOptions{
+		File:      displayPath(cli.Diagram),
+		MaxErrors: cli.MaxErrors,
+	})
+	if err != nil {
+		var diags control.Diagnostics
+		if errors.As(err, &diags) {
+			printDiagnostics(diags)
+		} else {
+			fmt.Fprintln(os.Stderr, "Error parsing diagram:", err)
+		}
+		return nil, false
+	}
+	return spec, true
+}
+
+// renderOptions returns the rendering options set on the command line
+func renderOptions(cli CLI, font *control.FontData) control.Options {
+	return control.Options{
+		Stretch:     cli.Stretch,
+		VerticalGap: cli.VerticalGap,
+		Font:        font,
+	}
+}
+
+// readDiagram reads the diagram file, or stdin for "-"
+func readDiagram(path string) ([]byte, error) {
+	if path == stdio {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// printDiagnostics prints diagnostics compiler-style (file:line:col: message) to stderr
+func printDiagnostics(diags control.Diagnostics) {
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+	}
+}
+
+// displayPath shortens an absolute path to one relative to the working directory
+// for diagnostics, falling back to the path as given
+func displayPath(path string) string {
+	if path == stdio {
+		return "<stdin>"
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxSymlinks bounds the links followed to the output file, as the kernel does
+const maxSymlinks = 40
+
+// atomicFile is an output file written under a temporary name next to its
+// target and renamed over it by Commit. A failed run leaves the previous
+// file untouched instead of truncating it.
+type atomicFile struct {
+	*os.File
+	path string      // Target path
+	mode os.FileMode // Permissions of a new target, as os.Create would set them
+	done bool        // Committed or aborted
+}
+
+// createAtomic opens a temporary file in the directory of the file path
+// points at, so Commit replaces that file and keeps any symlink to it
+func createAtomic(path string) (*atomicFile, error) {
+	path, err := resolveSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: path, mode: 0o666 &^ umask()}, nil
+}
+
+// resolveSymlinks returns the file path points at. A dangling symlink
+// resolves to its missing target, which Commit then creates.
+func resolveSymlinks(path string) (string, error) {
+	for range maxSymlinks {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return resolved, nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return path, nil // Not a symlink: a new file
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+}
+
+// Commit flushes the file to disk and renames it over the target, keeping the
+// permissions of an existing target
+func (f *atomicFile) Commit() error {
+	mode := f.mode
+	if info, err := os.Stat(f.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err := f.Chmod(mode)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	f.done = true
+	return err
+}
+
+// Abort closes and removes the temporary file; a no-op after Commit
+func (f *atomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
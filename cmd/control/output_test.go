@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicFile_CommitReplacesTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diagram.svg")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(path)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("Expected the target untouched before Commit, got %q", got)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	f.Abort() // deferred by callers: must not remove the committed file
+
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "new" {
+		t.Errorf("Expected %q after Commit, got %q (%v)", "new", got, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the target's permissions to be kept, got %v (%v)", info.Mode().Perm(), err)
+	}
+	assertOnlyFile(t, dir, "diagram.svg")
+}
+
+func TestAtomicFile_AbortKeepsTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diagram.svg")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(path)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	if _, err := f.WriteString("partial"); err != nil {
+		t.Fatal(err)
+	}
+	f.Abort()
+
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("Expected the target untouched after Abort, got %q", got)
+	}
+	assertOnlyFile(t, dir, "diagram.svg")
+}
+
+func TestAtomicFile_NewTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diagram.svg")
+
+	f, err := createAtomic(path)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	// The same permissions as a file created directly
+	created, err := os.Create(filepath.Join(t.TempDir(), "created.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = created.Close()
+	want, _ := os.Stat(created.Name())
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Expected a new target with mode %v, got %v (%v)", want.Mode().Perm(), info, err)
+	}
+}
+
+func TestAtomicFile_SymlinkTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool // Whether the link's target exists before the run
+	}{
+		{"existing target", true},
+		{"dangling link", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "diagram.svg")
+			link := filepath.Join(dir, "link.svg")
+			if tt.existing {
+				if err := os.WriteFile(target, []byte("old"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink("diagram.svg", link); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := createAtomic(link)
+			if err != nil {
+				t.Fatalf("createAtomic failed: %v", err)
+			}
+			if _, err := f.WriteString("new"); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit failed: %v", err)
+			}
+
+			if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("Expected the symlink to be kept, got %v (%v)", info, err)
+			}
+			if got, err := os.ReadFile(target); err != nil || string(got) != "new" {
+				t.Errorf("Expected %q written to the link's target, got %q (%v)", "new", got, err)
+			}
+		})
+	}
+}
+
+// assertOnlyFile fails unless dir holds exactly the named file (no leftover temp files)
+func assertOnlyFile(t *testing.T, dir, name string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != name {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("Expected only %s in the directory, got %v", name, names)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// umask returns no mask on platforms without one.
+func umask() os.FileMode {
+	return 0
+}
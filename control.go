@@ -0,0 +1,173 @@
+// Package control generates SVG diagrams from plain text.
+//
+// A diagram is a Spec: the boxes and arrows of a DiagramSpec plus the
+// diagram-wide settings of its Frontmatter. Parse reads a Spec from the text
+// format, and a Builder assembles one in code. Render lays a Spec out and
+// streams it as SVG:
+//
+//	spec, err := control.Parse(strings.NewReader("A: 1,1: Plan\nB: >+2,0: Ship"))
+//	if err != nil {
+//		return err // Diagnostics, positioned in the text
+//	}
+//	warnings, err := control.Render(spec, control.DefaultOptions(), w)
+//
+// Parse, Render and Builder never touch the file system: a font is loaded by
+// the caller with LoadCustomFont and passed in Options, the font path in the
+// frontmatter is only read by the command line tool.
+package control
+
+import (
+	"fmt"
+	"io"
+)
+
+// Spec is a parsed diagram, ready to render
+type Spec struct {
+	Frontmatter Frontmatter  // Diagram-wide settings (axes, legend, colors, arrow defaults)
+	Diagram     *DiagramSpec // Boxes, arrows, groups and containers
+	File        string       // File name reported in diagnostics ("" if unknown)
+}
+
+// Options holds the rendering settings that are not part of a diagram
+type Options struct {
+	Stretch     float64   // Horizontal stretch factor (1.0 = normal; 0 is treated as 1.0)
+	VerticalGap float64   // Vertical gap between boxes in grid units
+	Font        *FontData // Embedded font, nil for the default
+	Debug       io.Writer // If set, Render also writes the layout here as JSON: boxes, arrows and routing candidates
+}
+
+// DefaultOptions returns the options the command line uses by default
+func DefaultOptions() Options {
+	config := newDefaultConfig()
+	return Options{
+		Stretch:     config.Stretch,
+		VerticalGap: config.VerticalGapUnits,
+	}
+}
+
+// Parse reads a diagram in the text format, frontmatter included.
+// Errors are returned as Diagnostics; warnings are kept in Diagram.Diagnostics.
+func Parse(r io.Reader) (*Spec, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// ParseWithOptions is Parse with a file name for diagnostics and an error limit.
+// opts.LineOffset counts lines preceding the text, the frontmatter is added to it.
+func ParseWithOptions(r io.Reader, opts ParseOptions) (*Spec, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	frontmatter, diagramText := ParseFrontmatter(string(text))
+	opts.LineOffset += frontmatter.BodyOffset
+	diagram, err := parseDiagramSpecWithOptions(diagramText, frontmatter.Colors, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{Frontmatter: frontmatter, Diagram: diagram, File: opts.File}, nil
+}
+
+// Render lays out spec and writes it to w as SVG, and to opts.Debug as JSON.
+// Returns all warnings, those found while parsing included, in the order they
+// were found.
+func Render(spec *Spec, opts Options, w io.Writer) (Diagnostics, error) {
+	diagram, boxData, warnings := spec.layout(opts)
+	if err := diagram.WriteSVG(w); err != nil {
+		return warnings, err
+	}
+	if opts.Debug != nil {
+		return warnings, writeDebugJSON(opts.Debug, generateDebugOutput(diagram, boxData))
+	}
+	return warnings, nil
+}
+
+// layout converts the spec to a diagram with pixel coordinates. Returns the
+// diagram, its box data (for generateDebugOutput) and all warnings in the order
+// they were found. The spec is not modified, so it can be laid out again with
+// other options.
+func (s *Spec) layout(opts Options) (*diagram, map[string]placedBox, Diagnostics) {
+	frontmatter := s.Frontmatter
+	warnings := append(Diagnostics(nil), s.Diagram.Diagnostics...)
+
+	// Create layout configuration
+	config := newDefaultConfig()
+	if opts.Stretch != 0 {
+		config.Stretch = opts.Stretch
+	}
+	config.VerticalGapUnits = opts.VerticalGap
+	config.YAxis = frontmatter.YLabel != ""
+	if frontmatter.Arrowhead != "" {
+		if isArrowheadShape(frontmatter.Arrowhead) {
+			config.ArrowHead = frontmatter.Arrowhead
+		} else {
+			warnings = append(warnings, Diagnostic{
+				File:     s.File,
+				Severity: SeverityWarning,
+				Code:     CodeStyle,
+				Message:  fmt.Sprintf("unknown arrowhead '%s' in frontmatter (using chevron)", frontmatter.Arrowhead),
+			})
+		}
+	}
+
+	if frontmatter.ArrowFlow != "" && !isFlow(frontmatter.ArrowFlow) {
+		warnings = append(warnings, Diagnostic{
+			File:     s.File,
+			Severity: SeverityWarning,
+			Code:     CodeStyle,
+			Message:  fmt.Sprintf("unknown arrow flow '%s' in frontmatter (ignored)", frontmatter.ArrowFlow),
+		})
+		frontmatter.ArrowFlow = ""
+	}
+
+	switch frontmatter.Crossings {
+	case "", CrossingsNone, CrossingsHop:
+		config.Crossings = frontmatter.Crossings
+	default:
+		warnings = append(warnings, Diagnostic{
+			File:     s.File,
+			Severity: SeverityWarning,
+			Code:     CodeStyle,
+			Message:  fmt.Sprintf("unknown crossings style '%s' in frontmatter (using none)", frontmatter.Crossings),
+		})
+	}
+
+	switch frontmatter.ArrowShape {
+	case "", ArrowSharp, ArrowRounded, ArrowCurved:
+		config.ArrowShape = frontmatter.ArrowShape
+	default:
+		warnings = append(warnings, Diagnostic{
+			File:     s.File,
+			Severity: SeverityWarning,
+			Code:     CodeStyle,
+			Message:  fmt.Sprintf("unknown arrow shape '%s' in frontmatter (using sharp)", frontmatter.ArrowShape),
+		})
+	}
+
+	switch frontmatter.ArrowMerge {
+	case "", MergeBus, MergeNone:
+		config.Merge = frontmatter.ArrowMerge
+	default:
+		warnings = append(warnings, Diagnostic{
+			File:     s.File,
+			Severity: SeverityWarning,
+			Code:     CodeStyle,
+			Message:  fmt.Sprintf("unknown arrow merge '%s' in frontmatter (using the default)", frontmatter.ArrowMerge),
+		})
+	}
+
+	// Layout: convert logical spec to concrete diagram with pixel coordinates
+	diagram, boxData := layout(s.Diagram, config, frontmatter.Legend, s.Diagram.Groups, frontmatter.ArrowFlow)
+	for i := range diagram.Diagnostics {
+		diagram.Diagnostics[i].File = s.File
+	}
+	warnings = append(warnings, diagram.Diagnostics...)
+
+	// Set presentation details
+	diagram.YAxisLabel = frontmatter.YLabel
+	diagram.XAxisLabel = frontmatter.XLabel
+	diagram.Font = opts.Font
+	diagram.Legend = frontmatter.Legend
+	diagram.CustomColors = frontmatter.Colors
+
+	return diagram, boxData, warnings
+}
@@ -0,0 +1,167 @@
+package control
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse_FrontmatterAndBody(t *testing.T) {
+	text := "---\nx-label: Time\ncolor: blue = #3B82F6\n---\nA: 1,1: Plan, blue\nB: >+2,0: Ship\n"
+	spec, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if spec.Frontmatter.XLabel != "Time" {
+		t.Errorf("Expected x-label 'Time', got %q", spec.Frontmatter.XLabel)
+	}
+	if len(spec.Diagram.Boxes) != 2 || len(spec.Diagram.Arrows) != 1 {
+		t.Fatalf("Expected 2 boxes and 1 arrow, got %d and %d", len(spec.Diagram.Boxes), len(spec.Diagram.Arrows))
+	}
+	if spec.Diagram.Boxes[0].Color != "#3B82F6" {
+		t.Errorf("Expected the custom color on box A, got %q", spec.Diagram.Boxes[0].Color)
+	}
+}
+
+func TestParse_ErrorsPositionedInFile(t *testing.T) {
+	text := "---\nx-label: Time\n---\nA: 1,x: Plan\n"
+	_, err := ParseWithOptions(strings.NewReader(text), ParseOptions{File: "plan.txt"})
+	var diags Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 1 {
+		t.Fatalf("Expected one diagnostic, got %v", err)
+	}
+	if diags[0].File != "plan.txt" || diags[0].Line != 4 {
+		t.Errorf("Expected the error at plan.txt:4, got %s:%d", diags[0].File, diags[0].Line)
+	}
+}
+
+func TestRender_MatchesLayout(t *testing.T) {
+	spec, err := Parse(strings.NewReader("---\narrowhead: star\n---\nA: 1,1: Plan\nB: >+2,0: Ship\nB -> A: rework\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var svg strings.Builder
+	warnings, err := Render(spec, DefaultOptions(), &svg)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "unknown arrowhead 'star'") {
+		t.Errorf("Expected the frontmatter warning, got %v", warnings)
+	}
+
+	// The spec is left untouched, so it renders the same again
+	diagram, _, _ := spec.layout(DefaultOptions())
+	if got := diagram.GenerateSVG(); got != svg.String() {
+		t.Error("Rendering the spec a second time produced different SVG")
+	}
+}
+
+func TestRender_ZeroOptions(t *testing.T) {
+	spec, err := Parse(strings.NewReader("A: 1,1: Plan\nB: >+2,0: Ship\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	diagram, _, _ := spec.layout(Options{})
+	stretched, _, _ := spec.layout(Options{Stretch: 1.0})
+	if diagram.Width != stretched.Width {
+		t.Errorf("Expected a zero stretch to mean 1.0, got width %d instead of %d", diagram.Width, stretched.Width)
+	}
+}
+
+func TestBuilder_MatchesParse(t *testing.T) {
+	text := `---
+x-label: Time
+color: blue = #3B82F6
+---
+@Team: Our Team
+A: 1,1: Plan, blue @Team
+B: 4,1,3,2: Build, g-rb @Team
+C: 4,4: Ship
+A -> B: starts | dashed
+B.bottom -> C.top
+C <-> A
+A.top <- C
+`
+	parsed, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	b := NewBuilder()
+	b.Frontmatter.XLabel = "Time"
+	b.Color("blue", "#3B82F6")
+	b.GroupLabel("Team", "Our Team")
+	b.Box("A", 1, 1, "Plan").Style("blue").Group("Team")
+	b.Box("B", 4, 1, "Build").Size(3, 2).Style("g-rb").Group("Team")
+	b.Box("C", 4, 4, "Ship")
+	b.Arrow("A", "B").Label("starts").Options("dashed")
+	b.Arrow("B", "C").Ports(SideBottom, SideTop)
+	b.Arrow("C", "A").Connector(ConnectorBidirectional)
+	b.Arrow("A", "C").Ports(SideTop, "").Connector("<-")
+	built, err := b.Spec()
+	if err != nil {
+		t.Fatalf("Spec failed: %v", err)
+	}
+
+	var want, got strings.Builder
+	if _, err := Render(parsed, DefaultOptions(), &want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Render(built, DefaultOptions(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Error("Built diagram renders differently from the parsed one")
+	}
+}
+
+func TestBuilder_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder)
+		code  string
+	}{
+		{"invalid ID", func(b *Builder) { b.Box("a b", 1, 1, "A") }, CodeInvalidID},
+		{"duplicate ID", func(b *Builder) { b.Box("A", 1, 1, "A"); b.Box("A", 3, 1, "B") }, CodeInvalidID},
+		{"position", func(b *Builder) { b.Box("A", 0, 1, "A") }, CodeCoordinate},
+		{"size", func(b *Builder) { b.Box("A", 1, 1, "A").Size(0.1, 1) }, CodeSize},
+		{"missing box", func(b *Builder) { b.Box("A", 1, 1, "A"); b.Arrow("A", "B") }, CodeArrowReference},
+		{"port", func(b *Builder) { b.Box("A", 1, 1, "A"); b.Box("B", 3, 1, "B"); b.Arrow("A", "B").Ports("middle", "") }, CodeArrowReference},
+		{"connector", func(b *Builder) { b.Box("A", 1, 1, "A"); b.Box("B", 3, 1, "B"); b.Arrow("A", "B").Connector("=>") }, CodeArrowReference},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder()
+			tt.build(b)
+			_, err := b.Spec()
+			var diags Diagnostics
+			if !errors.As(err, &diags) || len(diags) != 1 {
+				t.Fatalf("Expected one diagnostic, got %v", err)
+			}
+			if diags[0].Code != tt.code {
+				t.Errorf("Expected code %q, got %q (%s)", tt.code, diags[0].Code, diags[0].Message)
+			}
+		})
+	}
+}
+
+func TestBuilder_Warnings(t *testing.T) {
+	b := NewBuilder()
+	b.Box("A", 1, 1, "A").Style("g-shiny")
+	b.Box("B", 3, 1, "B")
+	b.Arrow("A", "B").Options("wobbly")
+	b.Color("accent", "blu")
+	spec, err := b.Spec()
+	if err != nil {
+		t.Fatalf("Spec failed: %v", err)
+	}
+	if len(spec.Diagram.Diagnostics) != 3 {
+		t.Fatalf("Expected 3 warnings, got %v", spec.Diagram.Diagnostics)
+	}
+	if msg := spec.Diagram.Diagnostics[0].Message; msg != "custom color 'accent': 'blu' is not a hex color or color name (ignored)" {
+		t.Errorf("Expected a warning about the custom color, got %q", msg)
+	}
+	if spec.Diagram.Boxes[0].Color != "#D3D3D3" {
+		t.Errorf("Expected the known style code to apply, got color %q", spec.Diagram.Boxes[0].Color)
+	}
+}
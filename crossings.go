@@ -0,0 +1,102 @@
+package control
+
+import "slices"
+
+// hopRadius is the radius of the semicircular bridge drawn at arrow crossings
+const hopRadius = 6
+
+// markCrossings records on each arrow the points where it crosses an earlier
+// arrow, and returns the total number of crossings. Only a horizontal and a
+// vertical segment crossing in their interiors count: arrows merely touching,
+// e.g. at a shared box side, do not cross.
+func markCrossings(arrows []arrow) int {
+	routes := make([][]int, len(arrows))
+	bounds := make([]boxCoords, len(arrows))
+	for i := range arrows {
+		routes[i] = arrows[i].route()
+		bounds[i] = routeBounds(routes[i])
+	}
+
+	// Earlier routes by the cells of a uniform grid their bounds touch, so
+	// each route is only compared with the routes near it
+	cells := make(map[[2]int][]int)
+	seen := make([]int, len(arrows)) // Last route, plus one, that looked at each route
+	var near []int
+	total := 0
+	for i := range arrows {
+		arrows[i].Crossings = nil
+		near = near[:0]
+		b := bounds[i]
+		for cx := obstacleCell(b.X1); cx <= obstacleCell(b.X2); cx++ {
+			for cy := obstacleCell(b.Y1); cy <= obstacleCell(b.Y2); cy++ {
+				for _, j := range cells[[2]int{cx, cy}] {
+					if seen[j] != i+1 {
+						seen[j] = i + 1
+						near = append(near, j)
+					}
+				}
+				cells[[2]int{cx, cy}] = append(cells[[2]int{cx, cy}], i)
+			}
+		}
+		slices.Sort(near) // Crossings in the order of the earlier arrows
+		for _, j := range near {
+			a := bounds[j]
+			if a.X2 <= b.X1 || b.X2 <= a.X1 || a.Y2 <= b.Y1 || b.Y2 <= a.Y1 {
+				continue // Routes apart cannot cross
+			}
+			points := routeCrossings(routes[i], routes[j])
+			arrows[i].Crossings = append(arrows[i].Crossings, points...)
+			total += len(points) / 2
+		}
+	}
+	return total
+}
+
+// routeBounds returns the bounding box of a route
+func routeBounds(points []int) boxCoords {
+	if len(points) < 2 {
+		return boxCoords{}
+	}
+	bounds := boxCoords{X1: points[0], Y1: points[1], X2: points[0], Y2: points[1]}
+	for i := 2; i+1 < len(points); i += 2 {
+		bounds.X1, bounds.X2 = min(bounds.X1, points[i]), max(bounds.X2, points[i])
+		bounds.Y1, bounds.Y2 = min(bounds.Y1, points[i+1]), max(bounds.Y2, points[i+1])
+	}
+	return bounds
+}
+
+// routeCrossings returns the points where route a crosses route b, in the order along a
+func routeCrossings(a, b []int) []int {
+	var points []int
+	for i := 0; i+3 < len(a); i += 2 {
+		ax1, ay1, ax2, ay2 := a[i], a[i+1], a[i+2], a[i+3]
+		for j := 0; j+3 < len(b); j += 2 {
+			bx1, by1, bx2, by2 := b[j], b[j+1], b[j+2], b[j+3]
+			if x, y, ok := segmentCrossing(ax1, ay1, ax2, ay2, bx1, by1, bx2, by2); ok {
+				points = append(points, x, y)
+			}
+		}
+	}
+	return points
+}
+
+// segmentCrossing returns where a horizontal and a vertical segment cross
+// strictly inside both of them
+func segmentCrossing(ax1, ay1, ax2, ay2, bx1, by1, bx2, by2 int) (x, y int, ok bool) {
+	if ay1 == ay2 && bx1 == bx2 && ax1 != ax2 && by1 != by2 {
+		// a horizontal, b vertical
+		x, y = bx1, ay1
+		return x, y, between(x, ax1, ax2) && between(y, by1, by2)
+	}
+	if ax1 == ax2 && by1 == by2 && ay1 != ay2 && bx1 != bx2 {
+		// a vertical, b horizontal
+		x, y = ax1, by1
+		return x, y, between(y, ay1, ay2) && between(x, bx1, bx2)
+	}
+	return 0, 0, false
+}
+
+// between reports whether v lies strictly between a and b
+func between(v, a, b int) bool {
+	return (a < v && v < b) || (b < v && v < a)
+}
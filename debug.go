@@ -1,26 +1,30 @@
-package main
+package control
 
 import (
 	"encoding/json"
-	"os"
+	"io"
+	"maps"
+	"slices"
 )
 
-// DebugOutput represents the complete debug information for a diagram
-type DebugOutput struct {
-	Diagram DiagramInfo  `json:"diagram"`
-	Boxes   []BoxDebug   `json:"boxes"`
-	Arrows  []ArrowDebug `json:"arrows"`
-	Groups  []GroupDebug `json:"groups,omitempty"`
+// debugOutput represents the complete debug information for a diagram
+type debugOutput struct {
+	Diagram    diagramInfo      `json:"diagram"`
+	Boxes      []boxDebug       `json:"boxes"`
+	Arrows     []arrowDebug     `json:"arrows"`
+	Groups     []groupDebug     `json:"groups,omitempty"`
+	Containers []containerDebug `json:"containers,omitempty"`
 }
 
-// DiagramInfo contains overall diagram dimensions
-type DiagramInfo struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
+// diagramInfo contains overall diagram dimensions
+type diagramInfo struct {
+	Width     int `json:"width"`
+	Height    int `json:"height"`
+	Crossings int `json:"crossings"` // Number of points where two arrows cross
 }
 
-// BoxDebug contains debug information for a single box
-type BoxDebug struct {
+// boxDebug contains debug information for a single box
+type boxDebug struct {
 	ID     string `json:"id"`
 	X      int    `json:"x"`
 	Y      int    `json:"y"`
@@ -32,8 +36,8 @@ type BoxDebug struct {
 	Color  string `json:"color"`
 }
 
-// GroupDebug contains debug information for a single group
-type GroupDebug struct {
+// groupDebug contains debug information for a single group
+type groupDebug struct {
 	Label  string   `json:"label"`
 	X      int      `json:"x"`
 	Y      int      `json:"y"`
@@ -42,23 +46,41 @@ type GroupDebug struct {
 	BoxIDs []string `json:"boxIds"`
 }
 
-// ArrowDebug contains debug information for a single arrow
-type ArrowDebug struct {
-	FromBox              string           `json:"fromBox"`
-	ToBox                string           `json:"toBox"`
-	StartX               int              `json:"startX"`
-	StartY               int              `json:"startY"`
-	EndX                 int              `json:"endX"`
-	EndY                 int              `json:"endY"`
-	RoutingStrategy      string           `json:"routingStrategy"`
-	ArrowType            string           `json:"arrowType"`
-	ArrowheadOrientation string           `json:"arrowheadOrientation"`
-	VerticalFirst        bool             `json:"verticalFirst"`
-	Candidates           []CandidateDebug `json:"candidates,omitempty"`
+// containerDebug contains debug information for a single container
+type containerDebug struct {
+	ID     string   `json:"id"`
+	Label  string   `json:"label,omitempty"`
+	Framed bool     `json:"framed"`
+	X      int      `json:"x"`
+	Y      int      `json:"y"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	BoxIDs []string `json:"boxIds"`
+}
+
+// arrowDebug contains debug information for a single arrow
+type arrowDebug struct {
+	FromBox                   string           `json:"fromBox"`
+	ToBox                     string           `json:"toBox"`
+	StartX                    int              `json:"startX"`
+	StartY                    int              `json:"startY"`
+	EndX                      int              `json:"endX"`
+	EndY                      int              `json:"endY"`
+	RoutingStrategy           string           `json:"routingStrategy"`
+	ArrowType                 string           `json:"arrowType"`
+	ArrowheadOrientation      string           `json:"arrowheadOrientation"`      // Direction of the end arrowhead, "none" without one
+	StartArrowheadOrientation string           `json:"startArrowheadOrientation"` // Direction of the start arrowhead, "none" without one
+	VerticalFirst             bool             `json:"verticalFirst"`
+	Label                     string           `json:"label,omitempty"`
+	LabelX                    int              `json:"labelX,omitempty"`
+	LabelY                    int              `json:"labelY,omitempty"`
+	Crossings                 int              `json:"crossings,omitempty"` // Crossings with earlier arrows
+	Merged                    bool             `json:"merged,omitempty"`    // Shares a trunk with other arrows
+	Candidates                []candidateDebug `json:"candidates,omitempty"`
 }
 
-// CandidateDebug contains debug information for a candidate routing strategy
-type CandidateDebug struct {
+// candidateDebug contains debug information for a candidate routing strategy
+type candidateDebug struct {
 	Strategy      string `json:"strategy"`
 	Score         int    `json:"score"`
 	StartX        int    `json:"startX"`
@@ -71,32 +93,35 @@ type CandidateDebug struct {
 	RejectReason  string `json:"rejectReason,omitempty"`
 }
 
-// GenerateDebugOutput creates a DebugOutput from a diagram and box data
-func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutput {
-	output := DebugOutput{
-		Diagram: DiagramInfo{
-			Width:  diagram.Width,
-			Height: diagram.Height,
+// generateDebugOutput creates a debugOutput from a diagram and box data
+func generateDebugOutput(diagram *diagram, boxData map[string]placedBox) debugOutput {
+	output := debugOutput{
+		Diagram: diagramInfo{
+			Width:     diagram.Width,
+			Height:    diagram.Height,
+			Crossings: diagram.Crossings,
 		},
-		Boxes:  make([]BoxDebug, 0, len(diagram.Boxes)),
-		Arrows: make([]ArrowDebug, 0, len(diagram.Arrows)),
+		Boxes:  make([]boxDebug, 0, len(diagram.Boxes)),
+		Arrows: make([]arrowDebug, 0, len(diagram.Arrows)),
 	}
 
+	// Box IDs in sorted order, so boxes sharing a position match the same ID on every run
+	ids := slices.Sorted(maps.Keys(boxData))
+
 	// Collect box debug information
 	for _, box := range diagram.Boxes {
-		// Find corresponding BoxData for grid coordinates
+		// Find corresponding placedBox for grid coordinates
 		var gridX, gridY int
-		for id, data := range boxData {
+		for _, id := range ids {
 			// Match by position (best effort - boxes don't store their ID)
-			if data.PixelX == box.X && data.PixelY == box.Y {
+			if data := boxData[id]; data.PixelX == box.X && data.PixelY == box.Y {
 				gridX = data.GridX
 				gridY = data.GridY
-				_ = id // boxID
 				break
 			}
 		}
 
-		output.Boxes = append(output.Boxes, BoxDebug{
+		output.Boxes = append(output.Boxes, boxDebug{
 			ID:     "", // Will be filled from arrow references
 			X:      box.X,
 			Y:      box.Y,
@@ -112,19 +137,31 @@ func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutp
 	// Collect arrow debug information
 	for _, arrow := range diagram.Arrows {
 		arrowType := classifyArrowType(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.NumSegments)
-		orientation := calculateArrowheadOrientation(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.NumSegments, arrow.VerticalFirst)
+		orientation := "none"
+		if arrow.EndHead {
+			orientation = calculateArrowheadOrientation(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.NumSegments, arrow.VerticalFirst)
+		}
+		startOrientation := "none"
+		if arrow.StartHead {
+			startOrientation = calculateStartArrowheadOrientation(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.VerticalFirst)
+		}
+		if arrow.freeform() {
+			// Free-form routes: heads follow the first and last segments
+			arrowType = "polyline"
+			if arrow.EndHead {
+				orientation = sideOrientation(oppositeSide(entrySide(arrow.Points)))
+			}
+			if arrow.StartHead {
+				startOrientation = sideOrientation(oppositeSide(exitSide(arrow.Points)))
+			}
+		}
 
-		// Convert RouteCandidate to CandidateDebug
-		candidatesDebug := make([]CandidateDebug, 0, len(arrow.Candidates))
+		// Convert routeCandidate to candidateDebug
+		candidatesDebug := make([]candidateDebug, 0, len(arrow.Candidates))
 		for _, candidate := range arrow.Candidates {
-			// Determine if this candidate was selected (matches the arrow's final route)
-			selected := candidate.startX == arrow.FromX &&
-				candidate.startY == arrow.FromY &&
-				candidate.endX == arrow.ToX &&
-				candidate.endY == arrow.ToY &&
-				candidate.strategy == arrow.RoutingStrategy
+			selected := candidate.selected
 
-			candidatesDebug = append(candidatesDebug, CandidateDebug{
+			candidatesDebug = append(candidatesDebug, candidateDebug{
 				Strategy:      candidate.strategy,
 				Score:         candidate.score,
 				StartX:        candidate.startX,
@@ -138,18 +175,24 @@ func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutp
 			})
 		}
 
-		output.Arrows = append(output.Arrows, ArrowDebug{
-			FromBox:              arrow.FromBoxID,
-			ToBox:                arrow.ToBoxID,
-			StartX:               arrow.FromX,
-			StartY:               arrow.FromY,
-			EndX:                 arrow.ToX,
-			EndY:                 arrow.ToY,
-			RoutingStrategy:      arrow.RoutingStrategy,
-			ArrowType:            arrowType,
-			ArrowheadOrientation: orientation,
-			VerticalFirst:        arrow.VerticalFirst,
-			Candidates:           candidatesDebug,
+		output.Arrows = append(output.Arrows, arrowDebug{
+			FromBox:                   arrow.FromBoxID,
+			ToBox:                     arrow.ToBoxID,
+			StartX:                    arrow.FromX,
+			StartY:                    arrow.FromY,
+			EndX:                      arrow.ToX,
+			EndY:                      arrow.ToY,
+			RoutingStrategy:           arrow.RoutingStrategy,
+			ArrowType:                 arrowType,
+			ArrowheadOrientation:      orientation,
+			StartArrowheadOrientation: startOrientation,
+			VerticalFirst:             arrow.VerticalFirst,
+			Label:                     arrow.Label,
+			LabelX:                    arrow.LabelX,
+			LabelY:                    arrow.LabelY,
+			Crossings:                 len(arrow.Crossings) / 2,
+			Merged:                    arrow.Merged,
+			Candidates:                candidatesDebug,
 		})
 	}
 
@@ -166,8 +209,8 @@ func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutp
 
 	// Update box IDs (match by grid position from boxData)
 	for i := range output.Boxes {
-		for id, data := range boxData {
-			if data.PixelX == output.Boxes[i].X && data.PixelY == output.Boxes[i].Y {
+		for _, id := range ids {
+			if data := boxData[id]; data.PixelX == output.Boxes[i].X && data.PixelY == output.Boxes[i].Y {
 				output.Boxes[i].ID = id
 				break
 			}
@@ -176,7 +219,7 @@ func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutp
 
 	// Collect group debug information
 	for _, group := range diagram.Groups {
-		output.Groups = append(output.Groups, GroupDebug{
+		output.Groups = append(output.Groups, groupDebug{
 			Label:  group.Label,
 			X:      group.X,
 			Y:      group.Y,
@@ -186,6 +229,20 @@ func GenerateDebugOutput(diagram *Diagram, boxData map[string]BoxData) DebugOutp
 		})
 	}
 
+	// Collect container debug information
+	for _, container := range diagram.Containers {
+		output.Containers = append(output.Containers, containerDebug{
+			ID:     container.ID,
+			Label:  container.Label,
+			Framed: container.Framed,
+			X:      container.X,
+			Y:      container.Y,
+			Width:  container.Width,
+			Height: container.Height,
+			BoxIDs: container.BoxIDs,
+		})
+	}
+
 	return output
 }
 
@@ -250,11 +307,39 @@ func calculateArrowheadOrientation(fromX, fromY, toX, toY int, numSegments int,
 	return "left"
 }
 
-// WriteDebugJSON writes debug output to a JSON file
-func WriteDebugJSON(filename string, output DebugOutput) error {
+// calculateStartArrowheadOrientation determines which direction the start arrowhead
+// points: away from the line, opposite to the direction of the first segment
+func calculateStartArrowheadOrientation(fromX, fromY, toX, toY int, verticalFirst bool) string {
+	// Straight vertical arrows and vertical-first bends start vertically
+	if fromX == toX || (fromY != toY && verticalFirst) {
+		if toY > fromY {
+			return "up"
+		}
+		return "down"
+	}
+	if toX > fromX {
+		return "left"
+	}
+	return "right"
+}
+
+// sideOrientation names the arrowhead orientation pointing towards a box side
+func sideOrientation(side string) string {
+	switch side {
+	case SideTop:
+		return "up"
+	case SideBottom:
+		return "down"
+	}
+	return side
+}
+
+// writeDebugJSON writes debug output as indented JSON
+func writeDebugJSON(w io.Writer, output debugOutput) error {
 	data, err := json.MarshalIndent(output, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0600)
+	_, err = w.Write(data)
+	return err
 }
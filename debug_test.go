@@ -0,0 +1,57 @@
+package control
+
+import "testing"
+
+func TestCalculateStartArrowheadOrientation(t *testing.T) {
+	tests := []struct {
+		name                   string
+		fromX, fromY, toX, toY int
+		verticalFirst          bool
+		want                   string
+	}{
+		{"straight right", 10, 50, 200, 50, false, "left"},
+		{"straight left", 200, 50, 10, 50, false, "right"},
+		{"straight down", 50, 10, 50, 200, true, "up"},
+		{"straight up", 50, 200, 50, 10, true, "down"},
+		{"horizontal first", 10, 10, 200, 200, false, "left"},
+		{"vertical first", 10, 10, 200, 200, true, "up"},
+		{"vertical first upwards", 10, 200, 200, 10, true, "down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateStartArrowheadOrientation(tt.fromX, tt.fromY, tt.toX, tt.toY, tt.verticalFirst)
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateDebugOutput_ArrowheadEnds(t *testing.T) {
+	text := `
+a: 1,1: A
+b: 4,1: B
+c: 4,3: C
+a <-> b
+b -- c
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, boxData := layout(spec, newDefaultConfig(), nil, nil, "")
+	output := generateDebugOutput(diagram, boxData)
+	if len(output.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(output.Arrows))
+	}
+
+	both := output.Arrows[0]
+	if both.ArrowheadOrientation != "right" || both.StartArrowheadOrientation != "left" {
+		t.Errorf("Expected a <-> b heads right/left, got end=%q start=%q", both.ArrowheadOrientation, both.StartArrowheadOrientation)
+	}
+	none := output.Arrows[1]
+	if none.ArrowheadOrientation != "none" || none.StartArrowheadOrientation != "none" {
+		t.Errorf("Expected b -- c without heads, got end=%q start=%q", none.ArrowheadOrientation, none.StartArrowheadOrientation)
+	}
+}
@@ -0,0 +1,123 @@
+package control
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Severity classifies how serious a Diagnostic is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic codes identify the kind of problem independently of the message text
+const (
+	CodeBoxSyntax      = "box-syntax"      // Malformed box definition
+	CodeInvalidID      = "invalid-id"      // Box ID contains illegal characters or is empty
+	CodeCoordinate     = "coordinate"      // Unparseable or out-of-range grid coordinate
+	CodeSize           = "size"            // Invalid box width or height
+	CodePrefix         = "prefix"          // Misused ">" or "|" prefix
+	CodeContainer      = "container"       // Malformed, unclosed or unexpected container
+	CodeArrowReference = "arrow-reference" // Arrow references a missing or unlabeled box
+	CodeStyle          = "style"           // Unknown style code
+	CodeRouting        = "routing"         // Arrow could not be routed around boxes
+	CodeTooManyErrors  = "too-many-errors" // Error limit reached, parsing stopped
+)
+
+// Diagnostic describes a problem in a diagram file, positioned at its original
+// line and column so it can be reported in compiler style
+type Diagnostic struct {
+	File     string   // Source file name (empty if unknown)
+	Line     int      // 1-based line in the original file (0 = unknown)
+	Column   int      // 1-based column in the original line (0 = unknown)
+	Severity Severity // SeverityError or SeverityWarning
+	Code     string   // Machine-readable problem identifier (e.g., "coordinate")
+	Message  string   // Human-readable description
+}
+
+// Error implements the error interface using the compiler-style format
+func (d Diagnostic) Error() string {
+	return d.String()
+}
+
+// String formats the diagnostic as "file:line:col: message".
+// Warnings carry a "warning: " prefix before the message; unknown parts are omitted.
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File)
+		b.WriteString(":")
+	}
+	if d.Line > 0 {
+		fmt.Fprintf(&b, "%d:", d.Line)
+		if d.Column > 0 {
+			fmt.Fprintf(&b, "%d:", d.Column)
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if d.Severity == SeverityWarning {
+		b.WriteString("warning: ")
+	}
+	b.WriteString(d.Message)
+	return b.String()
+}
+
+// Diagnostics is a list of diagnostics that can be returned as a single error.
+// errors.As finds the first Diagnostic in the list.
+type Diagnostics []Diagnostic
+
+// Error joins all diagnostics, one per line
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap exposes the individual diagnostics to errors.Is and errors.As
+func (ds Diagnostics) Unwrap() []error {
+	errs := make([]error, len(ds))
+	for i, d := range ds {
+		errs[i] = d
+	}
+	return errs
+}
+
+// HasErrors reports whether any diagnostic has error severity
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByPosition orders diagnostics by line and column; unpositioned ones go last
+func (ds Diagnostics) sortByPosition() {
+	sort.SliceStable(ds, func(i, j int) bool {
+		a, b := ds[i], ds[j]
+		if (a.Line == 0) != (b.Line == 0) {
+			return b.Line == 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// trimOffset trims the blanks around a field of a line and returns it with
+// the number of leading bytes trimmed, to keep track of the field's column
+func trimOffset(s string) (string, int) {
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	return strings.TrimRightFunc(trimmed, unicode.IsSpace), len(s) - len(trimmed)
+}
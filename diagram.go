@@ -1,12 +1,13 @@
-package main
+package control
 
 import (
-	"fmt"
+	"io"
+	"slices"
 	"strings"
 )
 
-// Box represents a rectangular box in the diagram
-type Box struct {
+// box represents a rectangular box in the diagram
+type box struct {
 	X, Y          int
 	Width, Height int
 	Text          string
@@ -18,8 +19,8 @@ type Box struct {
 	TextLines     []string
 }
 
-// Arrow represents a connection between boxes
-type Arrow struct {
+// arrow represents a connection between boxes
+type arrow struct {
 	FromX, FromY    int
 	ToX, ToY        int
 	VerticalFirst   bool             // If true, use 2-segment routing (vertical then horizontal)
@@ -27,23 +28,48 @@ type Arrow struct {
 	FromBoxID       string           // ID of source box (for debug output)
 	ToBoxID         string           // ID of destination box (for debug output)
 	RoutingStrategy string           // Name of routing strategy used (for debug output)
-	Candidates      []RouteCandidate // All routing candidates considered (for debug output)
+	Candidates      []routeCandidate // All routing candidates considered (for debug output)
+	StartHead       bool             // Arrowhead at the start point ("<->")
+	EndHead         bool             // Arrowhead at the end point (false for "--")
+	Color           string           // Stroke color ("" = black)
+	StrokeWidth     int              // Stroke width (0 = default)
+	Dash            string           // ArrowDashed, ArrowDotted or "" for solid
+	Head            string           // Arrowhead shape ("" = HeadChevron)
+	Shape           string           // Line shape: ArrowRounded, ArrowCurved or "" for sharp bends
+	Merge           bool             // May share a trunk with arrows from the same source or to the same target
+	Merged          bool             // Shares a trunk with other arrows (set by mergeBuses)
+	Label           string           // Optional text drawn on the arrow
+	LabelX, LabelY  int              // Center of the label
+	Crossings       []int            // Points [x0,y0,...] where the arrow crosses earlier arrows
+	Points          []int            // Route polyline [x0,y0,x1,y1,...]; drawn as is when freeform
 }
 
-// Group represents a visual grouping rectangle around boxes
-type Group struct {
+// group represents a visual grouping rectangle around boxes
+type group struct {
 	X, Y          int
 	Width, Height int
 	Label         string
 	BoxIDs        []string // IDs of boxes in this group (for debug output)
 }
 
-// Diagram represents the entire workflow diagram
-type Diagram struct {
+// container represents the frame around a container's boxes
+type container struct {
+	X, Y          int
+	Width, Height int
+	ID            string // Fully scoped container ID (for debug output)
+	Label         string
+	Framed        bool // Only framed containers are drawn
+	Styles        ContainerStyles
+	BoxIDs        []string // IDs of boxes in this container, including nested ones (for debug output)
+}
+
+// diagram represents the entire workflow diagram
+type diagram struct {
 	Width, Height int
-	Boxes         []Box
-	Arrows        []Arrow
-	Groups        []Group
+	Boxes         []box
+	Arrows        []arrow
+	Groups        []group
+	Containers    []container // Parents precede their nested containers
 	YAxisLabel    string
 	XAxisLabel    string
 	ZoneLabel1    string
@@ -52,10 +78,14 @@ type Diagram struct {
 	Font          *FontData         // Optional custom font
 	Legend        []LegendEntry     // Optional legend entries
 	CustomColors  map[string]string // Custom color definitions (name -> hex)
+	Diagnostics   Diagnostics       // Layout warnings (e.g., arrows drawn across boxes)
+	Crossings     int               // Number of arrow crossings
+	HopCrossings  bool              // Draw bridges where arrows cross earlier ones
+	Junctions     []junction        // Dots where merged arrows split
 }
 
-// DiagramConfig holds diagram-wide rendering settings
-type DiagramConfig struct {
+// diagramConfig holds diagram-wide rendering settings
+type diagramConfig struct {
 	GridUnit         int     // Pixels per grid unit
 	BoxWidthUnits    float64 // Box width in grid units
 	GapUnits         float64 // Gap in grid units (horizontal)
@@ -63,11 +93,25 @@ type DiagramConfig struct {
 	AxisOffset       int     // Offset between axes and content
 	DefaultColor     string  // Default box color
 	Stretch          float64 // X-axis stretch factor (1.0 = normal)
+	ArrowHead        string  // Default arrowhead shape for arrows without their own ("" = chevron)
+	Crossings        string  // CrossingsHop draws bridges where arrows cross ("" = plain crossings)
+	ArrowShape       string  // Default line shape for arrows without their own ("" = sharp)
+	Merge            string  // MergeBus or MergeNone ("" = bus for arrows with flow down)
+	YAxis            bool    // The y-axis is drawn; loop lanes keep clear of it
 }
 
-// NewDefaultConfig returns default diagram configuration
-func NewDefaultConfig() DiagramConfig {
-	return DiagramConfig{
+// axisX is the x position of the y-axis; the content starts AxisOffset right of it
+const axisX = 60
+
+// Crossing styles (diagramConfig.Crossings)
+const (
+	CrossingsNone = "none" // Arrows simply cross (default)
+	CrossingsHop  = "hop"  // The later arrow jumps over the earlier one
+)
+
+// newDefaultConfig returns default diagram configuration
+func newDefaultConfig() diagramConfig {
+	return diagramConfig{
 		GridUnit:         100,
 		BoxWidthUnits:    2.5,
 		GapUnits:         0.5,
@@ -78,20 +122,20 @@ func NewDefaultConfig() DiagramConfig {
 	}
 }
 
-// NewDiagram creates a new diagram with default settings
-func NewDiagram(width, height int) *Diagram {
-	return &Diagram{
+// newDiagram creates a new diagram with default settings
+func newDiagram(width, height int) *diagram {
+	return &diagram{
 		Width:  width,
 		Height: height,
-		Boxes:  []Box{},
-		Arrows: []Arrow{},
+		Boxes:  []box{},
+		Arrows: []arrow{},
 	}
 }
 
 // AddBox adds a box to the diagram
-func (d *Diagram) AddBox(x, y, w, h int, text, color, borderColor string, borderWidth, fontSize int, textColor string) {
+func (d *diagram) AddBox(x, y, w, h int, text, color, borderColor string, borderWidth, fontSize int, textColor string) {
 	lines := strings.Split(text, "\n")
-	d.Boxes = append(d.Boxes, Box{
+	d.Boxes = append(d.Boxes, box{
 		X:           x,
 		Y:           y,
 		Width:       w,
@@ -106,9 +150,9 @@ func (d *Diagram) AddBox(x, y, w, h int, text, color, borderColor string, border
 	})
 }
 
-// AddArrow adds an arrow between two points
-func (d *Diagram) AddArrow(fromX, fromY, toX, toY int, verticalFirst bool, numSegments int, fromID, toID, routingStrategy string, candidates []RouteCandidate) {
-	d.Arrows = append(d.Arrows, Arrow{
+// AddArrow adds an arrow between two points and returns it for further settings
+func (d *diagram) AddArrow(fromX, fromY, toX, toY int, verticalFirst bool, numSegments int, fromID, toID, routingStrategy string, candidates []routeCandidate) *arrow {
+	d.Arrows = append(d.Arrows, arrow{
 		FromX:           fromX,
 		FromY:           fromY,
 		ToX:             toX,
@@ -119,11 +163,13 @@ func (d *Diagram) AddArrow(fromX, fromY, toX, toY int, verticalFirst bool, numSe
 		ToBoxID:         toID,
 		RoutingStrategy: routingStrategy,
 		Candidates:      candidates,
+		EndHead:         true,
 	})
+	return &d.Arrows[len(d.Arrows)-1]
 }
 
 // SetLabels sets axis and zone labels
-func (d *Diagram) SetLabels(yAxis, xAxis, zone1, zone2 string, zoneSplit int) {
+func (d *diagram) SetLabels(yAxis, xAxis, zone1, zone2 string, zoneSplit int) {
 	d.YAxisLabel = yAxis
 	d.XAxisLabel = xAxis
 	d.ZoneLabel1 = zone1
@@ -131,85 +177,182 @@ func (d *Diagram) SetLabels(yAxis, xAxis, zone1, zone2 string, zoneSplit int) {
 	d.ZoneSplit = zoneSplit
 }
 
-// GenerateSVG creates the SVG output
-func (d *Diagram) GenerateSVG() string {
+// GenerateSVG creates the SVG output as a string (see WriteSVG)
+func (d *diagram) GenerateSVG() string {
 	var svg strings.Builder
+	_ = d.WriteSVG(&svg) // Writing to a strings.Builder cannot fail
+	return svg.String()
+}
+
+// WriteSVG streams the SVG output to w element by element, without building
+// the whole document in memory. Returns the first error writing to w.
+func (d *diagram) WriteSVG(w io.Writer) error {
+	svg := newSVGWriter(w)
 
 	// SVG header with arrowhead marker definition
-	svg.WriteString(svgHeader(d.Width, d.Height, d.Font))
+	writeSVGHeader(svg, d.Width, d.Height, d.Font, d.arrowMarkers())
 
 	// Draw Y-axis if y-label is set
 	if d.YAxisLabel != "" {
-		svg.WriteString(drawLine(60, d.Height-50, 60, 50, false, true))
+		drawLine(svg, axisX, d.Height-50, axisX, 50, false, true)
 		attrs := map[string]string{
 			"transform":   "rotate(-90 30 30)",
 			"text-anchor": "end",
 		}
-		svg.WriteString(drawText(30, 30, d.YAxisLabel, 18, attrs, d.Font))
+		drawText(svg, 30, 30, d.YAxisLabel, 18, attrs, d.Font)
 	}
 
 	// Draw X-axis if x-label is set
 	if d.XAxisLabel != "" {
-		svg.WriteString(drawLine(60, d.Height-50, d.Width-20, d.Height-50, false, true))
+		drawLine(svg, axisX, d.Height-50, d.Width-20, d.Height-50, false, true)
 		attrs := map[string]string{
 			"text-anchor": "end",
 		}
-		svg.WriteString(drawText(d.Width-30, d.Height-20, d.XAxisLabel, 18, attrs, d.Font))
+		drawText(svg, d.Width-30, d.Height-20, d.XAxisLabel, 18, attrs, d.Font)
 	}
 
 	// Draw zone split line if specified
 	if d.ZoneSplit > 0 {
-		svg.WriteString(drawLine(60, d.ZoneSplit, d.Width-20, d.ZoneSplit, true, false))
+		drawLine(svg, 60, d.ZoneSplit, d.Width-20, d.ZoneSplit, true, false)
 
 		if d.ZoneLabel1 != "" {
 			attrs := map[string]string{"font-style": "italic"}
-			svg.WriteString(drawText(70, d.ZoneSplit-20, d.ZoneLabel1, 12, attrs, d.Font))
+			drawText(svg, 70, d.ZoneSplit-20, d.ZoneLabel1, 12, attrs, d.Font)
 		}
 		if d.ZoneLabel2 != "" {
 			attrs := map[string]string{"font-style": "italic"}
-			svg.WriteString(drawText(70, d.ZoneSplit+30, d.ZoneLabel2, 12, attrs, d.Font))
+			drawText(svg, 70, d.ZoneSplit+30, d.ZoneLabel2, 12, attrs, d.Font)
+		}
+	}
+
+	// Draw container frames (behind groups, boxes and arrows; parents first)
+	for _, container := range d.Containers {
+		if container.Framed {
+			drawContainer(svg, container.X, container.Y, container.Width, container.Height, container.Label, container.Styles, d.Font)
 		}
 	}
 
 	// Draw groups (behind boxes and arrows)
 	for _, group := range d.Groups {
-		svg.WriteString(drawGroup(group.X, group.Y, group.Width, group.Height, group.Label, d.Font))
+		drawGroup(svg, group.X, group.Y, group.Width, group.Height, group.Label, d.Font)
 	}
 
 	// Draw arrows based on segment count and routing direction
 	for _, arrow := range d.Arrows {
+		style := arrowStyle{
+			startHead: arrow.StartHead,
+			endHead:   arrow.EndHead,
+			color:     arrow.Color,
+			width:     arrow.StrokeWidth,
+			dash:      arrow.Dash,
+			head:      arrow.Head,
+			shape:     arrow.Shape,
+		}
+		var crossings []int
+		if d.HopCrossings {
+			crossings = arrow.Crossings
+		}
 		switch {
+		case arrow.freeform() || len(crossings) > 0:
+			arrowPath(svg, arrow.route(), crossings, style)
 		case arrow.NumSegments == 1:
-			svg.WriteString(straightArrow(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY))
+			straightArrow(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, style)
 		case arrow.NumSegments == 3 && arrow.VerticalFirst:
-			svg.WriteString(twoBentArrowVertical(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY))
+			twoBentArrowVertical(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, style)
 		case arrow.NumSegments == 3:
-			svg.WriteString(twoBentArrow(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY))
+			twoBentArrow(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, style)
 		case arrow.NumSegments == 2:
-			svg.WriteString(oneBentArrow(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.VerticalFirst))
+			oneBentArrow(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.VerticalFirst, style)
 		default:
 			// Fallback: use coordinate equality for untyped arrows
 			if arrow.FromX == arrow.ToX || arrow.FromY == arrow.ToY {
-				svg.WriteString(straightArrow(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY))
+				straightArrow(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, style)
 			} else {
-				svg.WriteString(oneBentArrow(arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.VerticalFirst))
+				oneBentArrow(svg, arrow.FromX, arrow.FromY, arrow.ToX, arrow.ToY, arrow.VerticalFirst, style)
 			}
 		}
 	}
 
+	// Draw junctions of merged arrows over the lines they join
+	for _, junction := range d.Junctions {
+		drawJunction(svg, junction.X, junction.Y, junction.Color)
+	}
+
+	// Draw arrow labels on top of all arrow lines
+	for _, arrow := range d.Arrows {
+		if arrow.Label != "" {
+			drawArrowLabel(svg, arrow.LabelX, arrow.LabelY, arrow.Label, d.Font)
+		}
+	}
+
 	// Draw boxes
 	for _, box := range d.Boxes {
-		svg.WriteString(drawBox(box.X, box.Y, box.Width, box.Height, box.Color, box.BorderColor, box.BorderWidth))
-		svg.WriteString(drawBoxText(box.X, box.Y, box.Width, box.Height, box.FontSize, box.TextColor, box.TextLines, d.Font))
+		drawBox(svg, box.X, box.Y, box.Width, box.Height, box.Color, box.BorderColor, box.BorderWidth)
+		drawBoxText(svg, box.X, box.Y, box.Width, box.Height, box.FontSize, box.TextColor, box.TextLines, d.Font)
 	}
 
 	// Draw legend if entries exist
 	if len(d.Legend) > 0 {
-		svg.WriteString(d.renderLegend())
+		d.writeLegend(svg)
 	}
 
-	svg.WriteString(svgFooter())
-	return svg.String()
+	writeSVGFooter(svg)
+	return svg.flush()
+}
+
+// fixedShape returns the polyline the fixed-shape emitters draw for the arrow
+// from its endpoints, segment count and direction
+func (a *arrow) fixedShape() []int {
+	switch {
+	case a.NumSegments == 1:
+		return []int{a.FromX, a.FromY, a.ToX, a.ToY}
+	case a.NumSegments == 3 && a.VerticalFirst:
+		midY := (a.FromY + a.ToY) / 2
+		return []int{a.FromX, a.FromY, a.FromX, midY, a.ToX, midY, a.ToX, a.ToY}
+	case a.NumSegments == 3:
+		midX := (a.FromX + a.ToX) / 2
+		return []int{a.FromX, a.FromY, midX, a.FromY, midX, a.ToY, a.ToX, a.ToY}
+	case a.FromX == a.ToX || a.FromY == a.ToY:
+		return []int{a.FromX, a.FromY, a.ToX, a.ToY}
+	case a.VerticalFirst:
+		return []int{a.FromX, a.FromY, a.FromX, a.ToY, a.ToX, a.ToY}
+	}
+	return []int{a.FromX, a.FromY, a.ToX, a.FromY, a.ToX, a.ToY}
+}
+
+// route returns the arrow's polyline, from its route or from its fixed shape
+func (a *arrow) route() []int {
+	if len(a.Points) >= 4 {
+		return simplifyPolyline(a.Points)
+	}
+	return simplifyPolyline(a.fixedShape())
+}
+
+// freeform reports whether the arrow's route differs from what the
+// fixed-shape emitters would draw, so it must be drawn as a polyline
+func (a *arrow) freeform() bool {
+	return len(a.Points) >= 4 && !slices.Equal(simplifyPolyline(a.Points), simplifyPolyline(a.fixedShape()))
+}
+
+// arrowMarkers returns the distinct arrowhead shape/color combinations of arrows
+// with at least one head, in first-use order
+func (d *diagram) arrowMarkers() []arrowMarker {
+	var markers []arrowMarker
+	seen := make(map[arrowMarker]bool)
+	for _, arrow := range d.Arrows {
+		if !arrow.StartHead && !arrow.EndHead {
+			continue
+		}
+		marker := arrowMarker{shape: arrow.Head, color: arrow.Color}
+		if marker.shape == HeadChevron {
+			marker.shape = ""
+		}
+		if !seen[marker] {
+			seen[marker] = true
+			markers = append(markers, marker)
+		}
+	}
+	return markers
 }
 
 // Legend rendering constants
@@ -222,10 +365,8 @@ const (
 	legendTopMargin  = 50 // Top margin (same as diagram top margin)
 )
 
-// renderLegend renders the legend entries in the top-right corner of the SVG
-func (d *Diagram) renderLegend() string {
-	var svg strings.Builder
-
+// writeLegend writes the legend entries in the top-right corner of the SVG
+func (d *diagram) writeLegend(svg *svgWriter) {
 	// Position legend in top-right corner
 	startX := d.Width - legendPadding
 	startY := legendTopMargin
@@ -242,7 +383,7 @@ func (d *Diagram) renderLegend() string {
 
 		// Draw colored square (right-aligned)
 		squareX := startX - legendSquareSize
-		fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="#000" stroke-width="1"/>`,
+		svg.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="#000" stroke-width="1"/>`,
 			squareX, y, legendSquareSize, legendSquareSize, color)
 
 		// Draw label text to the left of the square
@@ -252,8 +393,6 @@ func (d *Diagram) renderLegend() string {
 			"text-anchor":       "end",
 			"dominant-baseline": "middle",
 		}
-		svg.WriteString(drawText(textX, textY, entry.Label, legendFontSize, attrs, d.Font))
+		drawText(svg, textX, textY, entry.Label, legendFontSize, attrs, d.Font)
 	}
-
-	return svg.String()
 }
@@ -1,4 +1,4 @@
-module control
+module github.com/StephanSchmidt/control
 
 go 1.25.3
 
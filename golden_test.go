@@ -0,0 +1,81 @@
+package control
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// renderGolden renders a diagram file with the default command line settings,
+// returning the SVG and the debug JSON
+func renderGolden(t *testing.T, file string) (string, string) {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Reading %s failed: %v", file, err)
+	}
+	defer func() { _ = f.Close() }()
+	spec, err := ParseWithOptions(f, ParseOptions{File: file, MaxErrors: 20})
+	if err != nil {
+		t.Fatalf("Parsing %s failed: %v", file, err)
+	}
+	var svg, debug strings.Builder
+	opts := DefaultOptions()
+	opts.Debug = &debug
+	if _, err := Render(spec, opts, &svg); err != nil {
+		t.Fatalf("Rendering %s failed: %v", file, err)
+	}
+	return svg.String(), debug.String()
+}
+
+func TestGolden_ExamplesAndTutorial(t *testing.T) {
+	var files []string
+	for _, pattern := range []string{"examples/*.txt", "tutorial/*.txt"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Fatal("No diagram files found")
+	}
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			svg, debug := renderGolden(t, file)
+			// Map iteration order changes from run to run: render again to catch it
+			for range 5 {
+				again, againDebug := renderGolden(t, file)
+				if again != svg {
+					t.Fatal("Rendering the same input twice produced different SVG")
+				}
+				if againDebug != debug {
+					t.Fatal("Rendering the same input twice produced different debug output")
+				}
+			}
+
+			golden := filepath.Join("testdata", "golden", strings.TrimSuffix(file, ".txt")+".svg")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, []byte(svg), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Reading the golden file failed (run 'go test -run TestGolden -update' to create it): %v", err)
+			}
+			if string(want) != svg {
+				t.Errorf("SVG differs from %s; if the change is intended, run 'go test -run TestGolden -update'", golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package control
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Grid router constants
+const (
+	gridRouteMargin    = 15  // Distance of grid lines from box edges (must exceed boxCollisionBuffer)
+	gridBendPenalty    = 40  // Extra cost per bend, so straighter routes win over slightly shorter ones
+	gridWindowPadding  = 150 // Initial reach of the search window beyond both boxes
+	gridDirections     = 4   // right, left, down, up
+	gridDirectionRight = 0
+	gridDirectionLeft  = 1
+	gridDirectionDown  = 2
+	gridDirectionUp    = 3
+)
+
+// gridStep is the x/y offset of one move in each grid direction
+var gridStep = [gridDirections][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+// sideDirection returns the grid direction pointing out of a box side
+func sideDirection(side string) int {
+	switch side {
+	case SideTop:
+		return gridDirectionUp
+	case SideBottom:
+		return gridDirectionDown
+	case SideLeft:
+		return gridDirectionLeft
+	}
+	return gridDirectionRight
+}
+
+// reverseDirection returns the opposite grid direction
+func reverseDirection(dir int) int {
+	return dir ^ 1
+}
+
+// gridRoute finds an orthogonal route from box1 to box2 around all obstacles
+// with A* over a visibility grid. The search starts in a window reaching
+// gridWindowPadding beyond both boxes and doubles the padding while it
+// fails and the search reached the window edges, until the window holds every
+// obstacle. Returns the simplified route polyline, or nil if the boxes cannot
+// be connected.
+func gridRoute(box1, box2 boxCoords, obstacles *obstacleIndex, fromID, toID string, ports arrowPorts) []int {
+	ends := boxCoords{X1: min(box1.X1, box2.X1), Y1: min(box1.Y1, box2.Y1), X2: max(box1.X2, box2.X2), Y2: max(box1.Y2, box2.Y2)}
+	extent := ends
+	if bounds, ok := obstacles.bounds(); ok {
+		extent = boxCoords{X1: min(ends.X1, bounds.X1), Y1: min(ends.Y1, bounds.Y1), X2: max(ends.X2, bounds.X2), Y2: max(ends.Y2, bounds.Y2)}
+	}
+	for padding := gridWindowPadding; ; padding *= 2 {
+		window := boxCoords{X1: ends.X1 - padding, Y1: ends.Y1 - padding, X2: ends.X2 + padding, Y2: ends.Y2 + padding}
+		points, open := gridRouteWithin(box1, box2, obstacles.within(window), window, fromID, toID, ports)
+		if points != nil || !open {
+			return points
+		}
+		// Beyond the grid lines around every obstacle, a wider window finds nothing new
+		margin := gridRouteMargin + portStubLength
+		if window.X1 < extent.X1-margin && window.Y1 < extent.Y1-margin && window.X2 > extent.X2+margin && window.Y2 > extent.Y2+margin {
+			return nil
+		}
+	}
+}
+
+// gridRouteWithin runs the grid router on the obstacles near a window, with
+// routes kept inside it. Grid lines run along the window edges,
+// gridRouteMargin outside every box edge, through the side anchors of both
+// boxes, and halfway between neighbouring lines. Routes leave and enter
+// through a portStubLength stub, on the requested ports or any side.
+// Without a route, open reports whether the search reached the window edges,
+// so a wider window may still find one.
+func gridRouteWithin(box1, box2 boxCoords, allBoxes []placedBox, window boxCoords, fromID, toID string, ports arrowPorts) (points []int, open bool) {
+	from, to := boxCoordsData(box1), boxCoordsData(box2)
+	from.ID, to.ID = fromID, toID
+
+	// The end boxes are obstacles too: only the stubs may touch them
+	obstacles := make([]placedBox, 0, len(allBoxes)+2)
+	for _, box := range allBoxes {
+		if box.ID != fromID && box.ID != toID {
+			obstacles = append(obstacles, box)
+		}
+	}
+	obstacles = append(obstacles, from, to)
+
+	xs, ys := []int{window.X1, window.X2}, []int{window.Y1, window.Y2}
+	for _, box := range obstacles {
+		xs = append(xs, box.PixelX-gridRouteMargin, box.PixelX+box.Width+gridRouteMargin)
+		ys = append(ys, box.PixelY-gridRouteMargin, box.PixelY+box.Height+gridRouteMargin)
+	}
+
+	type anchor struct {
+		x, y, stubX, stubY int
+		dir                int // Outward direction of the side
+	}
+	anchors := func(box boxCoords, side string, offset int) anchor {
+		x, y, dx, dy := sideAnchor(box, side, offset)
+		return anchor{x: x, y: y, stubX: x + dx*portStubLength, stubY: y + dy*portStubLength, dir: sideDirection(side)}
+	}
+	var starts, goals []anchor
+	for _, side := range sidesOrAll(ports.From) {
+		a := anchors(box1, side, 0)
+		// The stub may leave the source box, but must not hit anything else
+		if !checkSegmentCollision(a.x, a.y, a.stubX, a.stubY, obstacles, map[string]bool{fromID: true}) {
+			starts = append(starts, a)
+			xs, ys = append(xs, a.stubX), append(ys, a.stubY)
+		}
+	}
+	for _, side := range sidesOrAll(ports.To) {
+		a := anchors(box2, side, strokeAdjustment)
+		if !checkSegmentCollision(a.x, a.y, a.stubX, a.stubY, obstacles, map[string]bool{toID: true}) {
+			goals = append(goals, a)
+			xs, ys = append(xs, a.stubX), append(ys, a.stubY)
+		}
+	}
+	if len(starts) == 0 || len(goals) == 0 {
+		return nil, false
+	}
+
+	g := &visibilityGrid{xs: withMidlines(within(xs, window.X1, window.X2)), ys: withMidlines(within(ys, window.Y1, window.Y2)), obstacles: newObstacleIndex(obstacles)}
+
+	// Goal stubs by grid node; the final move into the box runs against the side direction
+	goalsByNode := make(map[int][]anchor)
+	for _, a := range goals {
+		node := g.node(a.stubX, a.stubY)
+		goalsByNode[node] = append(goalsByNode[node], a)
+	}
+	heuristic := func(x, y int) int {
+		best := -1
+		for _, a := range goals {
+			if d := abs(a.stubX-x) + abs(a.stubY-y); best < 0 || d < best {
+				best = d
+			}
+		}
+		return best
+	}
+
+	nx := len(g.xs)
+	stateCount := nx * len(g.ys) * gridDirections
+	cost := make([]int, stateCount)
+	parent := make([]int, stateCount)
+	for i := range cost {
+		cost[i] = -1
+		parent[i] = -1
+	}
+
+	queue := &gridQueue{}
+	for _, a := range starts {
+		state := g.node(a.stubX, a.stubY)*gridDirections + a.dir
+		if cost[state] < 0 || portStubLength < cost[state] {
+			cost[state] = portStubLength
+			heap.Push(queue, gridItem{state: state, cost: portStubLength, priority: portStubLength + heuristic(a.stubX, a.stubY), goal: -1})
+		}
+	}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(gridItem)
+		if item.goal >= 0 {
+			// Cheapest complete route: walk the parents back to the start stub
+			goal := goalsByNode[item.state/gridDirections][item.goal]
+			points = []int{goal.x, goal.y}
+			for state := item.state; state >= 0; state = parent[state] {
+				n := state / gridDirections
+				points = append(points, g.xs[n%nx], g.ys[n/nx])
+			}
+			start := starts[0]
+			for _, a := range starts {
+				if a.stubX == points[len(points)-2] && a.stubY == points[len(points)-1] {
+					start = a
+					break
+				}
+			}
+			points = append(points, start.x, start.y)
+			reversePoints(points)
+			return simplifyPolyline(points), true
+		}
+		if item.cost > cost[item.state] {
+			continue // Stale queue entry
+		}
+
+		node, dir := item.state/gridDirections, item.state%gridDirections
+		i, j := node%nx, node/nx
+		x, y := g.xs[i], g.ys[j]
+
+		// Finishing here: the last segment enters the target box
+		for k, a := range goalsByNode[node] {
+			inward := reverseDirection(a.dir)
+			if dir == reverseDirection(inward) {
+				continue // Would double back over the stub
+			}
+			total := item.cost + portStubLength
+			if dir != inward {
+				total += gridBendPenalty
+			}
+			heap.Push(queue, gridItem{state: item.state, cost: total, priority: total, goal: k})
+		}
+
+		for next := 0; next < gridDirections; next++ {
+			if next == reverseDirection(dir) {
+				continue
+			}
+			ni, nj := i+gridStep[next][0], j+gridStep[next][1]
+			if ni < 0 || nj < 0 || ni >= nx || nj >= len(g.ys) {
+				open = true // The search reached the window edge
+				continue
+			}
+			nxX, nxY := g.xs[ni], g.ys[nj]
+			if g.obstacles.segmentCollides(x, y, nxX, nxY) {
+				continue
+			}
+			step := abs(nxX-x) + abs(nxY-y)
+			if next != dir {
+				step += gridBendPenalty
+			}
+			state := (nj*nx+ni)*gridDirections + next
+			if c := item.cost + step; cost[state] < 0 || c < cost[state] {
+				cost[state] = c
+				parent[state] = item.state
+				heap.Push(queue, gridItem{state: state, cost: c, priority: c + heuristic(nxX, nxY), goal: -1})
+			}
+		}
+	}
+	return nil, open
+}
+
+// visibilityGrid holds the candidate x and y lines of the grid router
+type visibilityGrid struct {
+	xs, ys    []int
+	obstacles *obstacleIndex
+}
+
+// node returns the index of the grid node at a point lying on grid lines
+func (g *visibilityGrid) node(x, y int) int {
+	i := sort.SearchInts(g.xs, x)
+	j := sort.SearchInts(g.ys, y)
+	return j*len(g.xs) + i
+}
+
+// within returns the grid lines between lo and hi, reusing the slice
+func within(lines []int, lo, hi int) []int {
+	kept := lines[:0]
+	for _, v := range lines {
+		if v >= lo && v <= hi {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// withMidlines sorts and deduplicates grid lines and adds a line halfway
+// between each neighbouring pair, so routes can run centered in gaps
+func withMidlines(lines []int) []int {
+	sort.Ints(lines)
+	result := make([]int, 0, 2*len(lines))
+	for i, v := range lines {
+		if i > 0 && v == lines[i-1] {
+			continue
+		}
+		if n := len(result); n > 0 && v-result[n-1] > 1 {
+			result = append(result, (v+result[n-1])/2)
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// reversePoints reverses a polyline [x0,y0,x1,y1,...] in place
+func reversePoints(points []int) {
+	for i, j := 0, len(points)-2; i < j; i, j = i+2, j-2 {
+		points[i], points[j] = points[j], points[i]
+		points[i+1], points[j+1] = points[j+1], points[i+1]
+	}
+}
+
+// gridItem is a search state in the grid router's priority queue. Items with
+// goal >= 0 are complete routes ending in the goal-th target stub of the node.
+type gridItem struct {
+	state    int // (node index)*gridDirections + arrival direction
+	cost     int
+	priority int // cost plus heuristic
+	goal     int
+}
+
+// gridQueue is a min-heap of search states ordered by priority
+type gridQueue []gridItem
+
+func (q gridQueue) Len() int           { return len(q) }
+func (q gridQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q gridQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *gridQueue) Push(x any)        { *q = append(*q, x.(gridItem)) }
+func (q *gridQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -1,49 +1,66 @@
-package main
+package control
 
 import (
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // Layout dimension constants
 const (
-	CHAR_WIDTH_PIXELS  = 16.0 // Approximate width per character for text wrapping
-	MIN_CHARS_PER_LINE = 1    // Minimum characters per line in wrapped text
-	MAX_TEXT_LINES     = 3    // Maximum lines for wrapped text
+	charWidthPixels = 16.0 // Approximate width per character for text wrapping
+	minCharsPerLine = 1    // Minimum characters per line in wrapped text
+	maxTextLines    = 3    // Maximum lines for wrapped text
+)
+
+// Arrow label constants
+const (
+	arrowLabelFontSize  = 18 // Font size for arrow labels
+	arrowLabelCharWidth = 11 // Approximate width per character at arrowLabelFontSize
+	arrowLabelHeight    = 22 // Height of the label obstacle box
+	arrowLabelPadding   = 4  // Padding around the label text in its obstacle box
 )
 
 // Coordinate conversion helpers
 
 // gridToPixelX converts grid X coordinate to pixel X coordinate
-func gridToPixelX(gridX int, dims Dimensions, config DiagramConfig) int {
+func gridToPixelX(gridX int, dims dimensions, config diagramConfig) int {
 	return dims.LeftMargin + int(float64(gridX-1)*dims.CellUnits*float64(config.GridUnit))
 }
 
 // gridToPixelY converts grid Y coordinate to pixel Y coordinate
-func gridToPixelY(gridY int, dims Dimensions, config DiagramConfig) int {
+func gridToPixelY(gridY int, dims dimensions, config diagramConfig) int {
 	return dims.TopMargin + int(float64(gridY-1)*dims.VerticalCellUnits*float64(config.GridUnit))
 }
 
 // calculateBoxWidth calculates the pixel width of a box based on its grid width
-func calculateBoxWidth(gridWidth float64, dims Dimensions, config DiagramConfig) int {
+func calculateBoxWidth(gridWidth float64, dims dimensions, config diagramConfig) int {
 	return int((gridWidth*dims.CellUnits - config.GapUnits*config.Stretch) * float64(config.GridUnit))
 }
 
 // calculateBoxHeight calculates the pixel height of a box based on its grid height
-func calculateBoxHeight(gridHeight int, config DiagramConfig) int {
+func calculateBoxHeight(gridHeight int, config diagramConfig) int {
 	return gridHeight * config.GridUnit
 }
 
 // calculateTouchExtension calculates the extension amount for TouchLeft boxes
-func calculateTouchExtension(config DiagramConfig) int {
+func calculateTouchExtension(config diagramConfig) int {
 	return int((config.GapUnits / 2.0) * float64(config.GridUnit))
 }
 
 // groupPadding is the padding (in pixels) around boxes within a group rectangle
 const groupPadding = 15
 
-// Layout converts a DiagramSpec into a concrete Diagram with pixel coordinates
-func Layout(spec *DiagramSpec, config DiagramConfig, legend []LegendEntry, groups []GroupDef, arrowFlow string) (*Diagram, map[string]BoxData) {
+// Container frame spacing (in pixels)
+const (
+	containerPadding     = 15 // Padding around member boxes and nested frames
+	containerTitleHeight = 30 // Extra space above the contents for the title
+)
+
+// layout converts a DiagramSpec into a concrete Diagram with pixel coordinates
+func layout(spec *DiagramSpec, config diagramConfig, legend []LegendEntry, groups []GroupDef, arrowFlow string) (*diagram, map[string]placedBox) {
 	// Find maximum grid positions
 	maxGridX := 0
 	maxGridY := 0
@@ -57,17 +74,17 @@ func Layout(spec *DiagramSpec, config DiagramConfig, legend []LegendEntry, group
 	}
 
 	// Calculate dimensions
-	dims := CalculateDimensions(maxGridX, maxGridY, config)
+	dims := calculateDimensions(maxGridX, maxGridY, config)
 
 	// Extend width for legend area if needed
-	legendWidth := EstimateLegendWidth(legend)
+	legendWidth := estimateLegendWidth(legend)
 	dims.Width += legendWidth
 
 	// Create diagram
-	diagram := NewDiagram(dims.Width, dims.Height)
+	diagram := newDiagram(dims.Width, dims.Height)
 
 	// Map box IDs to their data for arrow routing
-	boxData := make(map[string]BoxData)
+	boxData := make(map[string]placedBox)
 
 	// Create boxes
 	var previousBoxSpecID string // Track previous box ID for touch-left boxData updates
@@ -112,17 +129,17 @@ func Layout(spec *DiagramSpec, config DiagramConfig, legend []LegendEntry, group
 		textColor := boxSpec.TextColor
 
 		// Wrap text to fit in box
-		maxCharsPerLine := int(float64(boxWidth) / CHAR_WIDTH_PIXELS)
-		if maxCharsPerLine < MIN_CHARS_PER_LINE {
-			maxCharsPerLine = MIN_CHARS_PER_LINE
+		maxCharsPerLine := int(float64(boxWidth) / charWidthPixels)
+		if maxCharsPerLine < minCharsPerLine {
+			maxCharsPerLine = minCharsPerLine
 		}
-		wrappedLines := WrapText(boxSpec.Label, maxCharsPerLine, MAX_TEXT_LINES)
+		wrappedLines := wrapText(boxSpec.Label, maxCharsPerLine, maxTextLines)
 		wrappedLabel := strings.Join(wrappedLines, "\n")
 
 		diagram.AddBox(pixelX, pixelY, boxWidth, boxHeight, wrappedLabel, color, borderColor, borderWidth, fontSize, textColor)
 
 		// Store box data for arrow routing
-		boxData[boxSpec.ID] = BoxData{
+		boxData[boxSpec.ID] = placedBox{
 			ID:      boxSpec.ID,
 			GridX:   boxSpec.GridX,
 			GridY:   boxSpec.GridY,
@@ -145,25 +162,81 @@ func Layout(spec *DiagramSpec, config DiagramConfig, legend []LegendEntry, group
 		}
 	}
 
+	// Resolve groups to pixel coordinates
+	for _, g := range groups {
+		minX, minY, maxX, maxY, ok := memberBounds(g.BoxIDs, boxData)
+		if !ok {
+			continue // no valid boxes found
+		}
+		diagram.Groups = append(diagram.Groups, group{
+			X:      minX - groupPadding,
+			Y:      minY - groupPadding - 30, // extra space for label
+			Width:  (maxX - minX) + 2*groupPadding,
+			Height: (maxY - minY) + 2*groupPadding + 30, // extra space for label
+			Label:  g.Label,
+			BoxIDs: g.BoxIDs,
+		})
+	}
+
+	diagram.Containers = layoutContainers(spec.Containers, boxData)
+	for _, c := range diagram.Containers {
+		if !c.Framed {
+			continue
+		}
+		// Extend the canvas if a frame (e.g., around nested frames) exceeds it
+		if rightEdge := c.X + c.Width + 5; rightEdge+legendWidth > diagram.Width {
+			diagram.Width = rightEdge + legendWidth
+		}
+		if bottomEdge := c.Y + c.Height + 5; bottomEdge > diagram.Height {
+			diagram.Height = bottomEdge
+		}
+	}
+
+	// Obstacles for routing, built once and grown as arrows are placed: the
+	// boxes alone, the boxes with the labels of placed arrows, and the boxes
+	// with the labels and routes of placed arrows
+	boxes := make([]placedBox, 0, len(boxData))
+	for _, id := range slices.Sorted(maps.Keys(boxData)) {
+		boxes = append(boxes, boxData[id])
+	}
+	boxIndex := newObstacleIndex(boxes)
+	obstacles := newObstacleIndex(boxes)
+	placed := newObstacleIndex(boxes)
+
+	// Route feedback loops around the content: boxes, groups and frames
+	nested := make(map[string]bool)
+	for _, c := range spec.Containers {
+		for _, id := range c.BoxIDs {
+			nested[id] = true
+		}
+	}
+	arrowSpecs := selfLoopsLast(spec.Arrows)
+	loops, failedLoops := planLoops(arrowSpecs, boxData, boxIndex, contentBounds(diagram, boxes), arrowFlow, nested, config.YAxis)
+	for _, i := range failedLoops {
+		diagram.Diagnostics = append(diagram.Diagnostics, routingWarning(arrowSpecs[i],
+			"no outer lane for loop arrow '%s -> %s', routed between the boxes", arrowSpecs[i].FromID, arrowSpecs[i].ToID))
+	}
+
 	// Create arrows
-	for _, arrowSpec := range spec.Arrows {
+	labels := 0 // Placed arrow labels, which are obstacles for later arrows
+	for i, arrowSpec := range arrowSpecs {
 		fromBox := boxData[arrowSpec.FromID]
 		toBox := boxData[arrowSpec.ToID]
 
-		// Convert boxData map to slice for collision detection
-		allBoxes := make([]BoxData, 0, len(boxData))
-		for _, box := range boxData {
-			allBoxes = append(allBoxes, box)
+		avoid := obstacles
+		if arrowSpec.FromID == arrowSpec.ToID {
+			// Self-loops take a side that no placed arrow runs through
+			avoid = placed
 		}
 
 		// Create box coordinates
-		box1 := BoxCoords{
+		box1 := boxCoords{
 			X1: fromBox.PixelX,
 			Y1: fromBox.PixelY,
 			X2: fromBox.PixelX + fromBox.Width,
 			Y2: fromBox.PixelY + fromBox.Height,
 		}
-		box2 := BoxCoords{
+		box2 := boxCoords{
 			X1: toBox.PixelX,
 			Y1: toBox.PixelY,
 			X2: toBox.PixelX + toBox.Width,
@@ -176,71 +249,372 @@ func Layout(spec *DiagramSpec, config DiagramConfig, legend []LegendEntry, group
 			flow = arrowSpec.Flow
 		}
 
-		plan, err := RouteArrow(
-			box1, box2,
-			fromBox.GridX, fromBox.GridY,
-			toBox.GridX, toBox.GridY,
-			allBoxes,
-			arrowSpec.FromID, arrowSpec.ToID,
-			flow,
-		)
+		ports := arrowPorts{From: arrowSpec.FromSide, To: arrowSpec.ToSide}
+		if arrowSpec.FromID == arrowSpec.ToID && selfLoopSides(ports) == nil {
+			// A self-loop leaves and re-enters the same side
+			diagram.Diagnostics = append(diagram.Diagnostics, routingWarning(arrowSpec,
+				"self-loop '%s -> %s' cannot leave the %s and enter the %s side, drawn on the %s side",
+				arrowSpec.FromID, arrowSpec.ToID, ports.From, ports.To, ports.From))
+			ports.To = ports.From
+		}
+		var plan *routingPlan
+		var err error
+		if points, ok := loops[i]; ok {
+			plan = loopPlan(points)
+			extendCanvas(diagram, points, legendWidth)
+			for j := 1; j < len(points); j += 2 {
+				// Keep the bottom margin, and the x-axis in it, below the lane
+				diagram.Height = max(diagram.Height, points[j]+dims.BottomMargin)
+			}
+		} else if len(arrowSpec.Via) > 0 {
+			// Waypoints force the route: crossing boxes is reported, not avoided
+			plan = routeArrowVia(box1, box2, waypointPixels(arrowSpec.Via, dims, config), boxes, arrowSpec.FromID, arrowSpec.ToID, ports)
+			if plan.AllCandidates[0].rejected {
+				diagram.Diagnostics = append(diagram.Diagnostics, routingWarning(arrowSpec,
+					"arrow '%s -> %s' crosses other boxes on its way through the waypoints", arrowSpec.FromID, arrowSpec.ToID))
+			}
+			extendCanvas(diagram, plan.Points, legendWidth)
+		} else {
+			plan, err = routeArrowIndexed(
+				box1, box2,
+				fromBox.GridX, fromBox.GridY,
+				toBox.GridX, toBox.GridY,
+				avoid,
+				arrowSpec.FromID, arrowSpec.ToID,
+				flow,
+				ports,
+			)
+		}
+		if err != nil && labels > 0 {
+			// Labels are soft obstacles: crossing one beats dropping the arrow
+			plan, err = routeArrowIndexed(
+				box1, box2,
+				fromBox.GridX, fromBox.GridY,
+				toBox.GridX, toBox.GridY,
+				boxIndex,
+				arrowSpec.FromID, arrowSpec.ToID,
+				flow,
+				ports,
+			)
+		}
 		if err != nil {
-			fmt.Printf("Error routing arrow from %s to %s: %v\n", arrowSpec.FromID, arrowSpec.ToID, err)
+			// Last resort: crossing boxes beats silently dropping the arrow
+			plan, err = routeArrowIndexed(
+				box1, box2,
+				fromBox.GridX, fromBox.GridY,
+				toBox.GridX, toBox.GridY,
+				nil,
+				arrowSpec.FromID, arrowSpec.ToID,
+				flow,
+				ports,
+			)
+			if err == nil {
+				diagram.Diagnostics = append(diagram.Diagnostics, routingWarning(arrowSpec,
+					"no collision-free route for arrow '%s -> %s', drawn across other boxes", arrowSpec.FromID, arrowSpec.ToID))
+			}
+		}
+		if err != nil {
+			diagram.Diagnostics = append(diagram.Diagnostics, routingWarning(arrowSpec,
+				"arrow '%s -> %s' skipped: %v", arrowSpec.FromID, arrowSpec.ToID, err))
 			continue
 		}
 
-		diagram.AddArrow(plan.StartX, plan.StartY, plan.EndX, plan.EndY, plan.VerticalFirst, plan.NumSegments, arrowSpec.FromID, arrowSpec.ToID, plan.Strategy, plan.AllCandidates)
+		arrow := diagram.AddArrow(plan.StartX, plan.StartY, plan.EndX, plan.EndY, plan.VerticalFirst, plan.NumSegments, arrowSpec.FromID, arrowSpec.ToID, plan.Strategy, plan.AllCandidates)
+		arrow.Points = plan.Points
+		arrow.StartHead = arrowSpec.HasStartHead()
+		arrow.EndHead = arrowSpec.HasEndHead()
+		arrow.Color = arrowSpec.Color
+		arrow.StrokeWidth = arrowSpec.StrokeWidth
+		arrow.Dash = arrowSpec.Dash
+		arrow.Head = arrowSpec.Head
+		if arrow.Head == "" {
+			arrow.Head = config.ArrowHead
+		}
+		arrow.Shape = arrowSpec.Shape
+		if arrow.Shape == "" {
+			arrow.Shape = config.ArrowShape
+		}
+		arrow.Merge = plan.Strategy != "loop_lane" && (config.Merge == MergeBus || (config.Merge == "" && flow == FlowDown))
+		placed.addPath("_arrow_"+strconv.Itoa(len(diagram.Arrows)-1), arrow.route())
+
+		if arrowSpec.Label != "" {
+			labelBox := placeArrowLabel(plan.Points, arrowSpec.Label)
+			labelBox.ID = fmt.Sprintf("_label_%d", labels)
+			obstacles.add(labelBox)
+			placed.add(labelBox)
+			labels++
+			arrow.Label = arrowSpec.Label
+			arrow.LabelX, arrow.LabelY = labelBox.CenterX, labelBox.CenterY
+		}
+	}
+
+	// Merge fan-outs and fan-ins into buses, then move apart arrows sharing box
+	// sides or corridors; labels are placed on the final routes
+	diagram.Junctions = mergeBuses(diagram.Arrows, boxData, boxIndex)
+	separateArrows(diagram.Arrows, boxData, boxIndex)
+	labelBoxes := placeFinalLabels(diagram.Arrows, boxIndex)
+	for i, arrow := range diagram.Arrows {
+		if arrow.FromBoxID == arrow.ToBoxID {
+			// Self-loops stick out of their box, possibly past the canvas edge
+			extendCanvas(diagram, arrow.Points, legendWidth)
+			if label, ok := labelBoxes[i]; ok {
+				extendCanvas(diagram, []int{label.PixelX + label.Width, label.PixelY + label.Height}, legendWidth)
+			}
+		}
 	}
 
-	// Resolve groups to pixel coordinates
-	for _, g := range groups {
-		if len(g.BoxIDs) == 0 {
+	diagram.Crossings = markCrossings(diagram.Arrows)
+	diagram.HopCrossings = config.Crossings == CrossingsHop
+
+	return diagram, boxData
+}
+
+// waypointPixels converts waypoints [x0,y0,...] from grid coordinates to the
+// pixel centers of the grid cells, where a box of size 1x1 would have its center
+func waypointPixels(via []int, dims dimensions, config diagramConfig) []int {
+	cellWidth := calculateBoxWidth(1, dims, config)
+	cellHeight := calculateBoxHeight(1, config)
+	pixels := make([]int, len(via))
+	for i := 0; i+1 < len(via); i += 2 {
+		pixels[i] = gridToPixelX(via[i], dims, config) + cellWidth/2
+		pixels[i+1] = gridToPixelY(via[i+1], dims, config) + cellHeight/2
+	}
+	return pixels
+}
+
+// extendCanvas grows the diagram to fit a route running past its boxes,
+// e.g. through waypoints outside the grid in use
+func extendCanvas(diagram *diagram, points []int, legendWidth int) {
+	for i := 0; i+1 < len(points); i += 2 {
+		if rightEdge := points[i] + 20; rightEdge+legendWidth > diagram.Width {
+			diagram.Width = rightEdge + legendWidth
+		}
+		if bottomEdge := points[i+1] + 20; bottomEdge > diagram.Height {
+			diagram.Height = bottomEdge
+		}
+	}
+}
+
+// selfLoopsLast returns the arrows with self-loops moved to the end, so they
+// are routed once the other arrows are in place
+func selfLoopsLast(arrows []ArrowSpec) []ArrowSpec {
+	ordered := make([]ArrowSpec, 0, len(arrows))
+	var loops []ArrowSpec
+	for _, arrow := range arrows {
+		if arrow.FromID == arrow.ToID {
+			loops = append(loops, arrow)
+		} else {
+			ordered = append(ordered, arrow)
+		}
+	}
+	return append(ordered, loops...)
+}
+
+// routingWarning builds a layout warning positioned at the arrow's source line
+func routingWarning(arrowSpec ArrowSpec, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		Line:     arrowSpec.Line,
+		Severity: SeverityWarning,
+		Code:     CodeRouting,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// placeArrowLabel centers a label on the longest segment of a route polyline
+// and returns the label's bounding box, used as an obstacle for later arrows
+func placeArrowLabel(points []int, label string) placedBox {
+	candidates := arrowLabelCandidates(points, label)
+	if len(candidates) == 0 {
+		return arrowLabelBox(0, 0, label)
+	}
+	return candidates[0]
+}
+
+// arrowLabelCandidates returns a label's bounding box centered on each segment
+// of a route polyline, longest segment first, followed by the boxes moved off
+// the segment centers in steps of an eighth of the segment, closest first
+func arrowLabelCandidates(points []int, label string) []placedBox {
+	points = simplifyPolyline(points)
+	type segment struct{ x1, y1, x2, y2, length int }
+	var segments []segment
+	for i := 0; i+3 < len(points); i += 2 {
+		x1, y1, x2, y2 := points[i], points[i+1], points[i+2], points[i+3]
+		segments = append(segments, segment{x1, y1, x2, y2, abs(x2-x1) + abs(y2-y1)})
+	}
+	slices.SortStableFunc(segments, func(a, b segment) int { return b.length - a.length })
+
+	var candidates []placedBox
+	for _, eighths := range []int{4, 3, 5, 2, 6, 1, 7} {
+		for _, s := range segments {
+			candidates = append(candidates, arrowLabelBox(s.x1+(s.x2-s.x1)*eighths/8, s.y1+(s.y2-s.y1)*eighths/8, label))
+		}
+	}
+	return candidates
+}
+
+// arrowLabelBox returns the bounding box of a label centered at centerX, centerY
+func arrowLabelBox(centerX, centerY int, label string) placedBox {
+	width := len([]rune(label))*arrowLabelCharWidth + 2*arrowLabelPadding
+	height := arrowLabelHeight
+	return placedBox{
+		PixelX:  centerX - width/2,
+		PixelY:  centerY - height/2,
+		CenterX: centerX,
+		CenterY: centerY,
+		Width:   width,
+		Height:  height,
+	}
+}
+
+// placeFinalLabels places the labels of the arrows once merging and separation
+// have moved them. Each label takes the first of its candidates that is clear
+// of the boxes, the other arrows and the labels placed before it, or the
+// center of the longest segment if none is. It returns the label boxes by
+// arrow index.
+func placeFinalLabels(arrows []arrow, boxes *obstacleIndex) map[int]placedBox {
+	clear := newObstacleIndex(nil)
+	for i := range arrows {
+		clear.addPath("_arrow_"+strconv.Itoa(i), arrows[i].route())
+	}
+
+	placed := make(map[int]placedBox)
+	for i := range arrows {
+		arrow := &arrows[i]
+		if arrow.Label == "" {
 			continue
 		}
-		// Compute bounding box of all member boxes
-		first := true
-		var minX, minY, maxX, maxY int
-		for _, boxID := range g.BoxIDs {
-			bd, ok := boxData[boxID]
-			if !ok {
-				continue
+		label := placeArrowLabel(arrow.Points, arrow.Label)
+		for _, candidate := range arrowLabelCandidates(arrow.Points, arrow.Label) {
+			if !boxes.boxCollides(candidate) && !clear.boxCollides(candidate, "_arrow_"+strconv.Itoa(i)) {
+				label = candidate
+				break
 			}
-			bx1 := bd.PixelX
-			by1 := bd.PixelY
-			bx2 := bd.PixelX + bd.Width
-			by2 := bd.PixelY + bd.Height
-			if first {
-				minX, minY, maxX, maxY = bx1, by1, bx2, by2
-				first = false
-			} else {
-				if bx1 < minX {
-					minX = bx1
-				}
-				if by1 < minY {
-					minY = by1
-				}
-				if bx2 > maxX {
-					maxX = bx2
-				}
-				if by2 > maxY {
-					maxY = by2
-				}
+		}
+		label.ID = "_label_" + strconv.Itoa(i)
+		clear.add(label)
+		placed[i] = label
+		arrow.LabelX, arrow.LabelY = label.CenterX, label.CenterY
+	}
+	return placed
+}
+
+// simplifyPolyline drops repeated points and merges collinear segments of an
+// orthogonal polyline, so a straight route split at its midpoint becomes one
+// segment. A polyline with nothing to simplify is returned as is, not copied.
+func simplifyPolyline(points []int) []int {
+	if len(points) < 4 {
+		return points
+	}
+	kept := 2 // Length of the prefix that is already simple
+	for kept+1 < len(points) && !redundantPoint(points[:kept], points[kept], points[kept+1]) {
+		kept += 2
+	}
+	if kept+1 >= len(points) {
+		return points
+	}
+	result := append(make([]int, 0, len(points)), points[:kept]...)
+	for i := kept; i+1 < len(points); i += 2 {
+		x, y := points[i], points[i+1]
+		n := len(result)
+		if x == result[n-2] && y == result[n-1] {
+			continue // repeated point
+		}
+		if redundantPoint(result, x, y) {
+			result[n-2], result[n-1] = x, y // extend collinear segment
+			continue
+		}
+		result = append(result, x, y)
+	}
+	return result
+}
+
+// redundantPoint reports whether point (x, y) repeats the last point of a
+// polyline or continues its last segment in a straight line
+func redundantPoint(points []int, x, y int) bool {
+	n := len(points)
+	if x == points[n-2] && y == points[n-1] {
+		return true
+	}
+	return n >= 4 && ((points[n-4] == points[n-2] && points[n-2] == x) || (points[n-3] == points[n-1] && points[n-1] == y))
+}
+
+// abs returns the absolute value of an int
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// layoutContainers computes container bounds from their member boxes.
+// A framed nested container's frame is included in its parent's bounds, so
+// containers are resolved innermost first (children follow parents in defs).
+// Containers without any laid out member box are omitted.
+func layoutContainers(defs []ContainerDef, boxData map[string]placedBox) []container {
+	resolved := make(map[string]container)
+	for i := len(defs) - 1; i >= 0; i-- {
+		def := defs[i]
+		minX, minY, maxX, maxY, ok := memberBounds(def.BoxIDs, boxData)
+		if !ok {
+			continue
+		}
+		for _, child := range defs[i+1:] {
+			c, ok := resolved[child.ID]
+			if child.Parent != def.ID || !ok || !c.Framed {
+				continue
 			}
+			minX = min(minX, c.X)
+			minY = min(minY, c.Y)
+			maxX = max(maxX, c.X+c.Width)
+			maxY = max(maxY, c.Y+c.Height)
 		}
-		if first {
-			continue // no valid boxes found
+		titleSpace := 0
+		if def.Label != "" {
+			titleSpace = containerTitleHeight
+		}
+		resolved[def.ID] = container{
+			X:      minX - containerPadding,
+			Y:      minY - containerPadding - titleSpace,
+			Width:  (maxX - minX) + 2*containerPadding,
+			Height: (maxY - minY) + 2*containerPadding + titleSpace,
+			ID:     def.ID,
+			Label:  def.Label,
+			Framed: def.Framed,
+			Styles: def.Styles,
+			BoxIDs: def.BoxIDs,
 		}
-		diagram.Groups = append(diagram.Groups, Group{
-			X:      minX - groupPadding,
-			Y:      minY - groupPadding - 30, // extra space for label
-			Width:  (maxX - minX) + 2*groupPadding,
-			Height: (maxY - minY) + 2*groupPadding + 30, // extra space for label
-			Label:  g.Label,
-			BoxIDs: g.BoxIDs,
-		})
 	}
 
-	return diagram, boxData
+	var containers []container
+	for _, def := range defs {
+		if c, ok := resolved[def.ID]; ok {
+			containers = append(containers, c)
+		}
+	}
+	return containers
+}
+
+// memberBounds returns the pixel bounding box of the given boxes.
+// ok is false if none of the IDs has box data.
+func memberBounds(boxIDs []string, boxData map[string]placedBox) (minX, minY, maxX, maxY int, ok bool) {
+	for _, boxID := range boxIDs {
+		bd, found := boxData[boxID]
+		if !found {
+			continue
+		}
+		bx1, by1 := bd.PixelX, bd.PixelY
+		bx2, by2 := bd.PixelX+bd.Width, bd.PixelY+bd.Height
+		if !ok {
+			minX, minY, maxX, maxY = bx1, by1, bx2, by2
+			ok = true
+			continue
+		}
+		minX = min(minX, bx1)
+		minY = min(minY, by1)
+		maxX = max(maxX, bx2)
+		maxY = max(maxY, by2)
+	}
+	return minX, minY, maxX, maxY, ok
 }
 
 // parseHexColor converts hex color string to RGB values (0-255 range)
@@ -1,15 +1,19 @@
-package main
+package control
 
-import "fmt"
+import (
+	"fmt"
+)
 
 // Arrow routing constants
 const (
-	STROKE_ADJUSTMENT    = 2 // Adjustment for arrow entry/exit to account for box stroke width
-	BOX_COLLISION_BUFFER = 3 // Buffer around boxes for collision detection
+	strokeAdjustment   = 2  // Adjustment for arrow entry/exit to account for box stroke width
+	boxCollisionBuffer = 3  // Buffer around boxes for collision detection
+	portStubLength     = 20 // Length of the first and last segment of a port route
+	selfLoopSize       = 40 // Distance a self-loop extends from its box side
 )
 
-// Dimensions holds calculated diagram dimensions
-type Dimensions struct {
+// dimensions holds calculated diagram dimensions
+type dimensions struct {
 	Width             int
 	Height            int
 	BoxWidth          int
@@ -21,15 +25,15 @@ type Dimensions struct {
 	VerticalCellUnits float64
 }
 
-// CalculateDimensions computes diagram dimensions from spec and config
-func CalculateDimensions(maxGridX, maxGridY int, config DiagramConfig) Dimensions {
+// calculateDimensions computes diagram dimensions from spec and config
+func calculateDimensions(maxGridX, maxGridY int, config diagramConfig) dimensions {
 	cellUnits := (1.0 + config.GapUnits) * config.Stretch
 	verticalCellUnits := 1.0 + config.VerticalGapUnits
 
 	boxWidth := int(config.BoxWidthUnits * float64(config.GridUnit))
 	boxHeight := config.GridUnit
 
-	leftMargin := 60 + config.AxisOffset
+	leftMargin := axisX + config.AxisOffset
 	bottomMargin := 50 + config.AxisOffset
 	topMargin := 50
 
@@ -39,7 +43,7 @@ func CalculateDimensions(maxGridX, maxGridY int, config DiagramConfig) Dimension
 	contentHeight := int(float64(maxGridY) * verticalCellUnits * float64(config.GridUnit))
 	height := bottomMargin + contentHeight + topMargin
 
-	return Dimensions{
+	return dimensions{
 		Width:             width,
 		Height:            height,
 		BoxWidth:          boxWidth,
@@ -52,9 +56,9 @@ func CalculateDimensions(maxGridX, maxGridY int, config DiagramConfig) Dimension
 	}
 }
 
-// EstimateLegendWidth estimates the pixel width needed for the legend area
+// estimateLegendWidth estimates the pixel width needed for the legend area
 // based on the longest label text. Returns 0 if no legend entries.
-func EstimateLegendWidth(legend []LegendEntry) int {
+func estimateLegendWidth(legend []LegendEntry) int {
 	if len(legend) == 0 {
 		return 0
 	}
@@ -71,8 +75,8 @@ func EstimateLegendWidth(legend []LegendEntry) int {
 	return textWidth + legendSquareSize + legendTextGap + legendPadding*2
 }
 
-// BoxData represents box information needed for arrow routing
-type BoxData struct {
+// placedBox represents box information needed for arrow routing
+type placedBox struct {
 	ID               string
 	GridX, GridY     int
 	PixelX, PixelY   int
@@ -80,8 +84,8 @@ type BoxData struct {
 	Width, Height    int
 }
 
-// ArrowRoute represents the calculated arrow path
-type ArrowRoute struct {
+// arrowRoute represents the calculated arrow path
+type arrowRoute struct {
 	FromX         int
 	FromY         int
 	ToX           int
@@ -90,7 +94,7 @@ type ArrowRoute struct {
 }
 
 // checkSegmentCollision checks if a line segment (vertical or horizontal) intersects any boxes
-func checkSegmentCollision(x1, y1, x2, y2 int, allBoxes []BoxData, excludeIDs map[string]bool) bool {
+func checkSegmentCollision(x1, y1, x2, y2 int, allBoxes []placedBox, excludeIDs map[string]bool) bool {
 	// Normalize coordinates
 	if x1 > x2 {
 		x1, x2 = x2, x1
@@ -100,58 +104,63 @@ func checkSegmentCollision(x1, y1, x2, y2 int, allBoxes []BoxData, excludeIDs ma
 	}
 
 	// Check each box
-	for _, box := range allBoxes {
+	for i := range allBoxes {
 		// Skip boxes we should exclude (source and destination)
-		if excludeIDs != nil && excludeIDs[box.ID] {
+		if excludeIDs != nil && excludeIDs[allBoxes[i].ID] {
 			continue
 		}
-
-		// Expand box bounds slightly for stroke width
-		boxLeft := box.PixelX - BOX_COLLISION_BUFFER
-		boxRight := box.PixelX + box.Width + BOX_COLLISION_BUFFER
-		boxTop := box.PixelY - BOX_COLLISION_BUFFER
-		boxBottom := box.PixelY + box.Height + BOX_COLLISION_BUFFER
-
-		// Check if line segment intersects this box
-		// For a line segment to intersect a box, it must overlap in both dimensions
-		xOverlap := x1 <= boxRight && x2 >= boxLeft
-		yOverlap := y1 <= boxBottom && y2 >= boxTop
-
-		if xOverlap && yOverlap {
+		if segmentHitsBox(x1, y1, x2, y2, &allBoxes[i]) {
 			return true
 		}
 	}
 	return false
 }
 
+// segmentHitsBox checks if a normalized segment (x1 <= x2, y1 <= y2)
+// intersects a box, expanded slightly for stroke width
+func segmentHitsBox(x1, y1, x2, y2 int, box *placedBox) bool {
+	boxLeft := box.PixelX - boxCollisionBuffer
+	boxRight := box.PixelX + box.Width + boxCollisionBuffer
+	boxTop := box.PixelY - boxCollisionBuffer
+	boxBottom := box.PixelY + box.Height + boxCollisionBuffer
+
+	// For a line segment to intersect a box, it must overlap in both dimensions
+	xOverlap := x1 <= boxRight && x2 >= boxLeft
+	yOverlap := y1 <= boxBottom && y2 >= boxTop
+	return xOverlap && yOverlap
+}
+
 // checkPathCollision checks if a polyline path collides with any boxes.
 // points is a list of (x,y) pairs defining the path segments.
-func checkPathCollision(points []int, allBoxes []BoxData, fromID, toID string) bool {
-	excludeIDs := map[string]bool{fromID: true, toID: true}
+func checkPathCollision(points []int, allBoxes []placedBox, fromID, toID string) bool {
 	for i := 0; i < len(points)-2; i += 2 {
-		if checkSegmentCollision(points[i], points[i+1], points[i+2], points[i+3], allBoxes, excludeIDs) {
-			return true
+		x1, y1, x2, y2 := min(points[i], points[i+2]), min(points[i+1], points[i+3]), max(points[i], points[i+2]), max(points[i+1], points[i+3])
+		for j := range allBoxes {
+			if id := allBoxes[j].ID; id != fromID && id != toID && segmentHitsBox(x1, y1, x2, y2, &allBoxes[j]) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
-// BoxCoords represents the bounding box coordinates of a box
-type BoxCoords struct {
+// boxCoords represents the bounding box coordinates of a box
+type boxCoords struct {
 	X1, Y1, X2, Y2 int
 }
 
-// RoutingPlan represents the final routing plan for an arrow
-type RoutingPlan struct {
+// routingPlan represents the final routing plan for an arrow
+type routingPlan struct {
 	StartX, StartY, EndX, EndY int
 	Strategy                   string
 	VerticalFirst              bool
-	NumSegments                int // 1=straight, 2=L-shape, 3=Z-shape
-	AllCandidates              []RouteCandidate
+	NumSegments                int   // 1=straight, 2=L-shape, 3=Z-shape
+	Points                     []int // Polyline points [x0,y0,x1,y1,...] of the chosen route
+	AllCandidates              []routeCandidate
 }
 
-// RouteCandidate represents a potential arrow route
-type RouteCandidate struct {
+// routeCandidate represents a potential arrow route
+type routeCandidate struct {
 	startX, startY, endX, endY int
 	strategy                   string
 	verticalFirst              bool
@@ -159,12 +168,29 @@ type RouteCandidate struct {
 	boxWidth1, boxWidth2       int // Store box widths for scoring
 	rejected                   bool
 	rejectReason               string // "collision_detected", or empty if not rejected
-	flow                       string // Flow direction hint (e.g., "down")
+	flow                       string // Flow hint (e.g., FlowDown)
 	segments                   []int  // Polyline points [x0,y0,x1,y1,...] for collision detection
+	selected                   bool   // Chosen as the arrow's route
+}
+
+// routeCandidates is the number of route candidates an arrow usually has,
+// to size the candidate lists up front
+const routeCandidates = 8
+
+// routePoints hands out the polylines of an arrow's route candidates from one
+// backing array, instead of allocating the points of every candidate
+type routePoints []int
+
+// take appends points to the buffer and returns them, capped so that
+// appending to one polyline never writes into the next
+func (r *routePoints) take(points ...int) []int {
+	start := len(*r)
+	*r = append(*r, points...)
+	return (*r)[start:len(*r):len(*r)]
 }
 
 // scoreRoute assigns a quality score to a route (higher is better)
-func scoreRoute(candidate RouteCandidate) int {
+func scoreRoute(candidate routeCandidate) int {
 	score := 0
 
 	// Calculate width difference for narrow-to-wide transition detection
@@ -193,6 +219,23 @@ func scoreRoute(candidate RouteCandidate) int {
 		}
 	case "three_segment_horizontal_first", "three_segment_vertical_first":
 		score += 70
+	case "port_route":
+		// Below the fixed strategies, fewer bends and shorter detours first
+		score += 60 - 5*(len(candidate.segments)/2-1)
+		score -= (polylineLength(candidate.segments) - abs(candidate.endX-candidate.startX) - abs(candidate.endY-candidate.startY)) / 10
+	case "grid_route":
+		score += 50
+	case "self_loop":
+		// Prefer loops on the right, then top, bottom and left
+		score += 60
+		switch exitSide(candidate.segments) {
+		case SideRight:
+			score += 3
+		case SideTop:
+			score += 2
+		case SideBottom:
+			score++
+		}
 	}
 
 	// Distance penalty (Manhattan distance, max 50)
@@ -212,7 +255,8 @@ func scoreRoute(candidate RouteCandidate) int {
 	}
 
 	// Flow direction bonuses
-	if candidate.flow == "down" {
+	switch {
+	case verticalFlow(candidate.flow):
 		switch candidate.strategy {
 		case "straight_vertical":
 			score += 30
@@ -221,55 +265,122 @@ func scoreRoute(candidate RouteCandidate) int {
 		case "two_segment_vertical_first":
 			score += 15
 		}
+	case horizontalFlow(candidate.flow):
+		switch candidate.strategy {
+		case "non_overlapping_horizontal":
+			score += 30
+		case "three_segment_horizontal_first":
+			score += 40
+		case "two_segment_horizontal_first":
+			score += 15
+		}
 	}
 
 	return score
 }
 
-// validateAndAddCandidate validates a candidate route and adds it to the appropriate lists
+// verticalFlow reports whether a flow hint prefers vertical routes
+func verticalFlow(flow string) bool {
+	return flow == FlowDown || flow == FlowUp
+}
+
+// horizontalFlow reports whether a flow hint prefers horizontal routes
+func horizontalFlow(flow string) bool {
+	return flow == FlowLeft || flow == FlowRight || flow == FlowHorizontal
+}
+
+// validateAndAddCandidate validates a candidate route and adds it to the list,
+// rejected or not, for scoring and debugging
 // Returns true if the candidate is valid (not rejected)
 func validateAndAddCandidate(
-	candidate RouteCandidate,
-	allBoxes []BoxData,
+	candidate routeCandidate,
+	obstacles *obstacleIndex,
 	fromID, toID string,
-	candidates *[]RouteCandidate,
-	allCandidates *[]RouteCandidate,
+	allCandidates *[]routeCandidate,
 ) bool {
-	if checkPathCollision(candidate.segments, allBoxes, fromID, toID) {
+	if obstacles.pathCollides(candidate.segments, fromID, toID) {
 		candidate.rejected = true
 		candidate.rejectReason = "collision_detected"
 	}
-
-	// Add to allCandidates for debugging
 	*allCandidates = append(*allCandidates, candidate)
-
-	// If valid, add to candidates for scoring
-	if !candidate.rejected {
-		*candidates = append(*candidates, candidate)
-		return true
-	}
-	return false
+	return !candidate.rejected
 }
 
-// RouteArrow calculates arrow routing between two boxes
+// routeArrow calculates arrow routing between two boxes
 // This function generates ALL legal arrow routes from all strategies,
 // then chooses the best one based on quality scoring
 // Returns an error if no valid routing can be found
 // Also returns all candidates (both valid and rejected) for debug purposes
-func RouteArrow(
-	box1, box2 BoxCoords,
+func routeArrow(
+	box1, box2 boxCoords,
+	fromGridX, fromGridY, toGridX, toGridY int,
+	allBoxes []placedBox,
+	fromID, toID string,
+	flow string,
+) (*routingPlan, error) {
+	return routeArrowWithPorts(box1, box2, fromGridX, fromGridY, toGridX, toGridY, allBoxes, fromID, toID, flow, arrowPorts{})
+}
+
+// routeArrowWithPorts is routeArrow with explicit attachment sides.
+// Candidates leaving or entering on other sides are rejected, and extra
+// port routes are generated for side combinations no strategy covers.
+func routeArrowWithPorts(
+	box1, box2 boxCoords,
 	fromGridX, fromGridY, toGridX, toGridY int,
-	allBoxes []BoxData,
+	allBoxes []placedBox,
 	fromID, toID string,
 	flow string,
-) (*RoutingPlan, error) {
-	var candidates []RouteCandidate
-	allCandidates := make([]RouteCandidate, 0)
+	ports arrowPorts,
+) (*routingPlan, error) {
+	return routeArrowIndexed(box1, box2, fromGridX, fromGridY, toGridX, toGridY, newObstacleIndex(allBoxes), fromID, toID, flow, ports)
+}
+
+// routeArrowIndexed is routeArrowWithPorts against an obstacle index, so
+// callers routing many arrows build the index only once
+func routeArrowIndexed(
+	box1, box2 boxCoords,
+	fromGridX, fromGridY, toGridX, toGridY int,
+	obstacles *obstacleIndex,
+	fromID, toID string,
+	flow string,
+	ports arrowPorts,
+) (*routingPlan, error) {
+	valid := 0 // Candidates not rejected
+	allCandidates := make([]routeCandidate, 0, routeCandidates)
+	polylines := make(routePoints, 0, 8*routeCandidates)
+
+	// add rejects candidates not using the requested ports before collision checking
+	add := func(candidate routeCandidate) {
+		if !ports.allow(candidate.segments) {
+			candidate.rejected = true
+			candidate.rejectReason = "port_mismatch"
+			allCandidates = append(allCandidates, candidate)
+			return
+		}
+		if validateAndAddCandidate(candidate, obstacles, fromID, toID, &allCandidates) {
+			valid++
+		}
+	}
 
 	// Calculate box widths for scoring
 	boxWidth1 := box1.X2 - box1.X1
 	boxWidth2 := box2.X2 - box2.X1
 
+	// Self-loop: a rectangular loop off one side of the box
+	if fromID == toID {
+		for _, side := range selfLoopSides(ports) {
+			points := selfLoop(box1, side)
+			candidate := routeCandidate{
+				startX: points[0], startY: points[1],
+				endX: points[len(points)-2], endY: points[len(points)-1],
+				strategy: "self_loop", verticalFirst: points[0] == points[2],
+				boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
+				segments: points,
+			}
+			add(candidate)
+		}
+	}
+
 	// Strategy 1: Same column - straight vertical arrow
 	if fromGridX == toGridX && fromGridY != toGridY {
 		fromCenterX := (box1.X1 + box1.X2) / 2
@@ -282,20 +393,20 @@ func RouteArrow(
 		if toGridY > fromGridY {
 			// Going down
 			sy = box1.Y2
-			ey = box2.Y1 - STROKE_ADJUSTMENT
+			ey = box2.Y1 - strokeAdjustment
 		} else {
 			// Going up
 			sy = box1.Y1
-			ey = box2.Y2 + STROKE_ADJUSTMENT
+			ey = box2.Y2 + strokeAdjustment
 		}
 
-		candidate := RouteCandidate{
+		candidate := routeCandidate{
 			startX: sx, startY: sy, endX: ex, endY: ey,
 			strategy: "straight_vertical", verticalFirst: true,
 			boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-			segments: []int{sx, sy, ex, ey},
+			segments: polylines.take(sx, sy, ex, ey),
 		}
-		validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+		add(candidate)
 	}
 
 	// Strategy 1b: Same column - horizontal-first 2-segment routing (horizontal then vertical)
@@ -316,19 +427,19 @@ func RouteArrow(
 
 		// Enter vertically into destination
 		if toGridY > fromGridY {
-			ey = box2.Y1 - STROKE_ADJUSTMENT // Enter from top
+			ey = box2.Y1 - strokeAdjustment // Enter from top
 		} else {
-			ey = box2.Y2 + STROKE_ADJUSTMENT // Enter from bottom
+			ey = box2.Y2 + strokeAdjustment // Enter from bottom
 		}
 		ex = toCenterX
 
-		candidate := RouteCandidate{
+		candidate := routeCandidate{
 			startX: sx, startY: sy, endX: ex, endY: ey,
 			strategy: "two_segment_horizontal_first", verticalFirst: false,
 			boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-			segments: []int{sx, sy, ex, sy, ex, ey},
+			segments: polylines.take(sx, sy, ex, sy, ex, ey),
 		}
-		validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+		add(candidate)
 	}
 
 	// Strategy 2: Vertical-first 2-segment routing (vertical then horizontal)
@@ -351,19 +462,19 @@ func RouteArrow(
 
 		// Enter horizontally into destination
 		if toGridX > fromGridX {
-			ex = box2.X1 - STROKE_ADJUSTMENT // Enter from left
+			ex = box2.X1 - strokeAdjustment // Enter from left
 		} else {
-			ex = box2.X2 + STROKE_ADJUSTMENT // Enter from right
+			ex = box2.X2 + strokeAdjustment // Enter from right
 		}
 		ey = toCenterY
 
-		candidate := RouteCandidate{
+		candidate := routeCandidate{
 			startX: sx, startY: sy, endX: ex, endY: ey,
 			strategy: "two_segment_vertical_first", verticalFirst: true,
 			boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-			segments: []int{sx, sy, sx, ey, ex, ey},
+			segments: polylines.take(sx, sy, sx, ey, ex, ey),
 		}
-		validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+		add(candidate)
 	}
 
 	// Strategy 3: Horizontal-first 3-segment routing (horizontal, vertical, horizontal)
@@ -375,21 +486,21 @@ func RouteArrow(
 		if toGridX > fromGridX {
 			// Forward arrow (left to right)
 			sx = box1.X2
-			ex = box2.X1 - STROKE_ADJUSTMENT
+			ex = box2.X1 - strokeAdjustment
 		} else {
 			// Backward arrow (right to left)
 			sx = box1.X1
-			ex = box2.X2 + STROKE_ADJUSTMENT
+			ex = box2.X2 + strokeAdjustment
 		}
 
 		midX := (sx + ex) / 2
-		candidate := RouteCandidate{
+		candidate := routeCandidate{
 			startX: sx, startY: fromCenterY, endX: ex, endY: toCenterY,
 			strategy: "three_segment_horizontal_first", verticalFirst: false,
 			boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-			segments: []int{sx, fromCenterY, midX, fromCenterY, midX, toCenterY, ex, toCenterY},
+			segments: polylines.take(sx, fromCenterY, midX, fromCenterY, midX, toCenterY, ex, toCenterY),
 		}
-		validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+		add(candidate)
 	}
 
 	// Strategy 4: Non-overlapping horizontal routing (straight or 3-segment)
@@ -401,30 +512,64 @@ func RouteArrow(
 		if toGridX > fromGridX {
 			// Forward arrow (left to right)
 			sx = box1.X2
-			ex = box2.X1 - STROKE_ADJUSTMENT
+			ex = box2.X1 - strokeAdjustment
 		} else {
 			// Backward arrow (right to left)
 			sx = box1.X1
-			ex = box2.X2 + STROKE_ADJUSTMENT
+			ex = box2.X2 + strokeAdjustment
 		}
 
 		// Only generate candidate if there's actual horizontal space between boxes
 		// (boxes don't overlap in the direction of travel)
 		if (toGridX > fromGridX && sx < ex) || (toGridX < fromGridX && sx > ex) {
 			midX := (sx + ex) / 2
-			candidate := RouteCandidate{
+			candidate := routeCandidate{
 				startX: sx, startY: fromCenterY, endX: ex, endY: toCenterY,
 				strategy: "non_overlapping_horizontal", verticalFirst: false,
 				boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-				segments: []int{sx, fromCenterY, midX, fromCenterY, midX, toCenterY, ex, toCenterY},
+				segments: polylines.take(sx, fromCenterY, midX, fromCenterY, midX, toCenterY, ex, toCenterY),
+			}
+			add(candidate)
+		}
+	}
+
+	// Strategy 4b: Horizontal-first 2-segment routing (horizontal then vertical)
+	// Only generated for horizontal flows, the counterpart of Strategy 2
+	if fromGridX != toGridX && fromGridY != toGridY && horizontalFlow(flow) {
+		fromCenterY := (box1.Y1 + box1.Y2) / 2
+		toCenterX := (box2.X1 + box2.X2) / 2
+
+		var sx, ey int
+
+		// Exit horizontally from source
+		if toGridX > fromGridX {
+			sx = box1.X2 // Exit from right
+		} else {
+			sx = box1.X1 // Exit from left
+		}
+
+		// Enter vertically into destination
+		if toGridY > fromGridY {
+			ey = box2.Y1 - strokeAdjustment // Enter from top
+		} else {
+			ey = box2.Y2 + strokeAdjustment // Enter from bottom
+		}
+
+		// Only if the target's center lies beyond the exit side (wide boxes may overlap)
+		if (toGridX > fromGridX && toCenterX > sx) || (toGridX < fromGridX && toCenterX < sx) {
+			candidate := routeCandidate{
+				startX: sx, startY: fromCenterY, endX: toCenterX, endY: ey,
+				strategy: "two_segment_horizontal_first", verticalFirst: false,
+				boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
+				segments: polylines.take(sx, fromCenterY, toCenterX, fromCenterY, toCenterX, ey),
 			}
-			validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+			add(candidate)
 		}
 	}
 
 	// Strategy 5: Vertical-first 3-segment routing (vertical, horizontal, vertical)
-	// Only generated when flow == "down" for top-down org chart style arrows
-	if fromGridX != toGridX && fromGridY != toGridY && flow == "down" {
+	// Only generated for vertical flows, for org chart style arrows
+	if fromGridX != toGridX && fromGridY != toGridY && verticalFlow(flow) {
 		fromCenterX := (box1.X1 + box1.X2) / 2
 		toCenterX := (box2.X1 + box2.X2) / 2
 
@@ -432,51 +577,85 @@ func RouteArrow(
 		if toGridY > fromGridY {
 			// Going down: exit bottom, enter top
 			sy = box1.Y2
-			ey = box2.Y1 - STROKE_ADJUSTMENT
+			ey = box2.Y1 - strokeAdjustment
 		} else {
 			// Going up: exit top, enter bottom
 			sy = box1.Y1
-			ey = box2.Y2 + STROKE_ADJUSTMENT
+			ey = box2.Y2 + strokeAdjustment
 		}
 
 		midY := (sy + ey) / 2
-		candidate := RouteCandidate{
+		candidate := routeCandidate{
 			startX: fromCenterX, startY: sy, endX: toCenterX, endY: ey,
 			strategy: "three_segment_vertical_first", verticalFirst: true,
 			boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
-			segments: []int{fromCenterX, sy, fromCenterX, midY, toCenterX, midY, toCenterX, ey},
+			segments: polylines.take(fromCenterX, sy, fromCenterX, midY, toCenterX, midY, toCenterX, ey),
 		}
-		validateAndAddCandidate(candidate, allBoxes, fromID, toID, &candidates, &allCandidates)
+		add(candidate)
 	}
 
-	// If no valid candidates, return error
-	if len(candidates) == 0 {
-		return nil, fmt.Errorf("no valid arrow routing found from %s to %s (all strategies failed or produced illegal arrows)", fromID, toID)
+	// Strategy 6: Port routes - short stubs out of the requested sides, joined by
+	// an L or Z shape. Only generated when ports are requested.
+	if (ports.From != "" || ports.To != "") && fromID != toID {
+		endBoxes := []placedBox{boxCoordsData(box1), boxCoordsData(box2)}
+		for _, fromSide := range sidesOrAll(ports.From) {
+			for _, toSide := range sidesOrAll(ports.To) {
+				for _, points := range portRoutes(box1, box2, fromSide, toSide) {
+					// The stubs clear both boxes, so the rest of the route must not cross them
+					if checkPathCollision(points[2:len(points)-2], endBoxes, "", "") {
+						continue
+					}
+					points = simplifyPolyline(points)
+					candidate := routeCandidate{
+						startX: points[0], startY: points[1],
+						endX: points[len(points)-2], endY: points[len(points)-1],
+						strategy: "port_route", verticalFirst: points[0] == points[2],
+						boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
+						segments: points,
+					}
+					add(candidate)
+				}
+			}
+		}
 	}
 
-	// Score all candidates and find the best one
-	bestIdx := 0
-	candidates[0].score = scoreRoute(candidates[0])
-	bestScore := candidates[0].score
+	// Strategy 7: Grid route - fallback when every other route collides.
+	// Searches a path around all obstacles, so any two boxes can be connected.
+	if valid == 0 {
+		if points := gridRoute(box1, box2, obstacles, fromID, toID, ports); points != nil {
+			candidate := routeCandidate{
+				startX: points[0], startY: points[1],
+				endX: points[len(points)-2], endY: points[len(points)-1],
+				strategy: "grid_route", verticalFirst: points[0] == points[2],
+				boxWidth1: boxWidth1, boxWidth2: boxWidth2, flow: flow,
+				segments: points,
+			}
+			add(candidate)
+		}
+	}
 
-	for i := 1; i < len(candidates); i++ {
-		candidates[i].score = scoreRoute(candidates[i])
-		if candidates[i].score > bestScore {
-			bestScore = candidates[i].score
-			bestIdx = i
+	// If no valid candidates, return error
+	if valid == 0 {
+		if ports.From != "" || ports.To != "" {
+			return nil, fmt.Errorf("no collision-free arrow route from %s to %s uses the requested ports", ports.label(fromID, ports.From), ports.label(toID, ports.To))
 		}
+		return nil, fmt.Errorf("no valid arrow routing found from %s to %s (all strategies failed or produced illegal arrows)", fromID, toID)
 	}
 
-	// Update allCandidates with scores for valid candidates
+	// Score the valid candidates and mark the best one as the chosen route
+	bestIdx := -1
 	for i := range allCandidates {
-		if !allCandidates[i].rejected {
-			allCandidates[i].score = scoreRoute(allCandidates[i])
+		if allCandidates[i].rejected {
+			continue
+		}
+		allCandidates[i].score = scoreRoute(allCandidates[i])
+		if bestIdx < 0 || allCandidates[i].score > allCandidates[bestIdx].score {
+			bestIdx = i
 		}
 	}
-
-	// Return the best routing plan
-	best := candidates[bestIdx]
-	return &RoutingPlan{
+	allCandidates[bestIdx].selected = true
+	best := allCandidates[bestIdx]
+	return &routingPlan{
 		StartX:        best.startX,
 		StartY:        best.startY,
 		EndX:          best.endX,
@@ -484,6 +663,192 @@ func RouteArrow(
 		Strategy:      best.strategy,
 		VerticalFirst: best.verticalFirst,
 		NumSegments:   len(best.segments)/2 - 1,
+		Points:        best.segments,
 		AllCandidates: allCandidates,
 	}, nil
 }
+
+// arrowPorts holds the requested attachment sides of an arrow
+// (SideTop, SideBottom, SideLeft, SideRight; "" = any side)
+type arrowPorts struct {
+	From, To string
+}
+
+// allow reports whether a route polyline leaves and enters on the requested sides
+func (p arrowPorts) allow(points []int) bool {
+	return (p.From == "" || exitSide(points) == p.From) && (p.To == "" || entrySide(points) == p.To)
+}
+
+// label formats a box ID with its port for error messages ("A.bottom")
+func (p arrowPorts) label(id, side string) string {
+	if side == "" {
+		return id
+	}
+	return id + "." + side
+}
+
+// exitSide returns the side of the source box a route leaves from,
+// derived from the direction of its first non-empty segment
+func exitSide(points []int) string {
+	for i := 0; i+3 < len(points); i += 2 {
+		if side := segmentSide(points[i], points[i+1], points[i+2], points[i+3]); side != "" {
+			return side
+		}
+	}
+	return ""
+}
+
+// entrySide returns the side of the target box a route enters on,
+// opposite to the direction of its last non-empty segment
+func entrySide(points []int) string {
+	for i := len(points) - 4; i >= 0; i -= 2 {
+		if side := segmentSide(points[i], points[i+1], points[i+2], points[i+3]); side != "" {
+			return oppositeSide(side)
+		}
+	}
+	return ""
+}
+
+// segmentSide names the direction of an orthogonal segment as the box side
+// it points to ("" for an empty segment)
+func segmentSide(x1, y1, x2, y2 int) string {
+	switch {
+	case y2 > y1:
+		return SideBottom
+	case y2 < y1:
+		return SideTop
+	case x2 > x1:
+		return SideRight
+	case x2 < x1:
+		return SideLeft
+	}
+	return ""
+}
+
+// oppositeSide returns the side facing the given one
+func oppositeSide(side string) string {
+	switch side {
+	case SideTop:
+		return SideBottom
+	case SideBottom:
+		return SideTop
+	case SideLeft:
+		return SideRight
+	}
+	return SideLeft
+}
+
+// sidesOrAll returns the requested side, or all four sides if none is requested
+func sidesOrAll(side string) []string {
+	if side != "" {
+		return []string{side}
+	}
+	return []string{SideTop, SideBottom, SideLeft, SideRight}
+}
+
+// sideAnchor returns the middle of a box side, moved outwards by offset,
+// and the outward direction of that side
+func sideAnchor(box boxCoords, side string, offset int) (x, y, dx, dy int) {
+	switch side {
+	case SideTop:
+		return (box.X1 + box.X2) / 2, box.Y1 - offset, 0, -1
+	case SideBottom:
+		return (box.X1 + box.X2) / 2, box.Y2 + offset, 0, 1
+	case SideLeft:
+		return box.X1 - offset, (box.Y1 + box.Y2) / 2, -1, 0
+	}
+	return box.X2 + offset, (box.Y1 + box.Y2) / 2, 1, 0
+}
+
+// portRoutes returns the unsimplified route polylines from a side of box1 to a
+// side of box2: a stub out of each side, joined by an L (both corners) or a Z
+// (split at the middle in either direction)
+func portRoutes(box1, box2 boxCoords, fromSide, toSide string) [][]int {
+	sx, sy, dx1, dy1 := sideAnchor(box1, fromSide, 0)
+	ex, ey, dx2, dy2 := sideAnchor(box2, toSide, strokeAdjustment)
+	ax, ay := sx+dx1*portStubLength, sy+dy1*portStubLength
+	bx, by := ex+dx2*portStubLength, ey+dy2*portStubLength
+	midX, midY := (ax+bx)/2, (ay+by)/2
+
+	bends := [][]int{
+		{bx, ay},
+		{ax, by},
+		{midX, ay, midX, by},
+		{ax, midY, bx, midY},
+	}
+	routes := make([][]int, 0, len(bends))
+	for _, bend := range bends {
+		points := []int{sx, sy, ax, ay}
+		points = append(points, bend...)
+		points = append(points, bx, by, ex, ey)
+		routes = append(routes, points)
+	}
+	return routes
+}
+
+// boxCoordsData converts box bounds to placedBox for collision checks
+func boxCoordsData(box boxCoords) placedBox {
+	return placedBox{
+		PixelX: box.X1,
+		PixelY: box.Y1,
+		Width:  box.X2 - box.X1,
+		Height: box.Y2 - box.Y1,
+	}
+}
+
+// boxDataCoords returns the bounding box of a placed box
+func boxDataCoords(box placedBox) boxCoords {
+	return boxCoords{
+		X1: box.PixelX,
+		Y1: box.PixelY,
+		X2: box.PixelX + box.Width,
+		Y2: box.PixelY + box.Height,
+	}
+}
+
+// polylineLength returns the total length of an orthogonal polyline
+func polylineLength(points []int) int {
+	length := 0
+	for i := 0; i+3 < len(points); i += 2 {
+		length += abs(points[i+2]-points[i]) + abs(points[i+3]-points[i+1])
+	}
+	return length
+}
+
+// selfLoopSides returns the sides a self-loop may use: the side both ports
+// name, or all sides in order of preference. Returns nil if the ports name
+// different sides, as a loop leaves and re-enters the same side.
+func selfLoopSides(ports arrowPorts) []string {
+	if side := ports.From; side != "" || ports.To != "" {
+		if side == "" {
+			side = ports.To
+		}
+		if ports.To != "" && ports.To != side {
+			return nil
+		}
+		return []string{side}
+	}
+	return []string{SideRight, SideTop, SideBottom, SideLeft}
+}
+
+// selfLoop returns the polyline of a rectangular loop leaving a box side and
+// re-entering it further clockwise, extending selfLoopSize from the side
+func selfLoop(box boxCoords, side string) []int {
+	cx, cy, dx, dy := sideAnchor(box, side, 0)
+	px, py := -dy, dx // Along the side, clockwise around the box
+
+	length := box.X2 - box.X1
+	if side == SideLeft || side == SideRight {
+		length = box.Y2 - box.Y1
+	}
+	d := min(length/4, selfLoopSize/2)
+
+	sx, sy := cx-d*px, cy-d*py
+	ex, ey := cx+d*px, cy+d*py
+	return []int{
+		sx, sy,
+		sx + dx*selfLoopSize, sy + dy*selfLoopSize,
+		ex + dx*selfLoopSize, ey + dy*selfLoopSize,
+		ex + dx*strokeAdjustment, ey + dy*strokeAdjustment,
+	}
+}
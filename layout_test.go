@@ -1,9 +1,16 @@
-package main
+package control
 
-import "testing"
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
 
 func TestCalculateDimensions(t *testing.T) {
-	config := NewDefaultConfig()
+	config := newDefaultConfig()
 
 	tests := []struct {
 		name       string
@@ -30,7 +37,7 @@ func TestCalculateDimensions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			dims := CalculateDimensions(tt.maxGridX, tt.maxGridY, config)
+			dims := calculateDimensions(tt.maxGridX, tt.maxGridY, config)
 
 			if dims.Width != tt.wantWidth {
 				t.Errorf("Width = %d, want %d", dims.Width, tt.wantWidth)
@@ -49,9 +56,9 @@ func TestCalculateDimensions(t *testing.T) {
 }
 
 func TestRouteArrow_SameColumn(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 bounds
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 bounds
 		4, 1, // fromGridX, fromGridY (GridY=1 is top)
 		4, 4, // toGridX, toGridY (GridY=4 is bottom)
 		nil, "0", "1", "", // allBoxes, fromID, toID, flow (no collision detection for this test)
@@ -72,9 +79,9 @@ func TestRouteArrow_SameColumn(t *testing.T) {
 }
 
 func TestRouteArrow_OverlappingColumns(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
-		BoxCoords{X1: 200, Y1: 200, X2: 300, Y2: 300}, // Box 2 bounds
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
+		boxCoords{X1: 200, Y1: 200, X2: 300, Y2: 300}, // Box 2 bounds
 		3, 1, // fromGridX, fromGridY (GridY=1 is top)
 		4, 2, // toGridX, toGridY (GridY=2 is below)
 		nil, "0", "1", "", // allBoxes, fromID, toID, flow (no collision detection for this test)
@@ -103,9 +110,9 @@ func TestRouteArrow_OverlappingColumns(t *testing.T) {
 }
 
 func TestRouteArrow_NonOverlapping(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
-		BoxCoords{X1: 300, Y1: 100, X2: 400, Y2: 200}, // Box 2 bounds
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 bounds
+		boxCoords{X1: 300, Y1: 100, X2: 400, Y2: 200}, // Box 2 bounds
 		1, 2, // fromGridX, fromGridY
 		3, 2, // toGridX, toGridY
 		nil, "0", "1", "", // allBoxes, fromID, toID, flow (no collision detection for this test)
@@ -142,8 +149,8 @@ func TestLayout_SimpleSpec(t *testing.T) {
 		},
 	}
 
-	config := NewDefaultConfig()
-	diagram, _ := Layout(spec, config, nil, nil, "")
+	config := newDefaultConfig()
+	diagram, _ := layout(spec, config, nil, nil, "")
 
 	if len(diagram.Boxes) != 2 {
 		t.Errorf("Expected 2 boxes, got %d", len(diagram.Boxes))
@@ -290,9 +297,9 @@ func TestColorConversionRoundTrip(t *testing.T) {
 // TestRouteArrow_NarrowToWide tests horizontal-first routing for narrow-to-wide transitions
 func TestRouteArrow_NarrowToWide(t *testing.T) {
 	// Narrow box (width=20) to wide box (width=200) in same column
-	plan, err := RouteArrow(
-		BoxCoords{X1: 930, Y1: 650, X2: 950, Y2: 750},  // Narrow box: 20px wide
-		BoxCoords{X1: 930, Y1: 800, X2: 1155, Y2: 900}, // Wide box: 225px wide
+	plan, err := routeArrow(
+		boxCoords{X1: 930, Y1: 650, X2: 950, Y2: 750},  // Narrow box: 20px wide
+		boxCoords{X1: 930, Y1: 800, X2: 1155, Y2: 900}, // Wide box: 225px wide
 		8, 5, // fromGridX, fromGridY (same column)
 		8, 6, // toGridX, toGridY (below)
 		nil, "narrow", "wide", "",
@@ -326,9 +333,9 @@ func TestRouteArrow_NarrowToWide(t *testing.T) {
 // TestRouteArrow_WideToNarrow tests routing from wide to narrow box
 func TestRouteArrow_WideToNarrow(t *testing.T) {
 	// Just verify it doesn't error - specific routing depends on layout
-	_, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Wide box: 200px wide
-		BoxCoords{X1: 180, Y1: 300, X2: 200, Y2: 400}, // Narrow box: 20px wide, centered below wide box
+	_, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Wide box: 200px wide
+		boxCoords{X1: 180, Y1: 300, X2: 200, Y2: 400}, // Narrow box: 20px wide, centered below wide box
 		1, 1, // fromGridX, fromGridY
 		1, 2, // toGridX, toGridY (same column, below)
 		nil, "wide", "narrow", "",
@@ -340,9 +347,9 @@ func TestRouteArrow_WideToNarrow(t *testing.T) {
 
 // TestRouteArrow_ThreeSegment tests 3-segment routing for non-overlapping boxes
 func TestRouteArrow_ThreeSegment(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 1
-		BoxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 2 (non-overlapping, deltaX >= 2)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 1
+		boxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 2 (non-overlapping, deltaX >= 2)
 		1, 3, // fromGridX, fromGridY
 		4, 1, // toGridX, toGridY
 		nil, "box1", "box2", "",
@@ -375,14 +382,14 @@ func TestRouteArrow_ThreeSegment(t *testing.T) {
 // TestRouteArrow_CollisionDetection tests arrow routing with multiple boxes
 func TestRouteArrow_CollisionDetection(t *testing.T) {
 	// Create realistic box layout
-	allBoxes := []BoxData{
+	allBoxes := []placedBox{
 		{ID: "from", GridX: 1, GridY: 2, PixelX: 100, PixelY: 200, Width: 100, Height: 100, CenterX: 150, CenterY: 250},
 		{ID: "to", GridX: 3, GridY: 2, PixelX: 400, PixelY: 200, Width: 100, Height: 100, CenterX: 450, CenterY: 250},
 	}
 
-	_, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 200, X2: 200, Y2: 300}, // From box
-		BoxCoords{X1: 400, Y1: 200, X2: 500, Y2: 300}, // To box (non-overlapping)
+	_, err := routeArrow(
+		boxCoords{X1: 100, Y1: 200, X2: 200, Y2: 300}, // From box
+		boxCoords{X1: 400, Y1: 200, X2: 500, Y2: 300}, // To box (non-overlapping)
 		1, 2, // fromGridX, fromGridY
 		3, 2, // toGridX, toGridY (deltaX = 2, non-overlapping)
 		allBoxes, "from", "to", "",
@@ -396,9 +403,9 @@ func TestRouteArrow_CollisionDetection(t *testing.T) {
 
 // TestRouteArrow_SameDimensions tests routing between boxes with same dimensions
 func TestRouteArrow_SameDimensions(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1: 100x100
-		BoxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 2: 100x100 (same width)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1: 100x100
+		boxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 2: 100x100 (same width)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY (same column)
 		nil, "box1", "box2", "",
@@ -417,14 +424,14 @@ func TestRouteArrow_SameDimensions(t *testing.T) {
 func TestRouteArrow_ErrorCase(t *testing.T) {
 	// Try to route with all strategies blocked by collisions
 	// This is a synthetic test - in practice we always find a route
-	allBoxes := []BoxData{
+	allBoxes := []placedBox{
 		{ID: "from", GridX: 1, GridY: 1, PixelX: 100, PixelY: 100, Width: 100, Height: 100},
 		{ID: "to", GridX: 1, GridY: 3, PixelX: 100, PixelY: 300, Width: 100, Height: 100},
 	}
 
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
-		BoxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // To box (same column)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
+		boxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // To box (same column)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY
 		allBoxes, "from", "to", "",
@@ -442,9 +449,9 @@ func TestRouteArrow_ErrorCase(t *testing.T) {
 // TestRouteArrow_ExtremeNarrowToWide tests extreme width ratio transitions
 func TestRouteArrow_ExtremeNarrowToWide(t *testing.T) {
 	// 10px wide box to 200px wide box (20x width difference)
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 110, Y2: 200}, // Very narrow box: 10px wide
-		BoxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Wide box: 200px wide
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 110, Y2: 200}, // Very narrow box: 10px wide
+		boxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Wide box: 200px wide
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY (same column)
 		nil, "narrow", "wide", "",
@@ -477,9 +484,9 @@ func TestRouteArrow_ExtremeNarrowToWide(t *testing.T) {
 // TestRouteArrow_BackwardArrow tests that backward (right-to-left) arrows are routed successfully
 func TestRouteArrow_BackwardArrow(t *testing.T) {
 	// Box on right pointing to box on left - should succeed
-	plan, err := RouteArrow(
-		BoxCoords{X1: 300, Y1: 100, X2: 400, Y2: 200}, // From box (right)
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // To box (left)
+	plan, err := routeArrow(
+		boxCoords{X1: 300, Y1: 100, X2: 400, Y2: 200}, // From box (right)
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // To box (left)
 		3, 2, // fromGridX, fromGridY
 		1, 2, // toGridX, toGridY (going left)
 		nil, "right", "left", "",
@@ -496,9 +503,9 @@ func TestRouteArrow_BackwardArrow(t *testing.T) {
 
 // TestRouteArrow_HorizontalAlignment tests boxes at same Y level
 func TestRouteArrow_HorizontalAlignment(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 200, X2: 200, Y2: 300}, // Box 1
-		BoxCoords{X1: 400, Y1: 200, X2: 500, Y2: 300}, // Box 2 (same vertical position)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 200, X2: 200, Y2: 300}, // Box 1
+		boxCoords{X1: 400, Y1: 200, X2: 500, Y2: 300}, // Box 2 (same vertical position)
 		1, 2, // fromGridX, fromGridY
 		4, 2, // toGridX, toGridY (same row)
 		nil, "box1", "box2", "",
@@ -533,9 +540,9 @@ func TestRouteArrow_HorizontalAlignment(t *testing.T) {
 
 // TestRouteArrow_DiagonalBoxes tests boxes at different X and Y positions
 func TestRouteArrow_DiagonalBoxes(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 (top-left)
-		BoxCoords{X1: 400, Y1: 400, X2: 500, Y2: 500}, // Box 2 (bottom-right, diagonal)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 (top-left)
+		boxCoords{X1: 400, Y1: 400, X2: 500, Y2: 500}, // Box 2 (bottom-right, diagonal)
 		1, 1, // fromGridX, fromGridY
 		4, 4, // toGridX, toGridY
 		nil, "topleft", "bottomright", "",
@@ -557,9 +564,9 @@ func TestRouteArrow_DiagonalBoxes(t *testing.T) {
 
 // TestRouteArrow_IdenticalWidths tests boxes with exactly the same width
 func TestRouteArrow_IdenticalWidths(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Box 1: 200px wide
-		BoxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2: 200px wide (identical)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Box 1: 200px wide
+		boxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2: 200px wide (identical)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY (same column)
 		nil, "box1", "box2", "",
@@ -576,9 +583,9 @@ func TestRouteArrow_IdenticalWidths(t *testing.T) {
 
 // TestRouteArrow_SlightWidthDifference tests width below narrow-to-wide threshold
 func TestRouteArrow_SlightWidthDifference(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1: 100px wide
-		BoxCoords{X1: 100, Y1: 300, X2: 250, Y2: 400}, // Box 2: 150px wide (1.5x, below 2x threshold)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1: 100px wide
+		boxCoords{X1: 100, Y1: 300, X2: 250, Y2: 400}, // Box 2: 150px wide (1.5x, below 2x threshold)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY (same column)
 		nil, "box1", "box2", "",
@@ -595,9 +602,9 @@ func TestRouteArrow_SlightWidthDifference(t *testing.T) {
 
 // TestRouteArrow_WideToExtremelyNarrow tests reverse extreme ratio
 func TestRouteArrow_WideToExtremelyNarrow(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Wide box: 200px wide
-		BoxCoords{X1: 150, Y1: 300, X2: 250, Y2: 400}, // Narrow box: 100px wide (centered below)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Wide box: 200px wide
+		boxCoords{X1: 150, Y1: 300, X2: 250, Y2: 400}, // Narrow box: 100px wide (centered below)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY (same column)
 		nil, "wide", "narrow", "",
@@ -614,9 +621,9 @@ func TestRouteArrow_WideToExtremelyNarrow(t *testing.T) {
 
 // TestRouteArrow_AdjacentColumnsOverlapping tests deltaX=1 with column overlap
 func TestRouteArrow_AdjacentColumnsOverlapping(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Box 1
-		BoxCoords{X1: 250, Y1: 300, X2: 450, Y2: 400}, // Box 2 (overlaps horizontally with Box 1)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}, // Box 1
+		boxCoords{X1: 250, Y1: 300, X2: 450, Y2: 400}, // Box 2 (overlaps horizontally with Box 1)
 		1, 1, // fromGridX, fromGridY
 		2, 3, // toGridX, toGridY (deltaX=1, adjacent)
 		nil, "box1", "box2", "",
@@ -637,9 +644,9 @@ func TestRouteArrow_AdjacentColumnsOverlapping(t *testing.T) {
 
 // TestRouteArrow_AdjacentColumnsNonOverlapping tests deltaX=1 without column overlap
 func TestRouteArrow_AdjacentColumnsNonOverlapping(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 (ends at x=200)
-		BoxCoords{X1: 250, Y1: 100, X2: 350, Y2: 200}, // Box 2 (starts at x=250, no overlap)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1 (ends at x=200)
+		boxCoords{X1: 250, Y1: 100, X2: 350, Y2: 200}, // Box 2 (starts at x=250, no overlap)
 		1, 2, // fromGridX, fromGridY
 		2, 2, // toGridX, toGridY (deltaX=1, same row)
 		nil, "box1", "box2", "",
@@ -657,9 +664,9 @@ func TestRouteArrow_AdjacentColumnsNonOverlapping(t *testing.T) {
 // TestRouteArrow_VeryLongDistance tests distance penalty on scoring
 func TestRouteArrow_VeryLongDistance(t *testing.T) {
 	// Boxes very far apart (distance > 500px)
-	_, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
-		BoxCoords{X1: 800, Y1: 600, X2: 900, Y2: 700}, // Box 2 (700px horizontal, 500px vertical apart)
+	_, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
+		boxCoords{X1: 800, Y1: 600, X2: 900, Y2: 700}, // Box 2 (700px horizontal, 500px vertical apart)
 		1, 1, // fromGridX, fromGridY
 		8, 6, // toGridX, toGridY
 		nil, "box1", "box2", "",
@@ -674,14 +681,14 @@ func TestRouteArrow_VeryLongDistance(t *testing.T) {
 // TestRouteArrow_MultipleObstacles tests routing around several boxes
 func TestRouteArrow_MultipleObstacles(t *testing.T) {
 	// Create a field of obstacle boxes
-	allBoxes := []BoxData{
+	allBoxes := []placedBox{
 		{ID: "from", GridX: 1, GridY: 1, PixelX: 100, PixelY: 100, Width: 100, Height: 100, CenterX: 150, CenterY: 150},
 		{ID: "to", GridX: 5, GridY: 1, PixelX: 500, PixelY: 100, Width: 100, Height: 100, CenterX: 550, CenterY: 150},
 	}
 
-	_, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
-		BoxCoords{X1: 500, Y1: 100, X2: 600, Y2: 200}, // To box (far to the right, same row)
+	_, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
+		boxCoords{X1: 500, Y1: 100, X2: 600, Y2: 200}, // To box (far to the right, same row)
 		1, 1, // fromGridX, fromGridY
 		5, 1, // toGridX, toGridY
 		allBoxes, "from", "to", "",
@@ -695,9 +702,9 @@ func TestRouteArrow_MultipleObstacles(t *testing.T) {
 
 // TestRouteArrow_ForceThreeSegment tests scenario that requires 3-segment routing
 func TestRouteArrow_ForceThreeSegment(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 1 (bottom-left)
-		BoxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 2 (top-right)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 1 (bottom-left)
+		boxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 2 (top-right)
 		1, 4, // fromGridX, fromGridY
 		4, 1, // toGridX, toGridY (going up and right)
 		nil, "bottomleft", "topright", "",
@@ -720,9 +727,9 @@ func TestRouteArrow_ForceThreeSegment(t *testing.T) {
 // TestRouteArrow_ScoringPreference tests that scoring correctly selects best strategy
 func TestRouteArrow_ScoringPreference(t *testing.T) {
 	// Scenario where multiple strategies are valid - verify correct one is chosen
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (same column, well separated)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (same column, well separated)
 		1, 1, // fromGridX, fromGridY
 		1, 4, // toGridX, toGridY
 		nil, "box1", "box2", "",
@@ -740,15 +747,15 @@ func TestRouteArrow_ScoringPreference(t *testing.T) {
 // TestRouteArrow_BoxInPath tests obstacle directly between source and destination
 func TestRouteArrow_BoxInPath(t *testing.T) {
 	// Box directly in the path blocks straight vertical - should fall back to horizontal-first
-	allBoxes := []BoxData{
+	allBoxes := []placedBox{
 		{ID: "from", GridX: 1, GridY: 1, PixelX: 100, PixelY: 100, Width: 100, Height: 100, CenterX: 150, CenterY: 150},
 		{ID: "obstacle", GridX: 1, GridY: 2, PixelX: 100, PixelY: 250, Width: 100, Height: 100, CenterX: 150, CenterY: 300},
 		{ID: "to", GridX: 1, GridY: 3, PixelX: 100, PixelY: 400, Width: 100, Height: 100, CenterX: 150, CenterY: 450},
 	}
 
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // To box (same column)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // From box
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // To box (same column)
 		1, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY
 		allBoxes, "from", "to", "",
@@ -768,9 +775,9 @@ func TestRouteArrow_BoxInPath(t *testing.T) {
 func TestRouteArrow_ThreeSegmentVerticalFirst(t *testing.T) {
 	// Boxes at different columns and rows, close together so V-H-V wins over horizontal
 	// Box1 at grid (3,1), Box2 at grid (2,2) — adjacent columns
-	plan, err := RouteArrow(
-		BoxCoords{X1: 300, Y1: 50, X2: 500, Y2: 150},  // Box 1 (upper)
-		BoxCoords{X1: 150, Y1: 200, X2: 350, Y2: 300}, // Box 2 (lower-left, overlapping X)
+	plan, err := routeArrow(
+		boxCoords{X1: 300, Y1: 50, X2: 500, Y2: 150},  // Box 1 (upper)
+		boxCoords{X1: 150, Y1: 200, X2: 350, Y2: 300}, // Box 2 (lower-left, overlapping X)
 		3, 1, // fromGridX, fromGridY
 		2, 2, // toGridX, toGridY (adjacent column, boxes overlap horizontally)
 		nil, "top", "bottom", "down",
@@ -815,9 +822,9 @@ func TestRouteArrow_ThreeSegmentVerticalFirst(t *testing.T) {
 
 // TestRouteArrow_FlowDown_SameColumn tests that same-column with flow=down still uses straight_vertical
 func TestRouteArrow_FlowDown_SameColumn(t *testing.T) {
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (same column)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 1
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (same column)
 		1, 1, // fromGridX, fromGridY
 		1, 4, // toGridX, toGridY
 		nil, "box1", "box2", "down",
@@ -836,9 +843,9 @@ func TestRouteArrow_FlowDown_SameColumn(t *testing.T) {
 // TestRouteArrow_FlowDown_DiagonalBeatsHorizontal tests that flow=down boosts vertical strategies
 func TestRouteArrow_FlowDown_DiagonalBeatsHorizontal(t *testing.T) {
 	// Boxes at different X and Y, with flow=down
-	plan, err := RouteArrow(
-		BoxCoords{X1: 400, Y1: 100, X2: 600, Y2: 200}, // Box 1 (upper right)
-		BoxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2 (lower left)
+	plan, err := routeArrow(
+		boxCoords{X1: 400, Y1: 100, X2: 600, Y2: 200}, // Box 1 (upper right)
+		boxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2 (lower left)
 		4, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY
 		nil, "upper", "lower", "down",
@@ -863,9 +870,9 @@ func TestRouteArrow_FlowDown_DiagonalBeatsHorizontal(t *testing.T) {
 // TestRouteArrow_NoFlow_DiagonalUsesHorizontal tests that without flow, horizontal strategies still win
 func TestRouteArrow_NoFlow_DiagonalUsesHorizontal(t *testing.T) {
 	// Same boxes as above but without flow
-	plan, err := RouteArrow(
-		BoxCoords{X1: 400, Y1: 100, X2: 600, Y2: 200}, // Box 1 (upper right)
-		BoxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2 (lower left)
+	plan, err := routeArrow(
+		boxCoords{X1: 400, Y1: 100, X2: 600, Y2: 200}, // Box 1 (upper right)
+		boxCoords{X1: 100, Y1: 300, X2: 300, Y2: 400}, // Box 2 (lower left)
 		4, 1, // fromGridX, fromGridY
 		1, 3, // toGridX, toGridY
 		nil, "upper", "lower", "",
@@ -881,19 +888,78 @@ func TestRouteArrow_NoFlow_DiagonalUsesHorizontal(t *testing.T) {
 	}
 }
 
+// TestRouteArrow_FlowHints tests that every flow hint prefers routes along its axis
+func TestRouteArrow_FlowHints(t *testing.T) {
+	vertical := map[string]bool{"straight_vertical": true, "two_segment_vertical_first": true, "three_segment_vertical_first": true}
+	horizontal := map[string]bool{"non_overlapping_horizontal": true, "two_segment_horizontal_first": true, "three_segment_horizontal_first": true}
+	tests := []struct {
+		flow string
+		want map[string]bool
+	}{
+		{FlowDown, vertical},
+		{FlowUp, vertical},
+		{FlowLeft, horizontal},
+		{FlowRight, horizontal},
+		{FlowHorizontal, horizontal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flow, func(t *testing.T) {
+			// Lower left to upper right, far apart in both directions
+			plan, err := routeArrow(
+				boxCoords{X1: 100, Y1: 500, X2: 300, Y2: 600},
+				boxCoords{X1: 700, Y1: 100, X2: 900, Y2: 200},
+				1, 5,
+				7, 1,
+				nil, "lower", "upper", tt.flow,
+			)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tt.want[plan.Strategy] {
+				t.Errorf("Unexpected strategy %s with flow=%s", plan.Strategy, tt.flow)
+			}
+		})
+	}
+}
+
+// TestRouteArrow_HorizontalFlowGeneratesHorizontalFirst tests the L-shaped
+// counterpart of two_segment_vertical_first for horizontal flows
+func TestRouteArrow_HorizontalFlowGeneratesHorizontalFirst(t *testing.T) {
+	box1 := boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}
+	box2 := boxCoords{X1: 500, Y1: 400, X2: 700, Y2: 500}
+	for _, flow := range []string{"", FlowDown, FlowRight} {
+		plan, err := routeArrow(box1, box2, 1, 1, 5, 4, nil, "a", "b", flow)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		found := false
+		for _, c := range plan.AllCandidates {
+			if c.strategy == "two_segment_horizontal_first" {
+				found = true
+				if want := []int{300, 150, 600, 150, 600, 398}; !slices.Equal(c.segments, want) {
+					t.Errorf("Expected segments %v, got %v", want, c.segments)
+				}
+			}
+		}
+		if found != (flow == FlowRight) {
+			t.Errorf("flow=%q: expected horizontal-first L only for horizontal flows, found=%v", flow, found)
+		}
+	}
+}
+
 // ===== Step 1: Unit tests for checkSegmentCollision =====
 
 func TestCheckSegmentCollision(t *testing.T) {
 	// Standard box at (100, 100) with width=100, height=100
-	// With BOX_COLLISION_BUFFER=3: effective bounds [97, 97] to [203, 203]
-	box := BoxData{ID: "box1", PixelX: 100, PixelY: 100, Width: 100, Height: 100}
-	boxes := []BoxData{box}
+	// With boxCollisionBuffer=3: effective bounds [97, 97] to [203, 203]
+	box := placedBox{ID: "box1", PixelX: 100, PixelY: 100, Width: 100, Height: 100}
+	boxes := []placedBox{box}
 
 	tests := []struct {
 		name       string
 		x1, y1     int
 		x2, y2     int
-		boxes      []BoxData
+		boxes      []placedBox
 		excludeIDs map[string]bool
 		want       bool
 	}{
@@ -977,7 +1043,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 	tests := []struct {
 		name         string
 		points       []int
-		boxes        []BoxData
+		boxes        []placedBox
 		fromID, toID string
 		want         bool
 	}{
@@ -987,7 +1053,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 			// Obstacle at (90,190,20,20) → buffer [87,187,113,213]
 			// Seg1 (100,100)→(100,300): xOverlap 100∈[87,113]→true, yOverlap [100,300]∩[187,213]→true
 			points: []int{100, 100, 100, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 90, PixelY: 190, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -998,7 +1064,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 		{
 			name:   "L-path vertical-first, no collision",
 			points: []int{100, 100, 100, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
 			},
@@ -1011,7 +1077,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 			// Obstacle at (190,90,20,20) → buffer [187,87,213,113]
 			// Seg1 (100,100)→(300,100): yOverlap 100∈[87,113]→true, xOverlap [100,300]∩[187,213]→true
 			points: []int{100, 100, 300, 100, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 190, PixelY: 90, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1022,7 +1088,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 		{
 			name:   "L-path horizontal-first, no collision",
 			points: []int{100, 100, 300, 100, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
 			},
@@ -1032,7 +1098,7 @@ func TestCheckPathCollision_TwoSegment(t *testing.T) {
 		{
 			name:   "excluded source/dest boxes not counted as collisions",
 			points: []int{100, 100, 100, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 90, PixelY: 90, Width: 20, Height: 20},
 				{ID: "to", PixelX: 290, PixelY: 290, Width: 20, Height: 20},
 			},
@@ -1055,7 +1121,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 	tests := []struct {
 		name         string
 		points       []int
-		boxes        []BoxData
+		boxes        []placedBox
 		fromID, toID string
 		want         bool
 	}{
@@ -1065,7 +1131,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (90,140,20,20)→buffer [87,137,113,163]
 			// Seg1 (100,100)→(100,200): x=100∈[87,113], y∩[137,163]→true
 			points: []int{100, 100, 100, 200, 300, 200, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 90, PixelY: 140, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1078,7 +1144,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (190,190,20,20)→buffer [187,187,213,213]
 			// Seg2 (100,200)→(300,200): y=200∈[187,213], x∩[187,213]→true
 			points: []int{100, 100, 100, 200, 300, 200, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 190, PixelY: 190, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1091,7 +1157,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (290,240,20,20)→buffer [287,237,313,263]
 			// Seg3 (300,200)→(300,300): x=300∈[287,313], y∩[237,263]→true
 			points: []int{100, 100, 100, 200, 300, 200, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 290, PixelY: 240, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1105,7 +1171,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (140,90,20,20)→buffer [137,87,163,113]
 			// Seg1 (100,100)→(200,100): y=100∈[87,113], x∩[137,163]→true
 			points: []int{100, 100, 200, 100, 200, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 140, PixelY: 90, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1118,7 +1184,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (190,190,20,20)→buffer [187,187,213,213]
 			// Seg2 (200,100)→(200,300): x=200∈[187,213], y∩[187,213]→true
 			points: []int{100, 100, 200, 100, 200, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 190, PixelY: 190, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1131,7 +1197,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 			// Obstacle at (240,290,20,20)→buffer [237,287,263,313]
 			// Seg3 (200,300)→(300,300): y=300∈[287,313], x∩[237,263]→true
 			points: []int{100, 100, 200, 100, 200, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "obstacle", PixelX: 240, PixelY: 290, Width: 20, Height: 20},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
@@ -1142,7 +1208,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 		{
 			name:   "V-H-V no collision",
 			points: []int{100, 100, 100, 200, 300, 200, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
 			},
@@ -1152,7 +1218,7 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 		{
 			name:   "H-V-H no collision",
 			points: []int{100, 100, 200, 100, 200, 300, 300, 300},
-			boxes: []BoxData{
+			boxes: []placedBox{
 				{ID: "from", PixelX: 80, PixelY: 80, Width: 40, Height: 40},
 				{ID: "to", PixelX: 280, PixelY: 280, Width: 40, Height: 40},
 			},
@@ -1176,12 +1242,12 @@ func TestCheckPathCollision_ThreeSegment(t *testing.T) {
 func TestScoreRoute(t *testing.T) {
 	tests := []struct {
 		name      string
-		candidate RouteCandidate
+		candidate routeCandidate
 		want      int
 	}{
 		{
 			name: "straight_vertical, no flow, same width",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "straight_vertical", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 150, endY: 400,
 			},
@@ -1189,7 +1255,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "straight_vertical, narrow-to-wide",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "straight_vertical", boxWidth1: 50, boxWidth2: 200,
 				startX: 100, startY: 200, endX: 100, endY: 400,
 			},
@@ -1197,7 +1263,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "straight_vertical, flow=down",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "straight_vertical", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 150, endY: 400, flow: "down",
 			},
@@ -1205,7 +1271,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "non_overlapping_horizontal",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "non_overlapping_horizontal", boxWidth1: 100, boxWidth2: 100,
 				startX: 200, startY: 150, endX: 400, endY: 150,
 			},
@@ -1213,7 +1279,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "two_segment_vertical_first",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "two_segment_vertical_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 400, endY: 300,
 			},
@@ -1221,7 +1287,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "two_segment_vertical_first, flow=down",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "two_segment_vertical_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 400, endY: 300, flow: "down",
 			},
@@ -1229,7 +1295,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "two_segment_horizontal_first, narrow-to-wide",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "two_segment_horizontal_first", boxWidth1: 50, boxWidth2: 200,
 				startX: 110, startY: 150, endX: 200, endY: 400,
 			},
@@ -1237,7 +1303,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "two_segment_horizontal_first, normal",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "two_segment_horizontal_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 200, startY: 150, endX: 350, endY: 400,
 			},
@@ -1245,7 +1311,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "three_segment_horizontal_first",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "three_segment_horizontal_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 200, startY: 150, endX: 400, endY: 350,
 			},
@@ -1253,7 +1319,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "three_segment_vertical_first",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "three_segment_vertical_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 350, endY: 400,
 			},
@@ -1261,7 +1327,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "three_segment_vertical_first, flow=down",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "three_segment_vertical_first", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 200, endX: 350, endY: 400, flow: "down",
 			},
@@ -1269,7 +1335,7 @@ func TestScoreRoute(t *testing.T) {
 		},
 		{
 			name: "very long distance caps penalty at 50",
-			candidate: RouteCandidate{
+			candidate: routeCandidate{
 				strategy: "straight_vertical", boxWidth1: 100, boxWidth2: 100,
 				startX: 150, startY: 100, endX: 150, endY: 700,
 			},
@@ -1287,13 +1353,13 @@ func TestScoreRoute(t *testing.T) {
 	}
 }
 
-// ===== Step 4: Edge case tests for RouteArrow =====
+// ===== Step 4: Edge case tests for routeArrow =====
 
 func TestRouteArrow_UpwardArrow(t *testing.T) {
 	// toGridY < fromGridY: arrow goes upward
-	plan, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 1 (lower)
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 2 (upper)
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400}, // Box 1 (lower)
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}, // Box 2 (upper)
 		2, 3, // fromGridX, fromGridY
 		2, 1, // toGridX, toGridY (going up)
 		nil, "lower", "upper", "",
@@ -1321,9 +1387,9 @@ func TestRouteArrow_UpwardArrow(t *testing.T) {
 func TestRouteArrow_BackwardDiagonalFlowDown(t *testing.T) {
 	// toGridX < fromGridX, toGridY > fromGridY, flow="down"
 	// Should generate three_segment_vertical_first candidate
-	plan, err := RouteArrow(
-		BoxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 1 (upper-right)
-		BoxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (lower-left)
+	plan, err := routeArrow(
+		boxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200}, // Box 1 (upper-right)
+		boxCoords{X1: 100, Y1: 400, X2: 200, Y2: 500}, // Box 2 (lower-left)
 		4, 1, // fromGridX, fromGridY
 		1, 4, // toGridX, toGridY
 		nil, "upper", "lower", "down",
@@ -1357,9 +1423,9 @@ func TestRouteArrow_BackwardDiagonalFlowDown(t *testing.T) {
 
 func TestRouteArrow_SamePosition(t *testing.T) {
 	// fromGridX == toGridX && fromGridY == toGridY: no strategy matches
-	_, err := RouteArrow(
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
-		BoxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
+	_, err := routeArrow(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
 		2, 2, // fromGridX, fromGridY
 		2, 2, // toGridX, toGridY (same position)
 		nil, "box1", "box2", "",
@@ -1370,7 +1436,7 @@ func TestRouteArrow_SamePosition(t *testing.T) {
 }
 
 func TestLayout_Container(t *testing.T) {
-	// Parse a container spec, run through Layout, verify results
+	// Parse a container spec, run through layout, verify results
 	text := `
 G: 2,2 [
     X: 0,0: Alpha
@@ -1378,13 +1444,13 @@ G: 2,2 [
     X -> Y
 ]
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
-		t.Fatalf("ParseDiagramSpec failed: %v", err)
+		t.Fatalf("parseDiagramSpec failed: %v", err)
 	}
 
-	config := NewDefaultConfig()
-	diagram, boxData := Layout(spec, config, nil, spec.Groups, "")
+	config := newDefaultConfig()
+	diagram, boxData := layout(spec, config, nil, spec.Groups, "")
 
 	// Verify boxes exist and have correct grid positions (scoped IDs: G.X, G.Y)
 	xData, ok := boxData["G.X"]
@@ -1409,13 +1475,1135 @@ G: 2,2 [
 		t.Errorf("Expected G.Y pixel X > G.X pixel X, got Y=%d, X=%d", yData.PixelX, xData.PixelX)
 	}
 
-	// Containers don't create groups — purely organizational
+	// Containers don't create groups, and without a style they are not framed
 	if len(diagram.Groups) != 0 {
 		t.Errorf("Expected 0 groups (containers don't render), got %d", len(diagram.Groups))
 	}
+	if len(diagram.Containers) != 1 || diagram.Containers[0].Framed {
+		t.Errorf("Expected 1 unframed container, got %+v", diagram.Containers)
+	}
 
 	// Verify arrow exists
 	if len(diagram.Arrows) != 1 {
 		t.Fatalf("Expected 1 arrow, got %d", len(diagram.Arrows))
 	}
 }
+
+func TestLayout_ContainerFrameBounds(t *testing.T) {
+	text := `
+G: 2,2: Outer, frame [
+    X: 0,0: Alpha
+    S: 2,0: Inner, frame [
+        Y: 0,0: Beta
+    ]
+]
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+
+	diagram, boxData := layout(spec, newDefaultConfig(), nil, spec.Groups, "")
+	if len(diagram.Containers) != 2 {
+		t.Fatalf("Expected 2 containers, got %d", len(diagram.Containers))
+	}
+	outer, inner := diagram.Containers[0], diagram.Containers[1]
+	if outer.ID != "G" || inner.ID != "G.S" {
+		t.Fatalf("Expected containers in header order G, G.S; got %s, %s", outer.ID, inner.ID)
+	}
+
+	// Inner frame wraps Y with padding and title space
+	y := boxData["G.S.Y"]
+	if inner.X != y.PixelX-containerPadding || inner.Y != y.PixelY-containerPadding-containerTitleHeight {
+		t.Errorf("Inner frame origin (%d,%d) does not wrap box at (%d,%d)", inner.X, inner.Y, y.PixelX, y.PixelY)
+	}
+	if inner.X+inner.Width != y.PixelX+y.Width+containerPadding {
+		t.Errorf("Inner frame right edge %d, expected %d", inner.X+inner.Width, y.PixelX+y.Width+containerPadding)
+	}
+
+	// Outer frame wraps X and the inner frame
+	x := boxData["G.X"]
+	if outer.X != x.PixelX-containerPadding {
+		t.Errorf("Outer frame X %d, expected %d", outer.X, x.PixelX-containerPadding)
+	}
+	if outer.Y != inner.Y-containerPadding-containerTitleHeight {
+		t.Errorf("Outer frame Y %d, expected %d", outer.Y, inner.Y-containerPadding-containerTitleHeight)
+	}
+	if outer.X+outer.Width != inner.X+inner.Width+containerPadding {
+		t.Errorf("Outer frame right edge %d, expected %d", outer.X+outer.Width, inner.X+inner.Width+containerPadding)
+	}
+	if outer.Y+outer.Height != inner.Y+inner.Height+containerPadding {
+		t.Errorf("Outer frame bottom edge %d, expected %d", outer.Y+outer.Height, inner.Y+inner.Height+containerPadding)
+	}
+
+	// Frames are rendered and listed in the debug output with their members
+	svg := diagram.GenerateSVG()
+	if !strings.Contains(svg, ">Outer</text>") || !strings.Contains(svg, ">Inner</text>") {
+		t.Error("Expected both container titles in SVG")
+	}
+	debug := generateDebugOutput(diagram, boxData)
+	if len(debug.Containers) != 2 {
+		t.Fatalf("Expected 2 debug containers, got %d", len(debug.Containers))
+	}
+	if got := strings.Join(debug.Containers[0].BoxIDs, ","); got != "G.X,G.S.Y" {
+		t.Errorf("Expected outer debug members G.X,G.S.Y, got %s", got)
+	}
+}
+
+func TestPlaceArrowLabel_LongestSegment(t *testing.T) {
+	// H-V-H route with a long vertical middle segment
+	points := []int{100, 100, 150, 100, 150, 400, 200, 400}
+	label := placeArrowLabel(points, "plan")
+
+	if label.CenterX != 150 || label.CenterY != 250 {
+		t.Errorf("Expected label centered at (150,250), got (%d,%d)", label.CenterX, label.CenterY)
+	}
+	wantWidth := 4*arrowLabelCharWidth + 2*arrowLabelPadding
+	if label.Width != wantWidth || label.Height != arrowLabelHeight {
+		t.Errorf("Expected label size %dx%d, got %dx%d", wantWidth, arrowLabelHeight, label.Width, label.Height)
+	}
+
+	// A straight route split at its midpoint is treated as one segment
+	straight := placeArrowLabel([]int{100, 100, 300, 100, 300, 100, 500, 100}, "plan")
+	if straight.CenterX != 300 || straight.CenterY != 100 {
+		t.Errorf("Expected straight label centered at (300,100), got (%d,%d)", straight.CenterX, straight.CenterY)
+	}
+}
+
+func TestLayout_FinalLabelsClear(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{
+			// The longest segment of A -> B is crossed by C -> D, routed before it
+			name: "other arrow",
+			spec: "A: 1,1: Alpha\nB: 4,3: Beta\nC: 2,1: Gamma\nD: 2,4: Delta\nC -> D\nA -> B: approves\n",
+		},
+		{
+			// The center of the long vertical segment of A -> B lies between C and D
+			name: "boxes",
+			spec: "A: 1,1: Alpha\nB: 2,4: Beta\nC: 1,2: Gamma\nD: 3,2: Delta\nA -> B: approves\nC -> D\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseDiagramSpec(tt.spec, nil)
+			if err != nil {
+				t.Fatalf("parseDiagramSpec failed: %v", err)
+			}
+			diagram, boxData := layout(spec, newDefaultConfig(), nil, nil, "")
+			var labeled arrow
+			others := newObstacleIndex(slices.Collect(maps.Values(boxData)))
+			for _, arrow := range diagram.Arrows {
+				if arrow.Label != "" {
+					labeled = arrow
+					continue
+				}
+				others.addPath("arrow", arrow.route())
+			}
+			if labeled.Label != "approves" {
+				t.Fatalf("Expected arrow label 'approves', got %q", labeled.Label)
+			}
+			if placeArrowLabel(labeled.Points, labeled.Label) == arrowLabelBox(labeled.LabelX, labeled.LabelY, labeled.Label) {
+				t.Fatal("Expected the label off the center of its longest segment")
+			}
+			if label := arrowLabelBox(labeled.LabelX, labeled.LabelY, labeled.Label); others.boxCollides(label) {
+				t.Errorf("Label at (%d,%d) overlaps a box or another arrow", labeled.LabelX, labeled.LabelY)
+			}
+		})
+	}
+}
+
+func TestLayout_ArrowLabelIsObstacle(t *testing.T) {
+	// C -> D would go straight down through the label of A -> B
+	base := `
+A: 1,2: Alpha
+B: 4,2: Beta
+C: 3,1,1: Gamma
+D: 4,3: Delta
+C -> D
+`
+	routeCD := func(text string) (arrow, *diagram) {
+		t.Helper()
+		spec, err := parseDiagramSpec(text, nil)
+		if err != nil {
+			t.Fatalf("parseDiagramSpec failed: %v", err)
+		}
+		diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "")
+		for _, arrow := range diagram.Arrows {
+			if arrow.FromBoxID == "C" {
+				return arrow, diagram
+			}
+		}
+		t.Fatal("Arrow C -> D not routed")
+		return arrow{}, nil
+	}
+
+	unlabeled, _ := routeCD(base + "A -> B\n")
+	labeled, diagram := routeCD("A -> B: approves\n" + base)
+
+	labelArrow := diagram.Arrows[0]
+	if labelArrow.Label != "approves" {
+		t.Fatalf("Expected first arrow label 'approves', got %q", labelArrow.Label)
+	}
+	label := placeArrowLabel([]int{labelArrow.FromX, labelArrow.FromY, labelArrow.ToX, labelArrow.ToY}, labelArrow.Label)
+
+	var rejected bool
+	for _, c := range labeled.Candidates {
+		if c.strategy == "two_segment_vertical_first" && c.rejected {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("Expected vertical-first route through the label to be rejected")
+	}
+	for _, c := range unlabeled.Candidates {
+		if c.strategy == "two_segment_vertical_first" && c.rejected {
+			t.Error("Vertical-first route should be valid without the label")
+		}
+	}
+
+	points := []int{labeled.FromX, labeled.FromY, (labeled.FromX + labeled.ToX) / 2, labeled.FromY,
+		(labeled.FromX + labeled.ToX) / 2, labeled.ToY, labeled.ToX, labeled.ToY}
+	if checkPathCollision(points, []placedBox{label}, "C", "D") {
+		t.Error("Chosen route for C -> D crosses the label")
+	}
+}
+
+func TestLayout_DefaultArrowhead(t *testing.T) {
+	spec, err := parseDiagramSpec("a: 1,1: A\nb: 4,1: B\nc: 4,3: C\na -> b\nb -> c | circle", nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	config := newDefaultConfig()
+	config.ArrowHead = HeadTriangle
+
+	diagram, _ := layout(spec, config, nil, nil, "")
+	if len(diagram.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(diagram.Arrows))
+	}
+	if diagram.Arrows[0].Head != HeadTriangle {
+		t.Errorf("Expected frontmatter default triangle, got %q", diagram.Arrows[0].Head)
+	}
+	if diagram.Arrows[1].Head != HeadCircle {
+		t.Errorf("Expected per-arrow circle to override default, got %q", diagram.Arrows[1].Head)
+	}
+}
+
+func TestLayout_DefaultArrowShape(t *testing.T) {
+	spec, err := parseDiagramSpec("a: 1,1: A\nb: 4,1: B\nc: 4,3: C\na -> c\nb -> c | sharp", nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	config := newDefaultConfig()
+	config.ArrowShape = ArrowRounded
+
+	diagram, _ := layout(spec, config, nil, nil, "")
+	if len(diagram.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(diagram.Arrows))
+	}
+	if diagram.Arrows[0].Shape != ArrowRounded {
+		t.Errorf("Expected frontmatter default rounded, got %q", diagram.Arrows[0].Shape)
+	}
+	if diagram.Arrows[1].Shape != ArrowSharp {
+		t.Errorf("Expected per-arrow sharp to override default, got %q", diagram.Arrows[1].Shape)
+	}
+	if svg := diagram.GenerateSVG(); strings.Count(svg, " A10,10 ") < 1 {
+		t.Errorf("Expected a rounded bend on the bent arrow, got %s", svg)
+	}
+}
+
+func TestRouteArrowWithPorts_FiltersStrategies(t *testing.T) {
+	// Same column: without ports the straight vertical route wins
+	plan, err := routeArrowWithPorts(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
+		boxCoords{X1: 100, Y1: 300, X2: 200, Y2: 400},
+		1, 1,
+		1, 3,
+		nil, "from", "to", "",
+		arrowPorts{From: SideRight, To: SideTop},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitSide(plan.Points) != SideRight || entrySide(plan.Points) != SideTop {
+		t.Errorf("Expected route right -> top, got %s -> %s (%v)", exitSide(plan.Points), entrySide(plan.Points), plan.Points)
+	}
+	for _, c := range plan.AllCandidates {
+		if c.strategy == "straight_vertical" && c.rejectReason != "port_mismatch" {
+			t.Errorf("Expected straight_vertical to be rejected as port_mismatch, got %q", c.rejectReason)
+		}
+	}
+}
+
+func TestRouteArrowWithPorts_PortRoute(t *testing.T) {
+	// Top to top: no fixed strategy enters a lower box from above while leaving upwards
+	plan, err := routeArrowWithPorts(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
+		boxCoords{X1: 400, Y1: 300, X2: 500, Y2: 400},
+		1, 1,
+		3, 3,
+		nil, "from", "to", "",
+		arrowPorts{From: SideTop, To: SideTop},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plan.Strategy != "port_route" {
+		t.Errorf("Expected port_route, got %s", plan.Strategy)
+	}
+	want := []int{150, 100, 150, 80, 450, 80, 450, 298}
+	if len(plan.Points) != len(want) {
+		t.Fatalf("Expected points %v, got %v", want, plan.Points)
+	}
+	for i := range want {
+		if plan.Points[i] != want[i] {
+			t.Fatalf("Expected points %v, got %v", want, plan.Points)
+		}
+	}
+}
+
+func TestRouteArrowWithPorts_Error(t *testing.T) {
+	// A wall right of the source blocks every route leaving on the right
+	allBoxes := []placedBox{
+		{ID: "from", PixelX: 100, PixelY: 100, Width: 100, Height: 100},
+		{ID: "to", PixelX: 400, PixelY: 100, Width: 100, Height: 100},
+		{ID: "wall", PixelX: 210, PixelY: 0, Width: 20, Height: 400},
+	}
+	_, err := routeArrowWithPorts(
+		boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200},
+		boxCoords{X1: 400, Y1: 100, X2: 500, Y2: 200},
+		1, 1,
+		3, 1,
+		allBoxes, "from", "to", "",
+		arrowPorts{From: SideRight},
+	)
+	if err == nil {
+		t.Fatal("Expected an error when no route uses the requested port")
+	}
+	if !strings.Contains(err.Error(), "from.right") || !strings.Contains(err.Error(), "requested ports") {
+		t.Errorf("Expected error to name the port, got: %v", err)
+	}
+}
+
+func TestRouteArrow_GridRouteFallback(t *testing.T) {
+	// A tall wall between two boxes on the same row blocks every fixed strategy
+	allBoxes := []placedBox{
+		{ID: "from", PixelX: 100, PixelY: 200, Width: 100, Height: 100},
+		{ID: "wall", PixelX: 300, PixelY: 50, Width: 50, Height: 350},
+		{ID: "to", PixelX: 450, PixelY: 200, Width: 100, Height: 100},
+	}
+	plan, err := routeArrow(
+		boxCoords{X1: 100, Y1: 200, X2: 200, Y2: 300},
+		boxCoords{X1: 450, Y1: 200, X2: 550, Y2: 300},
+		1, 2,
+		5, 2,
+		allBoxes, "from", "to", "",
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plan.Strategy != "grid_route" {
+		t.Fatalf("Expected grid_route fallback, got %s", plan.Strategy)
+	}
+	if checkPathCollision(plan.Points, allBoxes, "from", "to") {
+		t.Errorf("Grid route collides with a box: %v", plan.Points)
+	}
+	// Under the wall, leaving and entering at the bottom: fewest bends
+	want := []int{150, 300, 150, 415, 500, 415, 500, 302}
+	if len(plan.Points) != len(want) {
+		t.Fatalf("Expected points %v, got %v", want, plan.Points)
+	}
+	for i := range want {
+		if plan.Points[i] != want[i] {
+			t.Fatalf("Expected points %v, got %v", want, plan.Points)
+		}
+	}
+
+	var fixed, grid int
+	for _, c := range plan.AllCandidates {
+		if c.strategy == "grid_route" {
+			grid++
+		} else if c.rejected {
+			fixed++
+		}
+	}
+	if grid != 1 || fixed == 0 {
+		t.Errorf("Expected rejected fixed candidates plus one grid_route candidate, got %+v", plan.AllCandidates)
+	}
+}
+
+func TestGridRoute_Enclosed(t *testing.T) {
+	// The target sits inside a ring of boxes: no route exists
+	allBoxes := []placedBox{
+		{ID: "top", PixelX: 300, PixelY: 100, Width: 300, Height: 50},
+		{ID: "bottom", PixelX: 300, PixelY: 350, Width: 300, Height: 50},
+		{ID: "left", PixelX: 300, PixelY: 100, Width: 50, Height: 300},
+		{ID: "right", PixelX: 550, PixelY: 100, Width: 50, Height: 300},
+	}
+	points := gridRoute(
+		boxCoords{X1: 0, Y1: 200, X2: 100, Y2: 300},
+		boxCoords{X1: 420, Y1: 220, X2: 480, Y2: 280},
+		newObstacleIndex(allBoxes), "from", "to", arrowPorts{},
+	)
+	if points != nil {
+		t.Errorf("Expected no route into an enclosed box, got %v", points)
+	}
+}
+
+func TestGridRoute_WidensWindow(t *testing.T) {
+	// A wall far longer than the initial window: the route runs around its end
+	wall := placedBox{ID: "wall", PixelX: 200, PixelY: -1000, Width: 50, Height: 2500}
+	far := placedBox{ID: "far", PixelX: 5000, PixelY: 5000, Width: 100, Height: 100}
+	obstacles := newObstacleIndex([]placedBox{wall, far})
+	box1 := boxCoords{X1: 0, Y1: 200, X2: 100, Y2: 300}
+	box2 := boxCoords{X1: 400, Y1: 200, X2: 500, Y2: 300}
+
+	points := gridRoute(box1, box2, obstacles, "from", "to", arrowPorts{})
+	if points == nil {
+		t.Fatal("Expected a route around the wall")
+	}
+	if obstacles.pathCollides(points, "from", "to") {
+		t.Errorf("Route %v crosses the wall", points)
+	}
+	for i := 0; i+1 < len(points); i += 2 {
+		if points[i] > 1000 || points[i+1] > 3000 {
+			t.Errorf("Route %v strays towards the distant box", points)
+			break
+		}
+	}
+}
+
+func TestObstacleIndex_Within(t *testing.T) {
+	boxes := newObstacleIndex([]placedBox{
+		{ID: "A", PixelX: 0, PixelY: 0, Width: 600, Height: 50}, // Spans several cells
+		{ID: "B", PixelX: 300, PixelY: 300, Width: 100, Height: 50},
+		{ID: "C", PixelX: 2000, PixelY: 2000, Width: 100, Height: 50},
+	})
+	var ids []string
+	for _, box := range boxes.within(boxCoords{X1: 0, Y1: 0, X2: 500, Y2: 500}) {
+		ids = append(ids, box.ID)
+	}
+	if got := strings.Join(ids, ","); got != "A,B" {
+		t.Errorf("Expected boxes A,B within the window, got %s", got)
+	}
+	if bounds, ok := boxes.bounds(); !ok || bounds != (boxCoords{X1: 0, Y1: 0, X2: 2100, Y2: 2050}) {
+		t.Errorf("Expected bounds 0,0-2100,2050, got %+v", bounds)
+	}
+}
+
+func TestLayout_BlockedArrowIsNotDropped(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 1,2: Alpha
+W: 3,1,1,3: Wall
+B: 5,2: Beta
+A -> B
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "")
+	if len(diagram.Arrows) != 1 {
+		t.Fatalf("Expected 1 arrow, got %d", len(diagram.Arrows))
+	}
+	if diagram.Arrows[0].RoutingStrategy != "grid_route" {
+		t.Errorf("Expected grid_route, got %s", diagram.Arrows[0].RoutingStrategy)
+	}
+	if len(diagram.Diagnostics) != 0 {
+		t.Errorf("Expected no routing warnings, got %v", diagram.Diagnostics)
+	}
+	if svg := diagram.GenerateSVG(); !strings.Contains(svg, `<path d="M340,250 L375,250`) {
+		t.Error("Expected the grid route to be drawn along its points")
+	}
+}
+
+func TestShiftArrowEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  []int
+		atStart bool
+		offset  int
+		want    []int
+	}{
+		{"L-shape start along bottom side", []int{100, 200, 100, 300, 400, 300}, true, 20, []int{120, 200, 120, 300, 400, 300}},
+		{"L-shape end along left side", []int{100, 200, 100, 300, 400, 300}, false, -10, []int{100, 200, 100, 290, 400, 290}},
+		{"straight segment gets a jog", []int{100, 200, 100, 400}, true, 20, []int{120, 200, 120, 300, 100, 300, 100, 400}},
+		{"zero offset", []int{100, 200, 300, 200}, false, 0, []int{100, 200, 300, 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shiftArrowEnd(tt.points, tt.atStart, tt.offset)
+			if len(got) != len(tt.want) {
+				t.Fatalf("shiftArrowEnd() = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("shiftArrowEnd() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeparateChannels_OverlappingSegments(t *testing.T) {
+	// Two Z-shaped arrows whose middle segments share the line y=250
+	arrows := []arrow{
+		{FromBoxID: "a", ToBoxID: "c", Points: []int{100, 200, 100, 250, 400, 250, 400, 300}},
+		{FromBoxID: "b", ToBoxID: "d", Points: []int{200, 200, 200, 250, 500, 250, 500, 300}},
+	}
+	separateChannels(arrows, nil)
+
+	y0, y1 := arrows[0].Points[3], arrows[1].Points[3]
+	if y1-y0 != channelSpacing {
+		t.Errorf("Expected middle segments %d apart, got y=%d and y=%d", channelSpacing, y0, y1)
+	}
+	for _, arrow := range arrows {
+		if arrow.Points[3] != arrow.Points[5] || !isOrthogonal(arrow.Points) {
+			t.Errorf("Expected an orthogonal route after the move, got %v", arrow.Points)
+		}
+	}
+}
+
+func TestLayout_FanOutEndsAreSpread(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 4,1: CEO
+B: 2,3: Sales
+C: 6,3: Eng
+A -> B, C
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	// Without merging: flow down trees would otherwise share a trunk
+	config := newDefaultConfig()
+	config.Merge = MergeNone
+	diagram, _ := layout(spec, config, nil, nil, "down")
+	if len(diagram.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(diagram.Arrows))
+	}
+	ab, ac := diagram.Arrows[0], diagram.Arrows[1]
+	if ab.FromX == ac.FromX && ab.FromY == ac.FromY {
+		t.Fatalf("Expected arrows to leave A at different points, both start at %d,%d", ab.FromX, ab.FromY)
+	}
+	if ab.FromX >= ac.FromX {
+		t.Errorf("Expected the arrow to the left box to leave further left: %d vs %d", ab.FromX, ac.FromX)
+	}
+}
+
+func TestLayout_FanOutMergesIntoBus(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 4,1: CEO
+B: 2,3: Sales
+C: 4,3: Ops
+D: 6,3: Eng
+A -> B, C, D
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "down")
+	if len(diagram.Arrows) != 3 {
+		t.Fatalf("Expected 3 arrows, got %d", len(diagram.Arrows))
+	}
+	first := diagram.Arrows[0]
+	for i, arrow := range diagram.Arrows {
+		if !arrow.Merged {
+			t.Errorf("Arrow %d: expected it to be merged", i)
+		}
+		if arrow.FromX != first.FromX || arrow.FromY != first.FromY {
+			t.Errorf("Arrow %d: expected the shared start %d,%d, got %d,%d", i, first.FromX, first.FromY, arrow.FromX, arrow.FromY)
+		}
+		if !isOrthogonal(arrow.Points) {
+			t.Errorf("Arrow %d: expected an orthogonal route, got %v", i, arrow.Points)
+		}
+	}
+	// The trunk splits left, right and straight on: one junction
+	if len(diagram.Junctions) != 1 {
+		t.Fatalf("Expected 1 junction, got %d: %v", len(diagram.Junctions), diagram.Junctions)
+	}
+	if diagram.Junctions[0].X != first.FromX {
+		t.Errorf("Expected the junction below the trunk at x=%d, got %d", first.FromX, diagram.Junctions[0].X)
+	}
+}
+
+func TestLayout_DifferentlyStyledArrowsDoNotMerge(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 4,1: CEO
+B: 2,3: Sales
+C: 4,3: Ops
+D: 6,3: Eng
+A -> B | dashed-red
+A -> C, D
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "down")
+	if len(diagram.Arrows) != 3 {
+		t.Fatalf("Expected 3 arrows, got %d", len(diagram.Arrows))
+	}
+	if diagram.Arrows[0].Merged {
+		t.Errorf("Expected the red dashed arrow to keep its own route, got %v", diagram.Arrows[0].Points)
+	}
+	for _, arrow := range diagram.Arrows[1:] {
+		if !arrow.Merged {
+			t.Errorf("Expected the plain arrows to share a trunk, got %v", arrow.Points)
+		}
+	}
+	for _, junction := range diagram.Junctions {
+		if junction.Color != "" {
+			t.Errorf("Expected only the plain bus to have a junction, got one colored %q", junction.Color)
+		}
+	}
+}
+
+func TestLayout_MergeModes(t *testing.T) {
+	fanOut := `
+A: 4,1: CEO
+B: 2,3: Sales
+C: 6,3: Eng
+A -> B, C
+`
+	fanIn := `
+A: 1,1: Web
+B: 1,5: Mobile
+C: 5,3: API
+A, B -> C
+`
+	tests := []struct {
+		name   string
+		text   string
+		merge  string
+		flow   string
+		merged bool
+	}{
+		{"flow down merges by default", fanOut, "", "down", true},
+		{"none keeps routes apart", fanOut, MergeNone, "down", false},
+		{"other flows do not merge by default", fanIn, "", "right", false},
+		{"bus merges fan-ins", fanIn, MergeBus, "right", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseDiagramSpec(tt.text, nil)
+			if err != nil {
+				t.Fatalf("parseDiagramSpec failed: %v", err)
+			}
+			config := newDefaultConfig()
+			config.Merge = tt.merge
+			diagram, _ := layout(spec, config, nil, nil, tt.flow)
+			for i, arrow := range diagram.Arrows {
+				if arrow.Merged != tt.merged {
+					t.Errorf("Arrow %d: expected Merged=%v (strategy %s, points %v)", i, tt.merged, arrow.RoutingStrategy, arrow.Points)
+				}
+			}
+			if tt.merged {
+				a, b := diagram.Arrows[0], diagram.Arrows[1]
+				if a.FromBoxID == b.FromBoxID && (a.FromX != b.FromX || a.FromY != b.FromY) {
+					t.Errorf("Expected merged fan-out arrows to share their start")
+				}
+				if a.ToBoxID == b.ToBoxID && (a.ToX != b.ToX || a.ToY != b.ToY) {
+					t.Errorf("Expected merged fan-in arrows to share their end")
+				}
+			}
+		})
+	}
+}
+
+func TestIsBackEdge(t *testing.T) {
+	center := boxCoords{X1: 400, Y1: 400, X2: 600, Y2: 500}
+	above := boxCoords{X1: 400, Y1: 100, X2: 600, Y2: 200}
+	aboveLeft := boxCoords{X1: 100, Y1: 100, X2: 300, Y2: 200}
+	left := boxCoords{X1: 100, Y1: 400, X2: 300, Y2: 500}
+	tests := []struct {
+		name string
+		to   boxCoords
+		flow string
+		want bool
+	}{
+		{"up against flow down", above, FlowDown, true},
+		{"up with flow up", above, FlowUp, false},
+		{"left against flow right", left, FlowRight, true},
+		{"left with flow left", left, FlowLeft, false},
+		{"up and left against the diagonal", aboveLeft, "", true},
+		{"up only along the diagonal", above, "", false},
+		{"no direction for horizontal", left, FlowHorizontal, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBackEdge(center, tt.to, tt.flow); got != tt.want {
+				t.Errorf("isBackEdge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayout_BackEdgeUsesOuterLane(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 1,1: Input
+B: 4,1: Process
+C: 7,1: Output
+D: 4,3: Review
+A -> B
+B -> C
+C -> D
+D -> A
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	config := newDefaultConfig()
+	dims := calculateDimensions(7, 3, config)
+	diagram, boxData := layout(spec, config, nil, nil, "")
+
+	loop := diagram.Arrows[3]
+	if loop.RoutingStrategy != "loop_lane" {
+		t.Fatalf("Expected D -> A to take an outer lane, got %s", loop.RoutingStrategy)
+	}
+	d := boxData["D"]
+	lane := loop.Points[3]
+	if lane <= d.PixelY+d.Height {
+		t.Errorf("Expected the lane below the lowest box (y > %d), got y=%d", d.PixelY+d.Height, lane)
+	}
+	if !isOrthogonal(loop.Points) || len(loop.Points) != 8 {
+		t.Errorf("Expected a U-shaped route, got %v", loop.Points)
+	}
+	if diagram.Height < lane+dims.BottomMargin {
+		t.Errorf("Expected the canvas to extend below the lane at %d, height %d", lane, diagram.Height)
+	}
+	for i, arrow := range diagram.Arrows[:3] {
+		if arrow.RoutingStrategy == "loop_lane" {
+			t.Errorf("Arrow %d runs with the flow, expected a regular route", i)
+		}
+	}
+}
+
+func TestLayout_LoopLanes(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 1,1: A
+B: 4,1: B
+C: 7,1: C
+A -> B
+B -> C
+C -> A | loop
+C -> B | loop
+C -> B | left
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, FlowRight)
+	if len(diagram.Diagnostics) != 0 {
+		t.Errorf("Unexpected diagnostics: %v", diagram.Diagnostics)
+	}
+	ca, cb := diagram.Arrows[2], diagram.Arrows[3]
+	if ca.RoutingStrategy != "loop_lane" || cb.RoutingStrategy != "loop_lane" {
+		t.Fatalf("Expected both loops in outer lanes, got %s and %s", ca.RoutingStrategy, cb.RoutingStrategy)
+	}
+	// The shorter loop takes the inner lane below; the longer one the lane
+	// above, which is shorter than the second lane below
+	if cb.Points[3] <= cb.Points[1] {
+		t.Errorf("Expected C -> B below the boxes, got %v", cb.Points)
+	}
+	if ca.Points[3] >= ca.Points[1] || ca.Points[3] < 0 {
+		t.Errorf("Expected C -> A above the boxes, on the canvas, got %v", ca.Points)
+	}
+	// A flow hint of its own opts out of the lanes
+	if diagram.Arrows[4].RoutingStrategy == "loop_lane" {
+		t.Errorf("Expected C -> B | left between the boxes")
+	}
+}
+
+func TestLayout_LoopLaneFlowDown(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 2,1: Plan
+B: 2,3: Do
+C: 2,5: Check
+A -> B
+B -> C
+C -> A
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, boxData := layout(spec, newDefaultConfig(), nil, nil, FlowDown)
+	loop := diagram.Arrows[2]
+	if loop.RoutingStrategy != "loop_lane" {
+		t.Fatalf("Expected C -> A to take an outer lane, got %s", loop.RoutingStrategy)
+	}
+	a := boxData["A"]
+	if x := loop.Points[2]; x > a.PixelX && x < a.PixelX+a.Width {
+		t.Errorf("Expected the lane beside the boxes, got x=%d", x)
+	}
+}
+
+func TestLayout_LoopLaneClearOfYAxis(t *testing.T) {
+	spec, err := parseDiagramSpec(`
+A: 1,1: Plan
+B: 1,3: Do
+B.left -> A.left | loop
+`, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, FlowDown)
+	if diagram.Arrows[0].RoutingStrategy != "loop_lane" {
+		t.Fatalf("Expected a left lane without the y-axis, got %s", diagram.Arrows[0].RoutingStrategy)
+	}
+
+	config := newDefaultConfig()
+	config.YAxis = true
+	diagram, _ = layout(spec, config, nil, nil, FlowDown)
+	if diagram.Arrows[0].RoutingStrategy == "loop_lane" {
+		t.Errorf("Expected no left lane between the y-axis and the boxes, got %v", diagram.Arrows[0].Points)
+	}
+	if len(diagram.Diagnostics) != 1 || !strings.Contains(diagram.Diagnostics[0].Message, "lane") {
+		t.Errorf("Expected a warning about the missing lane, got %v", diagram.Diagnostics)
+	}
+}
+
+func TestMarkCrossings(t *testing.T) {
+	arrows := []arrow{
+		{Points: []int{0, 100, 300, 100}},                          // horizontal
+		{Points: []int{100, 0, 100, 200}},                          // crosses the first one
+		{Points: []int{200, 0, 200, 100}},                          // ends on the first one: touching only
+		{Points: []int{50, 50, 250, 50, 250, 150}},                 // crosses arrows 1, 2 and 0
+		{FromX: 0, FromY: 150, ToX: 300, ToY: 150, NumSegments: 1}, // no Points: fixed shape, touches arrow 3
+	}
+	total := markCrossings(arrows)
+
+	wantCounts := []int{0, 1, 0, 3, 1}
+	for i, want := range wantCounts {
+		if got := len(arrows[i].Crossings) / 2; got != want {
+			t.Errorf("Arrow %d: expected %d crossings, got %d (%v)", i, want, got, arrows[i].Crossings)
+		}
+	}
+	if total != 5 {
+		t.Errorf("Expected 5 crossings in total, got %d", total)
+	}
+	if arrows[1].Crossings[0] != 100 || arrows[1].Crossings[1] != 100 {
+		t.Errorf("Expected crossing at 100,100, got %v", arrows[1].Crossings)
+	}
+}
+
+func TestLayout_HopCrossings(t *testing.T) {
+	text := `
+A: 1,2: Alpha
+B: 5,2: Beta
+C: 3,1,1: Top
+D: 3,3,1: Bottom
+A -> B
+C -> D
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+
+	config := newDefaultConfig()
+	diagram, _ := layout(spec, config, nil, nil, "")
+	if diagram.Crossings != 1 {
+		t.Fatalf("Expected 1 crossing, got %d", diagram.Crossings)
+	}
+	if strings.Contains(diagram.GenerateSVG(), " A6,6 ") {
+		t.Error("Expected no bridges without crossings: hop")
+	}
+
+	config.Crossings = CrossingsHop
+	diagram, _ = layout(spec, config, nil, nil, "")
+	svg := diagram.GenerateSVG()
+	if strings.Count(svg, " A6,6 ") != 1 {
+		t.Errorf("Expected one bridge on the later arrow, got: %s", svg)
+	}
+	if output := generateDebugOutput(diagram, nil); output.Diagram.Crossings != 1 || output.Arrows[1].Crossings != 1 {
+		t.Errorf("Expected crossing count in debug output, got %+v", output.Diagram)
+	}
+}
+
+func TestRouteArrow_SelfLoop(t *testing.T) {
+	box := boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}
+	allBoxes := []placedBox{{ID: "a", PixelX: 100, PixelY: 100, Width: 100, Height: 100}}
+
+	plan, err := routeArrow(box, box, 1, 1, 1, 1, allBoxes, "a", "a", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plan.Strategy != "self_loop" {
+		t.Fatalf("Expected self_loop, got %s", plan.Strategy)
+	}
+	if side := exitSide(plan.Points); side != SideRight || entrySide(plan.Points) != SideRight {
+		t.Errorf("Expected loop on the right side by default, got %v", plan.Points)
+	}
+
+	plan, err = routeArrowWithPorts(box, box, 1, 1, 1, 1, allBoxes, "a", "a", "", arrowPorts{From: SideBottom, To: SideBottom})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitSide(plan.Points) != SideBottom || entrySide(plan.Points) != SideBottom {
+		t.Errorf("Expected loop on the bottom port, got %v", plan.Points)
+	}
+
+	// A box right next to the right side pushes the loop elsewhere
+	blocked := append(allBoxes, placedBox{ID: "b", PixelX: 220, PixelY: 100, Width: 100, Height: 100})
+	plan, err = routeArrow(box, box, 1, 1, 1, 1, blocked, "a", "a", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitSide(plan.Points) == SideRight {
+		t.Errorf("Expected loop to avoid the blocked right side, got %v", plan.Points)
+	}
+	if checkPathCollision(plan.Points, blocked, "a", "a") {
+		t.Errorf("Self-loop collides with a box: %v", plan.Points)
+	}
+}
+
+func TestLayout_SelfLoopAvoidsArrows(t *testing.T) {
+	text := `
+A: 1,1: Alpha
+B: 4,1: Beta
+A -> A: review
+A -> B
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "")
+	if len(diagram.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(diagram.Arrows))
+	}
+
+	var loop, straight *arrow
+	for i := range diagram.Arrows {
+		if diagram.Arrows[i].FromBoxID == diagram.Arrows[i].ToBoxID {
+			loop = &diagram.Arrows[i]
+		} else {
+			straight = &diagram.Arrows[i]
+		}
+	}
+	if loop == nil || straight == nil {
+		t.Fatalf("Expected a self-loop and a straight arrow, got %+v", diagram.Arrows)
+	}
+	if len(straight.Points) != 4 {
+		t.Errorf("Expected A -> B to stay straight, got %v", straight.Points)
+	}
+	if exitSide(loop.Points) == SideRight {
+		t.Errorf("Expected the loop to leave the side used by A -> B, got %v", loop.Points)
+	}
+	if loop.Label != "review" || (loop.LabelX == 0 && loop.LabelY == 0) {
+		t.Errorf("Expected a placed label on the self-loop, got %q", loop.Label)
+	}
+	if diagram.Crossings != 0 {
+		t.Errorf("Expected no crossings, got %d", diagram.Crossings)
+	}
+}
+
+func TestLayout_SelfLoopExtendsCanvas(t *testing.T) {
+	spec, err := parseDiagramSpec("A: 1,1: Alpha\nA -> A: rework\n", nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "")
+	loop := diagram.Arrows[0]
+	for i := 0; i+1 < len(loop.Points); i += 2 {
+		if loop.Points[i] >= diagram.Width || loop.Points[i+1] >= diagram.Height {
+			t.Errorf("Self-loop point %d,%d lies outside the %dx%d canvas", loop.Points[i], loop.Points[i+1], diagram.Width, diagram.Height)
+		}
+	}
+	label := arrowLabelBox(loop.LabelX, loop.LabelY, loop.Label)
+	if right := label.PixelX + label.Width; right > diagram.Width {
+		t.Errorf("Self-loop label ends at x=%d, past the canvas width %d", right, diagram.Width)
+	}
+}
+
+func TestLayout_SelfLoopConflictingPorts(t *testing.T) {
+	spec, err := parseDiagramSpec("A: 1,1: Alpha\nA.top -> A.bottom\n", nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), nil, nil, "")
+	if len(diagram.Diagnostics) != 1 || !strings.Contains(diagram.Diagnostics[0].Message, "cannot leave the top and enter the bottom side") {
+		t.Fatalf("Expected a warning about the conflicting ports, got %v", diagram.Diagnostics)
+	}
+	loop := diagram.Arrows[0].Points
+	if exitSide(loop) != SideTop || entrySide(loop) != SideTop {
+		t.Errorf("Expected the loop on the from port, got %v", loop)
+	}
+}
+
+func TestRouteArrowVia(t *testing.T) {
+	box1 := boxCoords{X1: 100, Y1: 100, X2: 200, Y2: 200}
+	box2 := boxCoords{X1: 500, Y1: 100, X2: 600, Y2: 200}
+	allBoxes := []placedBox{
+		{ID: "from", PixelX: 100, PixelY: 100, Width: 100, Height: 100},
+		{ID: "to", PixelX: 500, PixelY: 100, Width: 100, Height: 100},
+	}
+
+	// Down from the source, along y=300 and up into the target
+	plan := routeArrowVia(box1, box2, []int{150, 300, 550, 300}, allBoxes, "from", "to", arrowPorts{})
+	want := []int{150, 200, 150, 300, 550, 300, 550, 202}
+	if !slices.Equal(plan.Points, want) {
+		t.Errorf("Expected points %v, got %v", want, plan.Points)
+	}
+	if plan.Strategy != "waypoints" || plan.AllCandidates[0].rejected {
+		t.Errorf("Expected a collision-free waypoints route, got %+v", plan)
+	}
+
+	// A waypoint off to the side: the route bends there on its way to the target
+	plan = routeArrowVia(box1, box2, []int{350, 50}, allBoxes, "from", "to", arrowPorts{})
+	want = []int{150, 100, 150, 50, 550, 50, 550, 98}
+	if !slices.Equal(plan.Points, want) {
+		t.Errorf("Expected points %v, got %v", want, plan.Points)
+	}
+
+	// A box in the way is reported, but the route still runs through the waypoint
+	blocked := append(allBoxes, placedBox{ID: "wall", PixelX: 300, PixelY: 250, Width: 100, Height: 100})
+	plan = routeArrowVia(box1, box2, []int{150, 300, 550, 300}, blocked, "from", "to", arrowPorts{})
+	if !plan.AllCandidates[0].rejected {
+		t.Error("Expected the blocked route to be marked as colliding")
+	}
+	if !slices.Equal(plan.Points, []int{150, 200, 150, 300, 550, 300, 550, 202}) {
+		t.Errorf("Expected the route through the waypoints, got %v", plan.Points)
+	}
+}
+
+func TestLayout_ArrowWaypoints(t *testing.T) {
+	text := `
+A: 1,1: Alpha
+B: 5,1: Beta
+W: 3,3,1: Wall
+A -> B via 2,2 via 6,2
+A -> B via 2,3 via 5,3
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	config := newDefaultConfig()
+	diagram, _ := layout(spec, config, nil, nil, "")
+	if len(diagram.Arrows) != 2 {
+		t.Fatalf("Expected both arrows to be drawn, got %d", len(diagram.Arrows))
+	}
+
+	dims := calculateDimensions(5, 3, config)
+	for i, arrow := range diagram.Arrows {
+		if arrow.RoutingStrategy != "waypoints" {
+			t.Errorf("Arrow %d: expected waypoints strategy, got %s", i, arrow.RoutingStrategy)
+		}
+		// Every waypoint lies on the route
+		via := waypointPixels(spec.Arrows[i].Via, dims, config)
+		for j := 0; j+1 < len(via); j += 2 {
+			if !onPolyline(arrow.Points, via[j], via[j+1]) {
+				t.Errorf("Arrow %d: waypoint (%d,%d) not on route %v", i, via[j], via[j+1], arrow.Points)
+			}
+		}
+	}
+
+	// Only the second arrow runs through the wall
+	if len(diagram.Diagnostics) != 1 || diagram.Diagnostics[0].Line != 6 || diagram.Diagnostics[0].Code != CodeRouting {
+		t.Errorf("Expected one routing warning for line 6, got %v", diagram.Diagnostics)
+	}
+}
+
+// onPolyline reports whether point (x, y) lies on an orthogonal polyline
+func onPolyline(points []int, x, y int) bool {
+	for i := 0; i+3 < len(points); i += 2 {
+		x1, y1, x2, y2 := points[i], points[i+1], points[i+2], points[i+3]
+		if min(x1, x2) <= x && x <= max(x1, x2) && min(y1, y2) <= y && y <= max(y1, y2) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSimplifyPolyline(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []int
+		want   []int
+	}{
+		{"simple", []int{0, 0, 100, 0, 100, 50}, []int{0, 0, 100, 0, 100, 50}},
+		{"repeated point", []int{0, 0, 100, 0, 100, 0, 100, 50}, []int{0, 0, 100, 0, 100, 50}},
+		{"collinear", []int{0, 0, 50, 0, 100, 0, 100, 50}, []int{0, 0, 100, 0, 100, 50}},
+		{"collinear at the end", []int{0, 0, 0, 50, 100, 50, 150, 50}, []int{0, 0, 0, 50, 150, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := simplifyPolyline(tt.points); !slices.Equal(got, tt.want) {
+				t.Errorf("simplifyPolyline(%v) = %v, want %v", tt.points, got, tt.want)
+			}
+		})
+	}
+	if allocs := testing.AllocsPerRun(10, func() { simplifyPolyline(tests[0].points) }); allocs != 0 {
+		t.Errorf("Expected a simple polyline to be returned without copying, got %v allocations", allocs)
+	}
+}
+
+func TestObstacleIndex_PathCollidesAllocations(t *testing.T) {
+	boxes := newObstacleIndex([]placedBox{
+		{ID: "A", PixelX: 0, PixelY: 0, Width: 100, Height: 50},
+		{ID: "B", PixelX: 300, PixelY: 0, Width: 100, Height: 50},
+		{ID: "C", PixelX: 150, PixelY: 100, Width: 100, Height: 50},
+	})
+	path := []int{100, 25, 200, 25, 200, 200, 350, 200, 350, 50}
+	if !boxes.pathCollides(path, "A", "B") {
+		t.Fatal("Expected the path through C to collide")
+	}
+	if allocs := testing.AllocsPerRun(10, func() { boxes.pathCollides(path, "A", "B") }); allocs != 0 {
+		t.Errorf("Expected collision checks without allocations, got %v", allocs)
+	}
+}
+
+// syntheticSpec builds a spec of n boxes on a square grid, each one linked to
+// its right and lower neighbour, every fifth link labeled. The first box of
+// each fifth row links back up to the row above, and every 50th box has a
+// self-loop.
+func syntheticSpec(b *testing.B, n int) *DiagramSpec {
+	cols := int(math.Sqrt(float64(n)))
+	var text strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&text, "b%d: %d,%d: Box %d\n", i, 1+3*(i%cols), 1+2*(i/cols), i)
+	}
+	links := 0
+	link := func(from, to int) {
+		fmt.Fprintf(&text, "b%d -> b%d", from, to)
+		if links%5 == 0 {
+			fmt.Fprintf(&text, ": step %d", links)
+		}
+		text.WriteString("\n")
+		links++
+	}
+	for i := 0; i < n; i++ {
+		if (i+1)%cols != 0 && i+1 < n {
+			link(i, i+1)
+		}
+		if i+cols < n {
+			link(i, i+cols)
+		}
+		if row := i / cols; i%cols == 0 && row > 0 && row%5 == 0 {
+			link(i, i-cols)
+		}
+		if i%50 == 0 {
+			link(i, i)
+		}
+	}
+	spec, err := parseDiagramSpec(text.String(), nil)
+	if err != nil {
+		b.Fatalf("parseDiagramSpec failed: %v", err)
+	}
+	return spec
+}
+
+func benchmarkLayout(b *testing.B, n int) {
+	spec := syntheticSpec(b, n)
+	config := newDefaultConfig()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		layout(spec, config, nil, nil, FlowDown)
+	}
+}
+
+func BenchmarkLayout_1kBoxes(b *testing.B)  { benchmarkLayout(b, 1000) }
+func BenchmarkLayout_10kBoxes(b *testing.B) { benchmarkLayout(b, 10000) }
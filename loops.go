@@ -0,0 +1,200 @@
+package control
+
+import "sort"
+
+// loopLaneSpacing is the distance between the content and the first loop
+// lane, and between neighbouring loop lanes
+const loopLaneSpacing = 25
+
+// isBackEdge reports whether an arrow from box1 to box2 runs against the
+// diagram flow: upwards for flow down, leftwards for flow right and so on.
+// Without a flow, diagrams read from the top left to the bottom right, so an
+// arrow back up and to the left is a back-edge.
+func isBackEdge(box1, box2 boxCoords, flow string) bool {
+	switch flow {
+	case FlowDown:
+		return box2.Y2 <= box1.Y1
+	case FlowUp:
+		return box2.Y1 >= box1.Y2
+	case FlowRight:
+		return box2.X2 <= box1.X1
+	case FlowLeft:
+		return box2.X1 >= box1.X2
+	case "":
+		return box2.Y2 <= box1.Y1 && box2.X2 <= box1.X1
+	}
+	return false
+}
+
+// planLoops routes feedback loops around the content through lanes beyond
+// it. Loops are arrows with the "loop" flow, and top-level arrows without
+// ports, waypoints or a flow of their own that are back-edges of the diagram
+// flow. Shorter loops take the inner lanes, so nested loops do not cross.
+// Lanes on the left stay clear of the y-axis when yAxis is set. Returns the
+// routes by arrow index, and the indexes of "loop" arrows for which no lane
+// was found.
+func planLoops(arrows []ArrowSpec, boxData map[string]placedBox, boxes *obstacleIndex, content boxCoords, flow string, nested map[string]bool, yAxis bool) (map[int][]int, []int) {
+	type loop struct {
+		index, span int
+		box1, box2  boxCoords
+	}
+	var loops []loop
+	for i, arrow := range arrows {
+		from, ok1 := boxData[arrow.FromID]
+		to, ok2 := boxData[arrow.ToID]
+		if !ok1 || !ok2 || arrow.FromID == arrow.ToID {
+			continue
+		}
+		box1, box2 := boxDataCoords(from), boxDataCoords(to)
+		auto := arrow.Flow == "" && len(arrow.Via) == 0 && arrow.FromSide == "" && arrow.ToSide == "" &&
+			!nested[arrow.FromID] && !nested[arrow.ToID] && isBackEdge(box1, box2, flow)
+		if arrow.Flow != FlowLoop && !auto {
+			continue
+		}
+		span := abs(from.CenterX-to.CenterX) + abs(from.CenterY-to.CenterY)
+		loops = append(loops, loop{index: i, span: span, box1: box1, box2: box2})
+	}
+	sort.SliceStable(loops, func(i, j int) bool { return loops[i].span < loops[j].span })
+
+	routes := make(map[int][]int)
+	var failed []int
+	lanes := make(map[string]int) // Lanes taken per side
+	leftLimit := 0                // Left canvas edge, or the y-axis
+	if yAxis {
+		leftLimit = axisX
+	}
+	for _, l := range loops {
+		arrow := arrows[l.index]
+		var best []int
+		bestSide := ""
+		for _, side := range loopSides(flow, arrowPorts{From: arrow.FromSide, To: arrow.ToSide}) {
+			points := routeLoop(l.box1, l.box2, side, lanes[side], content, leftLimit, boxes, arrow.FromID, arrow.ToID)
+			if points != nil && (best == nil || polylineLength(points) < polylineLength(best)) {
+				best, bestSide = points, side
+			}
+		}
+		if best == nil {
+			if arrow.Flow == FlowLoop {
+				failed = append(failed, l.index)
+			}
+			continue
+		}
+		lanes[bestSide]++
+		routes[l.index] = best
+	}
+	return routes, failed
+}
+
+// loopSides returns the sides of the content a loop may run along: the
+// bottom or top for diagrams that read sideways or diagonally, the right or
+// left for vertical flows, or the side both ports name
+func loopSides(flow string, ports arrowPorts) []string {
+	if side := ports.From; side != "" || ports.To != "" {
+		if side == "" {
+			side = ports.To
+		}
+		if ports.To != "" && ports.To != side {
+			return nil
+		}
+		return []string{side}
+	}
+	if verticalFlow(flow) {
+		return []string{SideRight, SideLeft}
+	}
+	return []string{SideBottom, SideTop}
+}
+
+// routeLoop routes an arrow from box1 to box2 through lane (0 = innermost)
+// beyond the given side of the content. Both ends attach to the boxes' sides
+// facing the lane, at the position nearest the middle whose leg to the lane
+// is clear of boxes. Returns nil if there is no such position or the lane
+// does not fit between the canvas edge (leftLimit on the left) and the content.
+func routeLoop(box1, box2 boxCoords, side string, lane int, content boxCoords, leftLimit int, boxes *obstacleIndex, fromID, toID string) []int {
+	_, _, edge := frameSpan(side, content)
+	laneV := edge + loopLaneSpacing*(lane+1)
+	limit := 0
+	if side == SideLeft {
+		limit = leftLimit
+	}
+	if (side == SideTop || side == SideLeft) && -laneV-limit < loopLaneSpacing/2 {
+		return nil // The canvas only grows to the right and the bottom
+	}
+
+	startU, ok1 := loopAnchor(box1, side, 0, laneV, boxes, fromID)
+	endU, ok2 := loopAnchor(box2, side, strokeAdjustment, laneV, boxes, toID)
+	if !ok1 || !ok2 || abs(startU-endU) < channelSpacing {
+		return nil
+	}
+	_, _, startV := frameSpan(side, box1)
+	_, _, endV := frameSpan(side, box2)
+	return fromFrame(side, []int{startU, startV, startU, laneV, endU, laneV, endU, endV + strokeAdjustment})
+}
+
+// loopAnchor returns where along a box side a leg out to laneV may attach:
+// the middle, or the nearest position to it whose leg is clear of other
+// boxes. Positions are in the frame of the side (see toFrame).
+func loopAnchor(box boxCoords, side string, offset, laneV int, boxes *obstacleIndex, id string) (int, bool) {
+	u1, u2, v := frameSpan(side, box)
+	center := (u1 + u2) / 2
+	for d := 0; d <= (u2-u1)/2-channelSpacing; d += channelSpacing {
+		for _, u := range []int{center - d, center + d} {
+			leg := fromFrame(side, []int{u, v + offset, u, laneV})
+			if !boxes.pathCollides(leg, id, id) {
+				return u, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// frameSpan returns the extent of a box along a side in the frame of that
+// side, and how far the side lies out
+func frameSpan(side string, box boxCoords) (u1, u2, v int) {
+	frame := toFrame(side, []int{box.X1, box.Y1, box.X2, box.Y2})
+	return min(frame[0], frame[2]), max(frame[0], frame[2]), max(frame[1], frame[3])
+}
+
+// loopPlan wraps a loop route as the routing plan of its arrow
+func loopPlan(points []int) *routingPlan {
+	last := len(points) - 2
+	candidate := routeCandidate{
+		startX: points[0], startY: points[1],
+		endX: points[last], endY: points[last+1],
+		strategy: "loop_lane", verticalFirst: points[0] == points[2],
+		segments: points, selected: true,
+	}
+	return &routingPlan{
+		StartX: points[0], StartY: points[1],
+		EndX: points[last], EndY: points[last+1],
+		Strategy:      "loop_lane",
+		VerticalFirst: candidate.verticalFirst,
+		NumSegments:   len(points)/2 - 1,
+		Points:        points,
+		AllCandidates: []routeCandidate{candidate},
+	}
+}
+
+// contentBounds returns the bounding box of the boxes, groups and container
+// frames of a diagram
+func contentBounds(diagram *diagram, boxes []placedBox) boxCoords {
+	var rects []boxCoords
+	for _, box := range boxes {
+		rects = append(rects, boxDataCoords(box))
+	}
+	for _, g := range diagram.Groups {
+		rects = append(rects, boxCoords{X1: g.X, Y1: g.Y, X2: g.X + g.Width, Y2: g.Y + g.Height})
+	}
+	for _, c := range diagram.Containers {
+		if c.Framed {
+			rects = append(rects, boxCoords{X1: c.X, Y1: c.Y, X2: c.X + c.Width, Y2: c.Y + c.Height})
+		}
+	}
+	if len(rects) == 0 {
+		return boxCoords{}
+	}
+	bounds := rects[0]
+	for _, r := range rects[1:] {
+		bounds = boxCoords{X1: min(bounds.X1, r.X1), Y1: min(bounds.Y1, r.Y1), X2: max(bounds.X2, r.X2), Y2: max(bounds.Y2, r.Y2)}
+	}
+	return bounds
+}
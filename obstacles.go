@@ -0,0 +1,151 @@
+package control
+
+import "slices"
+
+// obstacleCellSize is the edge length in pixels of the cells of an obstacle
+// index, about one box with its gap
+const obstacleCellSize = 256
+
+// obstacleIndex holds the boxes routes must avoid in a uniform grid over their
+// pixel bounds, so a collision check only looks at the boxes near a segment
+// instead of at all of them. A nil index holds no obstacles.
+type obstacleIndex struct {
+	boxes  []placedBox
+	cells  map[[2]int][]int // Indexes into boxes, by cell
+	extent boxCoords        // Bounding box of all boxes
+}
+
+// newObstacleIndex builds the index over boxes
+func newObstacleIndex(boxes []placedBox) *obstacleIndex {
+	idx := &obstacleIndex{
+		boxes: make([]placedBox, 0, len(boxes)),
+		cells: make(map[[2]int][]int, len(boxes)),
+	}
+	for _, box := range boxes {
+		idx.add(box)
+	}
+	return idx
+}
+
+// add inserts a box into every cell its bounds, with boxCollisionBuffer,
+// touch
+func (idx *obstacleIndex) add(box placedBox) {
+	i := len(idx.boxes)
+	if i == 0 {
+		idx.extent = boxDataCoords(box)
+	} else {
+		idx.extent = boxCoords{
+			X1: min(idx.extent.X1, box.PixelX),
+			Y1: min(idx.extent.Y1, box.PixelY),
+			X2: max(idx.extent.X2, box.PixelX+box.Width),
+			Y2: max(idx.extent.Y2, box.PixelY+box.Height),
+		}
+	}
+	idx.boxes = append(idx.boxes, box)
+	for cx := obstacleCell(box.PixelX - boxCollisionBuffer); cx <= obstacleCell(box.PixelX+box.Width+boxCollisionBuffer); cx++ {
+		for cy := obstacleCell(box.PixelY - boxCollisionBuffer); cy <= obstacleCell(box.PixelY+box.Height+boxCollisionBuffer); cy++ {
+			cell := [2]int{cx, cy}
+			idx.cells[cell] = append(idx.cells[cell], i)
+		}
+	}
+}
+
+// addPath inserts the segments of a polyline [x0,y0,...] as zero-width boxes,
+// all with the given ID
+func (idx *obstacleIndex) addPath(id string, points []int) {
+	for i := 0; i+3 < len(points); i += 2 {
+		x1, y1, x2, y2 := points[i], points[i+1], points[i+2], points[i+3]
+		idx.add(placedBox{ID: id, PixelX: min(x1, x2), PixelY: min(y1, y2), Width: abs(x2 - x1), Height: abs(y2 - y1)})
+	}
+}
+
+// all returns the boxes in the index
+func (idx *obstacleIndex) all() []placedBox {
+	if idx == nil {
+		return nil
+	}
+	return idx.boxes
+}
+
+// within returns the boxes whose bounds, with boxCollisionBuffer, overlap
+// a window
+func (idx *obstacleIndex) within(window boxCoords) []placedBox {
+	if idx == nil {
+		return nil
+	}
+	var found []int
+	for cx := obstacleCell(window.X1); cx <= obstacleCell(window.X2); cx++ {
+		for cy := obstacleCell(window.Y1); cy <= obstacleCell(window.Y2); cy++ {
+			for _, i := range idx.cells[[2]int{cx, cy}] {
+				if segmentHitsBox(window.X1, window.Y1, window.X2, window.Y2, &idx.boxes[i]) {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	slices.Sort(found) // A box spanning several cells is found once per cell
+	found = slices.Compact(found)
+	boxes := make([]placedBox, len(found))
+	for j, i := range found {
+		boxes[j] = idx.boxes[i]
+	}
+	return boxes
+}
+
+// bounds returns the bounding box of all boxes in the index, or false if it
+// holds none
+func (idx *obstacleIndex) bounds() (boxCoords, bool) {
+	if idx == nil || len(idx.boxes) == 0 {
+		return boxCoords{}, false
+	}
+	return idx.extent, true
+}
+
+// segmentCollides reports whether a horizontal or vertical segment intersects
+// any box whose ID is not in skip
+func (idx *obstacleIndex) segmentCollides(x1, y1, x2, y2 int, skip ...string) bool {
+	if idx == nil {
+		return false
+	}
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	for cx := obstacleCell(x1); cx <= obstacleCell(x2); cx++ {
+		for cy := obstacleCell(y1); cy <= obstacleCell(y2); cy++ {
+			for _, i := range idx.cells[[2]int{cx, cy}] {
+				box := &idx.boxes[i]
+				if !slices.Contains(skip, box.ID) && segmentHitsBox(x1, y1, x2, y2, box) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// boxCollides reports whether a box overlaps any box whose ID is not in skip
+func (idx *obstacleIndex) boxCollides(box placedBox, skip ...string) bool {
+	return idx.segmentCollides(box.PixelX, box.PixelY, box.PixelX+box.Width, box.PixelY+box.Height, skip...)
+}
+
+// pathCollides reports whether a polyline [x0,y0,...] intersects any box other
+// than its end boxes fromID and toID
+func (idx *obstacleIndex) pathCollides(points []int, fromID, toID string) bool {
+	for i := 0; i+3 < len(points); i += 2 {
+		if idx.segmentCollides(points[i], points[i+1], points[i+2], points[i+3], fromID, toID) {
+			return true
+		}
+	}
+	return false
+}
+
+// obstacleCell returns the index of the cell containing pixel coordinate v
+func obstacleCell(v int) int {
+	if v < 0 {
+		return (v+1)/obstacleCellSize - 1
+	}
+	return v / obstacleCellSize
+}
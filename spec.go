@@ -1,16 +1,40 @@
-package main
+package control
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
 // DiagramSpec represents the logical diagram structure (grid-based)
 type DiagramSpec struct {
-	Boxes  []BoxSpec
-	Arrows []ArrowSpec
-	Groups []GroupDef
+	Boxes       []BoxSpec
+	Arrows      []ArrowSpec
+	Groups      []GroupDef
+	Containers  []ContainerDef // In header order: a parent always precedes its nested containers
+	Diagnostics Diagnostics    // Non-fatal warnings found while parsing
+}
+
+// ContainerDef represents a container block ("ID: x,y: Label, style [ ... ]")
+type ContainerDef struct {
+	ID     string   // Fully scoped container ID (e.g., "A.B")
+	Label  string   // Optional title from the header
+	Parent string   // Scoped ID of the enclosing container ("" at top level)
+	BoxIDs []string // Member boxes, including those of nested containers
+	Framed bool     // Whether a visual frame is drawn (opt-in via header style)
+	Styles ContainerStyles
+}
+
+// ContainerStyles represents the parsed style attributes for a container frame
+type ContainerStyles struct {
+	FillColor   string // Frame background (default: none)
+	BorderColor string // Frame border color (default: #555)
+	BorderWidth int    // Frame border width (default: 2)
+	Dashed      bool   // Dashed border
+	TextColor   string // Title color (default: black)
+	TitleAlign  string // Title placement: "left" (default), "center" or "right"
 }
 
 // GroupDef represents a visual group that contains boxes
@@ -39,28 +63,135 @@ type BoxSpec struct {
 
 // ArrowSpec represents logical connection between boxes
 type ArrowSpec struct {
-	FromID string
-	ToID   string
-	Flow   string // Optional per-arrow flow hint (e.g., "down")
+	FromID      string
+	ToID        string
+	Flow        string // Optional per-arrow flow hint (e.g., FlowDown); "" = diagram default
+	Label       string // Optional text drawn on the arrow (e.g., "approves")
+	Connector   string // ConnectorForward, ConnectorBidirectional or ConnectorUndirected
+	Color       string // Optional stroke color (hex); default black
+	StrokeWidth int    // Optional stroke width; 0 = default (2)
+	Dash        string // ArrowDashed, ArrowDotted or "" for solid
+	Head        string // Optional arrowhead shape (e.g., HeadDiamond); "" = diagram default
+	Shape       string // Optional line shape (e.g., ArrowRounded); "" = diagram default
+	Via         []int  // Optional forced bend points [x0,y0,x1,y1,...] in grid coordinates ("via 4,3")
+	FromSide    string // Optional side the arrow leaves FromID on (e.g., SideBottom); "" = any
+	ToSide      string // Optional side the arrow enters ToID on (e.g., SideLeft); "" = any
+	Line        int    // Source line the arrow was defined on (0 if built programmatically)
 }
 
-// ParsedCoordinate represents a single parsed coordinate with metadata
-type ParsedCoordinate struct {
+// Arrow dash patterns (ArrowSpec.Dash)
+const (
+	ArrowDashed = "dashed"
+	ArrowDotted = "dotted"
+)
+
+// Arrow line shapes (ArrowSpec.Shape)
+const (
+	ArrowSharp   = "sharp"   // Hard-angled bends (default)
+	ArrowRounded = "rounded" // Rounded corners at each bend
+	ArrowCurved  = "curved"  // Smooth curves through the route
+)
+
+// thickArrowWidth is the stroke width of arrows with the "thick" option
+const thickArrowWidth = 4
+
+// Arrow connectors (ArrowSpec.Connector)
+const (
+	ConnectorForward       = ""    // "->", and "<-" stored with swapped IDs
+	ConnectorBidirectional = "<->" // Arrowheads at both ends
+	ConnectorUndirected    = "--"  // Plain line, no arrowheads
+)
+
+// Box sides for arrow ports ("A.bottom -> B.left")
+const (
+	SideTop    = "top"
+	SideBottom = "bottom"
+	SideLeft   = "left"
+	SideRight  = "right"
+)
+
+// Arrow flow hints (ArrowSpec.Flow, "arrow-flow" frontmatter)
+const (
+	FlowDown       = "down"       // Top-down: prefer vertical routes
+	FlowUp         = "up"         // Bottom-up: prefer vertical routes
+	FlowLeft       = "left"       // Right-to-left: prefer horizontal routes
+	FlowRight      = "right"      // Left-to-right: prefer horizontal routes
+	FlowHorizontal = "horizontal" // Either way sideways: prefer horizontal routes
+	FlowLoop       = "loop"       // Feedback loop: route around the outside (per arrow only)
+)
+
+// isFlow reports whether name is a supported flow hint
+func isFlow(name string) bool {
+	switch name {
+	case FlowDown, FlowUp, FlowLeft, FlowRight, FlowHorizontal:
+		return true
+	}
+	return false
+}
+
+// splitPort splits an optional port suffix off an arrow endpoint:
+// "A.bottom" → ("A", "bottom"), "G.X" → ("G.X", "")
+func splitPort(endpoint string) (id, side string) {
+	dot := strings.LastIndex(endpoint, ".")
+	if dot <= 0 {
+		return endpoint, ""
+	}
+	switch suffix := endpoint[dot+1:]; suffix {
+	case SideTop, SideBottom, SideLeft, SideRight:
+		return endpoint[:dot], suffix
+	}
+	return endpoint, ""
+}
+
+// HasStartHead reports whether the arrow is drawn with a head at its start
+func (a ArrowSpec) HasStartHead() bool {
+	return a.Connector == ConnectorBidirectional
+}
+
+// HasEndHead reports whether the arrow is drawn with a head at its end
+func (a ArrowSpec) HasEndHead() bool {
+	return a.Connector != ConnectorUndirected
+}
+
+// parseConnector returns the ArrowSpec.Connector for a connector written
+// between arrow endpoints, and whether the endpoints are swapped: "a <- b" is
+// stored as the forward arrow "b -> a". ok is false for an unknown connector.
+func parseConnector(written string) (connector string, reversed, ok bool) {
+	switch written {
+	case "->", ConnectorForward:
+		return ConnectorForward, false, true
+	case "<-":
+		return ConnectorForward, true, true
+	case ConnectorBidirectional, ConnectorUndirected:
+		return written, false, true
+	}
+	return "", false, false
+}
+
+// arrowConnector matches the connectors between arrow endpoints. "--" needs
+// surrounding whitespace because box IDs may contain dashes.
+var arrowConnector = regexp.MustCompile(`<->|->|<-|\s--\s`)
+
+// arrowVia matches one waypoint clause after the last arrow endpoint ("via 4,3")
+var arrowVia = regexp.MustCompile(`\s+via\s+(\S+?)\s*,\s*(\S+)`)
+
+// parsedCoordinate represents a single parsed coordinate with metadata
+type parsedCoordinate struct {
 	IsRelative bool // true if relative (+/- prefix or "0"), false if absolute
 	Value      int  // the numeric value (can be negative for relative)
 }
 
-// BoxCoordinates represents the complete coordinate pair for a box
-type BoxCoordinates struct {
-	X         ParsedCoordinate // Parsed X coordinate
-	Y         ParsedCoordinate // Parsed Y coordinate
+// boxCoordinates represents the complete coordinate pair for a box
+type boxCoordinates struct {
+	X         parsedCoordinate // Parsed X coordinate
+	Y         parsedCoordinate // Parsed Y coordinate
 	AutoArrow bool             // Whether this box had the ">" auto-arrow prefix
 	GridX     int              // Resolved absolute grid X
 	GridY     int              // Resolved absolute grid Y
 }
 
-// BoxStyles represents the parsed style attributes for a box
-type BoxStyles struct {
+// boxStyles represents the parsed style attributes for a box
+type boxStyles struct {
 	BackgroundColor string
 	BorderColor     string
 	BorderWidth     int
@@ -69,45 +200,45 @@ type BoxStyles struct {
 }
 
 // parseCoordinate parses a single coordinate value (GridX or GridY)
-// Returns: ParsedCoordinate and error
+// Returns: parsedCoordinate and error
 // Examples:
 //
-//	"5"   -> ParsedCoordinate{IsRelative: false, Value: 5}      // Absolute
-//	"+2"  -> ParsedCoordinate{IsRelative: true, Value: 2}       // Relative positive
-//	"-1"  -> ParsedCoordinate{IsRelative: true, Value: -1}      // Relative negative
-//	"0"   -> ParsedCoordinate{IsRelative: true, Value: 0}       // Shorthand for "+0"
-func parseCoordinate(coordStr string) (ParsedCoordinate, error) {
+//	"5"   -> parsedCoordinate{IsRelative: false, Value: 5}      // Absolute
+//	"+2"  -> parsedCoordinate{IsRelative: true, Value: 2}       // Relative positive
+//	"-1"  -> parsedCoordinate{IsRelative: true, Value: -1}      // Relative negative
+//	"0"   -> parsedCoordinate{IsRelative: true, Value: 0}       // Shorthand for "+0"
+func parseCoordinate(coordStr string) (parsedCoordinate, error) {
 	coordStr = strings.TrimSpace(coordStr)
 
 	// Shorthand: "0" means relative zero "+0"
 	// (Absolute 0 is impossible since grid starts at 1)
 	if coordStr == "0" {
-		return ParsedCoordinate{IsRelative: true, Value: 0}, nil
+		return parsedCoordinate{IsRelative: true, Value: 0}, nil
 	}
 
 	// Check for explicit relative prefix
 	if strings.HasPrefix(coordStr, "+") {
 		// Relative positive
 		value, err := strconv.Atoi(coordStr[1:])
-		return ParsedCoordinate{IsRelative: true, Value: value}, err
+		return parsedCoordinate{IsRelative: true, Value: value}, err
 	}
 
 	if strings.HasPrefix(coordStr, "-") {
 		// Relative negative
 		value, err := strconv.Atoi(coordStr[1:])
 		if err != nil {
-			return ParsedCoordinate{}, err
+			return parsedCoordinate{}, err
 		}
-		return ParsedCoordinate{IsRelative: true, Value: -value}, nil
+		return parsedCoordinate{IsRelative: true, Value: -value}, nil
 	}
 
 	// Absolute coordinate (no prefix)
 	value, err := strconv.Atoi(coordStr)
-	return ParsedCoordinate{IsRelative: false, Value: value}, err
+	return parsedCoordinate{IsRelative: false, Value: value}, err
 }
 
-// parseBoxStyles parses a style string (e.g., "rb-g-rt") into BoxStyles
-// Returns: BoxStyles with parsed attributes
+// parseboxStyles parses a style string (e.g., "rb-g-rt") into boxStyles
+// Returns: boxStyles with parsed attributes
 // Supported styles:
 //   - "rb": Red border (3px width)
 //   - "g": Gray background
@@ -116,8 +247,8 @@ func parseCoordinate(coordStr string) (ParsedCoordinate, error) {
 //   - "nbb": No background, no border
 //   - "rt": Red text
 //   - "2t": Double text size (48px)
-func parseBoxStyles(styleStr string, customColors map[string]string) BoxStyles {
-	styles := BoxStyles{
+func parseBoxStyles(styleStr string, customColors map[string]string) boxStyles {
+	styles := boxStyles{
 		BackgroundColor: "",
 		BorderColor:     "",
 		BorderWidth:     0,
@@ -153,11 +284,11 @@ func parseBoxStyles(styleStr string, customColors map[string]string) BoxStyles {
 		default:
 			// Check custom colors: "green" → background, "greent" → text color
 			if customColors != nil {
-				if hex, ok := customColors[style]; ok {
+				if hex, ok := customColor(customColors, style); ok {
 					styles.BackgroundColor = hex
 				} else if strings.HasSuffix(style, "t") {
 					name := style[:len(style)-1]
-					if hex, ok := customColors[name]; ok {
+					if hex, ok := customColor(customColors, name); ok {
 						styles.TextColor = hex
 					}
 				}
@@ -168,6 +299,116 @@ func parseBoxStyles(styleStr string, customColors map[string]string) BoxStyles {
 	return styles
 }
 
+// unknownStyleCodes returns the codes in a style string that parseBoxStyles does not recognize
+func unknownStyleCodes(styleStr string, customColors map[string]string) []string {
+	if styleStr == "" {
+		return nil
+	}
+	var unknown []string
+	for _, style := range strings.Split(styleStr, "-") {
+		style = strings.TrimSpace(style)
+		switch style {
+		case "rb", "g", "p", "lp", "nbb", "rt", "2t":
+			continue
+		}
+		if _, ok := customColor(customColors, style); ok {
+			continue
+		}
+		if _, ok := customColor(customColors, strings.TrimSuffix(style, "t")); ok && strings.HasSuffix(style, "t") {
+			continue
+		}
+		unknown = append(unknown, style)
+	}
+	return unknown
+}
+
+// parseContainerStyles parses a container header style string (e.g., "frame-dash-g").
+// Container-only codes are handled here; all other codes are interpreted like box
+// styles, where the background becomes the frame fill. Returns the codes it does
+// not recognize.
+// Container-only styles:
+//   - "frame": Draw the frame with default styling
+//   - "dash": Dashed border
+//   - "tc": Title centered
+//   - "tr": Title right-aligned
+func parseContainerStyles(styleStr string, customColors map[string]string) (ContainerStyles, []string) {
+	styles := ContainerStyles{TitleAlign: "left"}
+	var boxCodes []string
+	for _, style := range strings.Split(styleStr, "-") {
+		style = strings.TrimSpace(style)
+		switch style {
+		case "frame":
+		case "dash":
+			styles.Dashed = true
+		case "tc":
+			styles.TitleAlign = "center"
+		case "tr":
+			styles.TitleAlign = "right"
+		default:
+			boxCodes = append(boxCodes, style)
+		}
+	}
+	boxStyleStr := strings.Join(boxCodes, "-")
+	boxStyles := parseBoxStyles(boxStyleStr, customColors)
+	styles.FillColor = boxStyles.BackgroundColor
+	styles.BorderColor = boxStyles.BorderColor
+	styles.BorderWidth = boxStyles.BorderWidth
+	styles.TextColor = boxStyles.TextColor
+	return styles, unknownStyleCodes(boxStyleStr, customColors)
+}
+
+// customColor looks up a custom color by name. Colors whose value is not a
+// valid color are treated as undefined, as they end up in SVG attributes.
+func customColor(customColors map[string]string, name string) (string, bool) {
+	value, ok := customColors[name]
+	if !ok || !validColor(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// parseArrowOptions parses the options after "|" on an arrow line into arrow,
+// e.g. "down", "dashed-red" or "down, thick". Codes are separated by spaces,
+// commas or dashes. Returns the codes it does not recognize.
+// Supported options:
+//   - "down", "up", "left", "right", "horizontal": Flow hint for routing
+//   - "loop": Route around the outside of the diagram
+//   - "dashed", "dotted": Line pattern
+//   - "thick": Thick line (4px)
+//   - A CSS color keyword such as "red", or a custom color name: Line and
+//     arrowhead color (custom colors must be "#rgb", "#rrggbb" or a keyword)
+//   - "chevron", "triangle", "hollow", "diamond", "circle", "bar": Arrowhead shape
+//   - "sharp", "rounded", "curved": Line shape
+func parseArrowOptions(optStr string, arrow *ArrowSpec, customColors map[string]string) []string {
+	var unknown []string
+	codes := strings.FieldsFunc(optStr, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ',' || r == '-'
+	})
+	for _, code := range codes {
+		switch code {
+		case FlowDown, FlowUp, FlowLeft, FlowRight, FlowHorizontal, FlowLoop:
+			arrow.Flow = code
+		case ArrowDashed, ArrowDotted:
+			arrow.Dash = code
+		case "thick":
+			arrow.StrokeWidth = thickArrowWidth
+		case HeadChevron, HeadTriangle, HeadHollow, HeadDiamond, HeadCircle, HeadBar:
+			arrow.Head = code
+		case ArrowSharp, ArrowRounded, ArrowCurved:
+			arrow.Shape = code
+		default:
+			if hex, ok := customColor(customColors, code); ok {
+				arrow.Color = hex
+			} else if hex, ok := namedColors[code]; ok {
+				arrow.Color = hex
+			} else {
+				unknown = append(unknown, code)
+			}
+		}
+	}
+	return unknown
+}
+
 // parseNumberOrFraction parses a string that can be an integer, decimal, or fraction
 // Examples:
 //   - Integer: "2" → 2.0
@@ -217,16 +458,25 @@ type LegendEntry struct {
 
 // Frontmatter represents metadata parsed from the top of a diagram file
 type Frontmatter struct {
-	Font      string            // Path to custom font file (WOFF2 format)
-	XLabel    string            // X-axis label; empty string (default) = no axis drawn
-	YLabel    string            // Y-axis label; empty string (default) = no axis drawn
-	Legend    []LegendEntry     // Legend entries mapping style codes to descriptions
-	Colors    map[string]string // Custom color definitions (name -> hex)
-	ArrowFlow string            // Global arrow flow direction (e.g., "down" for top-down routing)
+	Font       string            // Path to custom font file (WOFF2 format)
+	XLabel     string            // X-axis label; empty string (default) = no axis drawn
+	YLabel     string            // Y-axis label; empty string (default) = no axis drawn
+	Legend     []LegendEntry     // Legend entries mapping style codes to descriptions
+	Colors     map[string]string // Custom color definitions (name -> hex)
+	ArrowFlow  string            // Global arrow flow hint (e.g., "down" for top-down routing)
+	Arrowhead  string            // Default arrowhead shape (e.g., "triangle"); empty = chevron
+	Crossings  string            // How arrow crossings are drawn (e.g., "hop"); empty = plain
+	ArrowShape string            // Default arrow line shape (e.g., "rounded"); empty = sharp
+	ArrowMerge string            // Bus merging of fan-outs and fan-ins ("bus" or "none"); empty = bus for flow down
+
+	// BodyOffset is the number of lines stripped from the top of the file.
+	// Add it to line numbers in the remaining text to get original file positions.
+	BodyOffset int
 }
 
 // ParseFrontmatter extracts frontmatter key:value pairs from the top of diagram text.
 // Returns the parsed frontmatter and the remaining text with frontmatter lines stripped.
+// The number of stripped lines is recorded in Frontmatter.BodyOffset.
 // Supports two formats:
 //  1. Delimited: lines between opening and closing "---" markers
 //  2. Undelimited: key:value lines at the top (stops at first unrecognized line)
@@ -257,6 +507,7 @@ func ParseFrontmatter(text string) (Frontmatter, string) {
 			// Closing delimiter ends frontmatter
 			if trimmed == "---" {
 				consumedLines++ // consume closing ---
+				fm.BodyOffset = consumedLines
 				remaining := strings.Join(lines[consumedLines:], "\n")
 				return fm, remaining
 			}
@@ -265,6 +516,7 @@ func ParseFrontmatter(text string) (Frontmatter, string) {
 			parseFrontmatterKey(&fm, trimmed)
 		}
 		// Reached end of input without closing ---; treat entire input as frontmatter
+		fm.BodyOffset = len(lines)
 		return fm, ""
 	}
 
@@ -287,6 +539,7 @@ func ParseFrontmatter(text string) (Frontmatter, string) {
 		break
 	}
 
+	fm.BodyOffset = consumedLines
 	remaining := strings.Join(lines[consumedLines:], "\n")
 	return fm, remaining
 }
@@ -332,6 +585,26 @@ func parseFrontmatterKey(fm *Frontmatter, trimmed string) bool {
 		return true
 	}
 
+	if strings.HasPrefix(trimmed, "arrowhead:") {
+		fm.Arrowhead = strings.TrimSpace(strings.TrimPrefix(trimmed, "arrowhead:"))
+		return true
+	}
+
+	if strings.HasPrefix(trimmed, "arrow-merge:") {
+		fm.ArrowMerge = strings.TrimSpace(strings.TrimPrefix(trimmed, "arrow-merge:"))
+		return true
+	}
+
+	if strings.HasPrefix(trimmed, "arrow-shape:") {
+		fm.ArrowShape = strings.TrimSpace(strings.TrimPrefix(trimmed, "arrow-shape:"))
+		return true
+	}
+
+	if strings.HasPrefix(trimmed, "crossings:") {
+		fm.Crossings = strings.TrimSpace(strings.TrimPrefix(trimmed, "crossings:"))
+		return true
+	}
+
 	if strings.HasPrefix(trimmed, "color:") {
 		value := strings.TrimSpace(strings.TrimPrefix(trimmed, "color:"))
 		parts := strings.SplitN(value, "=", 2)
@@ -349,459 +622,898 @@ func parseFrontmatterKey(fm *Frontmatter, trimmed string) bool {
 	return false
 }
 
-// ParseDiagramSpec parses the text format into a DiagramSpec
-func ParseDiagramSpec(text string, customColors map[string]string) (*DiagramSpec, error) {
-	spec := &DiagramSpec{
-		Boxes:  []BoxSpec{},
-		Arrows: []ArrowSpec{},
+// ParseOptions carries source information used to position diagnostics
+type ParseOptions struct {
+	File       string // File name reported in diagnostics
+	LineOffset int    // Lines preceding the text in the original file (e.g., Frontmatter.BodyOffset)
+	MaxErrors  int    // Stop after this many errors (0 = unlimited)
+}
+
+// parseDiagramSpec parses the text format into a DiagramSpec
+func parseDiagramSpec(text string, customColors map[string]string) (*DiagramSpec, error) {
+	return parseDiagramSpecWithOptions(text, customColors, ParseOptions{})
+}
+
+// parseDiagramSpecWithOptions parses the text format into a DiagramSpec.
+// The parser recovers at line level: a bad line is reported and skipped, and
+// parsing continues so that all problems are found in one pass.
+// Errors are returned as Diagnostics positioned in the original file.
+func parseDiagramSpecWithOptions(text string, customColors map[string]string, opts ParseOptions) (*DiagramSpec, error) {
+	p := &specParser{
+		spec: &DiagramSpec{
+			Boxes:  []BoxSpec{},
+			Arrows: []ArrowSpec{},
+		},
+		opts:             opts,
+		customColors:     customColors,
+		groupDefs:        make(map[string]string),
+		boxGroups:        make(map[string]string),
+		containerGrouped: make(map[string]bool),
+		failedIDs:        make(map[string]bool),
+		definedIDs:       make(map[string]int),
 	}
 
-	lines := strings.Split(text, "\n")
-	var previousBoxID string             // Track previous box for auto-arrows
-	var previousGridX int                // Track previous box GridX for relative coordinates
-	var previousGridY int                // Track previous box GridY for relative coordinates
-	internalIDCounter := 0               // Counter for generating internal IDs for unlabeled boxes
-	groupDefs := make(map[string]string) // Group name -> label (from @Group: Label lines)
-	boxGroups := make(map[string]string) // Box ID -> group name (from @Group suffix on box lines)
-
-	// Container state (purely organizational, no visual rendering)
-	var inContainer bool
-	var containerID string
-	var containerBaseX, containerBaseY int
-	var containerBoxIDs []string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		// Skip comment lines
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		// Detect container closing "]" with optional @GroupName suffix
-		if line == "]" || strings.HasPrefix(line, "] ") {
-			if !inContainer {
-				return nil, fmt.Errorf("unexpected ']' outside container")
-			}
-			// Check for @GroupName suffix on the closing line
-			if suffix := strings.TrimSpace(strings.TrimPrefix(line, "]")); suffix != "" {
-				if !strings.HasPrefix(suffix, "@") {
-					return nil, fmt.Errorf("invalid container closing syntax: '%s' (expected '] @GroupName')", line)
-				}
-				containerGroup := strings.TrimPrefix(suffix, "@")
-				for _, boxID := range containerBoxIDs {
-					boxGroups[boxID] = containerGroup
-					// Update BoxSpec.Group field as well
-					for i := range spec.Boxes {
-						if spec.Boxes[i].ID == boxID {
-							spec.Boxes[i].Group = containerGroup
-							break
-						}
-					}
-				}
+	for _, name := range invalidColors(customColors) {
+		p.report(p.diagnosticAt(0, 0, SeverityWarning, CodeStyle,
+			"custom color '%s': '%s' is not a hex color or color name (ignored)", name, customColors[name]))
+	}
+
+	for i, raw := range strings.Split(text, "\n") {
+		line, start := trimOffset(raw)
+		p.lineStart = start
+		p.lineNo = opts.LineOffset + i + 1
+		if err := p.parseLine(line); err != nil {
+			if p.report(err) {
+				return nil, p.diagnostics
 			}
-			inContainer = false
-			containerID = ""
-			containerBoxIDs = nil
-			continue
 		}
+	}
 
-		// Detect container header: line ends with "["
-		if strings.HasSuffix(line, "[") {
-			if inContainer {
-				return nil, fmt.Errorf("nested containers not supported")
-			}
-			// Strip "[" and trim
-			headerStr := strings.TrimSpace(strings.TrimSuffix(line, "["))
+	if p.finish() {
+		return nil, p.diagnostics
+	}
+	p.diagnostics.sortByPosition()
+	if p.diagnostics.HasErrors() {
+		return nil, p.diagnostics
+	}
+	p.spec.Diagnostics = p.diagnostics
+	return p.spec, nil
+}
 
-			// Parse: "ID: x,y [" or "ID: x,y: Label ["
-			headerParts := strings.SplitN(headerStr, ":", 3)
-			if len(headerParts) < 2 {
-				return nil, fmt.Errorf("invalid container definition: '%s'", line)
-			}
-			containerID = strings.TrimSpace(headerParts[0])
-			coordsStr := strings.TrimSpace(headerParts[1])
+// arrowSource records where an arrow was defined, for reference validation
+type arrowSource struct {
+	line, fromColumn, toColumn int
+	scope                      string // Container scope the arrow was written in ("" = top level)
+	fromLocal, toLocal         string // IDs as written, before scoping
+}
 
-			coords := strings.Split(coordsStr, ",")
-			if len(coords) != 2 {
-				return nil, fmt.Errorf("invalid container coordinates: '%s'", line)
-			}
+// containerFrame is one open container on the nesting stack
+type containerFrame struct {
+	id           string   // Fully scoped container ID (e.g., "A.B")
+	baseX, baseY int      // Absolute grid origin
+	boxIDs       []string // Boxes defined in this container, including nested ones
+	line, column int      // Position of the header, for "unclosed" diagnostics
+	defIndex     int      // Index of the matching ContainerDef in spec.Containers
+}
 
-			baseX, err := strconv.Atoi(strings.TrimSpace(coords[0]))
-			if err != nil {
-				return nil, fmt.Errorf("invalid container X coordinate in line: '%s'", line)
-			}
-			baseY, err := strconv.Atoi(strings.TrimSpace(coords[1]))
-			if err != nil {
-				return nil, fmt.Errorf("invalid container Y coordinate in line: '%s'", line)
-			}
+// specParser holds the state carried between lines while parsing a diagram
+type specParser struct {
+	spec         *DiagramSpec
+	opts         ParseOptions
+	customColors map[string]string
+
+	previousBoxID     string            // Track previous box for auto-arrows
+	previousGridX     int               // Track previous box GridX for relative coordinates
+	previousGridY     int               // Track previous box GridY for relative coordinates
+	internalIDCounter int               // Counter for generating internal IDs for unlabeled boxes
+	groupDefs         map[string]string // Group name -> label (from @Group: Label lines)
+	boxGroups         map[string]string // Box ID -> group name (from @Group suffix on box lines)
+	failedIDs         map[string]bool   // IDs of boxes whose definition had errors (suppresses follow-up arrow errors)
+	definedIDs        map[string]int    // Scoped box and container IDs -> line of their definition
+	containerGrouped  map[string]bool   // Box IDs already assigned a group by a closing "] @Group"
+
+	// Container stack; innermost last
+	containers []containerFrame
+
+	arrowSources []arrowSource // Source position of each arrow, parallel to spec.Arrows
+
+	// Current source line, used to position diagnostics
+	lineStart int // Byte offset of the parsed (trimmed) line in the source line
+	lineNo    int
+
+	diagnostics Diagnostics
+	errorCount  int
+}
+
+// report records a diagnostic and returns true once the error limit is reached
+func (p *specParser) report(err error) bool {
+	var diag Diagnostic
+	if !errors.As(err, &diag) {
+		diag = Diagnostic{File: p.opts.File, Line: p.lineNo, Severity: SeverityError, Message: err.Error()}
+	}
+	p.diagnostics = append(p.diagnostics, diag)
+	if diag.Severity != SeverityError {
+		return false
+	}
+	p.errorCount++
+	if p.opts.MaxErrors > 0 && p.errorCount >= p.opts.MaxErrors {
+		p.diagnostics = append(p.diagnostics, Diagnostic{
+			File:     p.opts.File,
+			Severity: SeverityError,
+			Code:     CodeTooManyErrors,
+			Message:  fmt.Sprintf("too many errors (limit %d), stopping", p.opts.MaxErrors),
+		})
+		return true
+	}
+	return false
+}
 
-			containerBaseX = baseX
-			containerBaseY = baseY
+// column converts a byte offset into the parsed line to a 1-based source column
+func (p *specParser) column(offset int) int {
+	return p.lineStart + offset + 1
+}
 
-			// Set previous position to container base so relative coords inside work
-			previousGridX = containerBaseX
-			previousGridY = containerBaseY
+// errAt builds a diagnostic at a byte offset into the current parsed line
+func (p *specParser) errAt(offset int, code, format string, args ...any) error {
+	return p.diagnosticAt(p.lineNo, p.column(offset), SeverityError, code, format, args...)
+}
 
-			inContainer = true
-			continue
+// diagnosticAt builds a diagnostic at an explicit position
+func (p *specParser) diagnosticAt(line, column int, severity Severity, code, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		File:     p.opts.File,
+		Line:     line,
+		Column:   column,
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// parseLine dispatches a single trimmed line to the matching parser
+func (p *specParser) parseLine(line string) error {
+	if line == "" {
+		return nil
+	}
+	// Skip comment lines
+	if strings.HasPrefix(line, "#") {
+		return nil
+	}
+	// Detect container closing "]" with optional @GroupName suffix
+	if line == "]" || strings.HasPrefix(line, "] ") {
+		return p.parseContainerClose(line)
+	}
+	// Detect container header: line ends with "["
+	if strings.HasSuffix(line, "[") {
+		return p.parseContainerHeader(line)
+	}
+	// Arrow lines (containing "->", "<-", "<->" or " -- ") are recognized anywhere
+	if arrowConnector.MatchString(line) {
+		if handled, err := p.parseArrow(line); handled {
+			return err
 		}
+	}
+	// Check for group definition line: @GroupName: Label
+	if strings.HasPrefix(line, "@") {
+		p.parseGroupDef(line)
+		return nil
+	}
+	return p.parseBox(line)
+}
 
-		// Arrow lines (containing "->") are recognized anywhere
-		if strings.Contains(line, "->") {
-			parts := strings.Split(line, "->")
-			if len(parts) == 2 {
-				from := strings.TrimSpace(parts[0])
-				toAndFlow := strings.TrimSpace(parts[1])
-
-				// Parse optional "| flow" suffix (e.g., "HE | down")
-				var to, arrowFlow string
-				if pipeIdx := strings.Index(toAndFlow, "|"); pipeIdx >= 0 {
-					to = strings.TrimSpace(toAndFlow[:pipeIdx])
-					arrowFlow = strings.TrimSpace(toAndFlow[pipeIdx+1:])
-				} else {
-					to = toAndFlow
-				}
+// inContainer reports whether the parser is inside at least one container
+func (p *specParser) inContainer() bool {
+	return len(p.containers) > 0
+}
 
-				if from != "" && to != "" {
-					// Auto-scope arrow IDs inside containers
-					if inContainer {
-						from = containerID + "." + from
-						to = containerID + "." + to
-					}
-					// Manual arrows cannot reference internal IDs
-					if strings.HasPrefix(from, "_box_") || strings.Contains(from, "._box_") {
-						return nil, fmt.Errorf("arrow '%s -> %s' references box without explicit label (internal ID: %s)", from, to, from)
-					}
-					if strings.HasPrefix(to, "_box_") || strings.Contains(to, "._box_") {
-						return nil, fmt.Errorf("arrow '%s -> %s' references box without explicit label (internal ID: %s)", from, to, to)
-					}
-					spec.Arrows = append(spec.Arrows, ArrowSpec{
-						FromID: from,
-						ToID:   to,
-						Flow:   arrowFlow,
-					})
-					continue
-				}
-			}
-		}
+// currentContainer returns the innermost open container
+func (p *specParser) currentContainer() *containerFrame {
+	return &p.containers[len(p.containers)-1]
+}
 
-		// Check for group definition line: @GroupName: Label
-		if strings.HasPrefix(line, "@") {
-			groupLine := line[1:] // Strip "@"
-			groupParts := strings.SplitN(groupLine, ":", 2)
-			if len(groupParts) == 0 {
-				continue
-			}
-			groupName := strings.TrimSpace(groupParts[0])
-			groupLabel := groupName // Default label is the group name
-			if len(groupParts) == 2 {
-				groupLabel = strings.TrimSpace(groupParts[1])
-			}
-			groupDefs[groupName] = groupLabel
-			continue
-		}
+// scope returns the ID prefix of the innermost open container ("" at top level)
+func (p *specParser) scope() string {
+	if !p.inContainer() {
+		return ""
+	}
+	return p.currentContainer().id
+}
 
-		// Parse box: "dev: 1,2: Sprint Planning" or ">3,2: Daily Standup" (no ID)
-		parts := strings.SplitN(line, ":", 3)
+// scoped prefixes a local ID with the innermost container scope: "X" → "A.B.X"
+func (p *specParser) scoped(id string) string {
+	return scopedID(p.scope(), id)
+}
 
-		var id string
-		var coordsAndLabelParts []string
+// scopedID joins a container scope and a local ID
+func scopedID(scope, id string) string {
+	if scope == "" {
+		return id
+	}
+	return scope + "." + id
+}
 
-		if len(parts) == 3 {
-			// Format: "ID: coords: label"
-			id = strings.TrimSpace(parts[0])
-			// Validate ID: alphanumeric + underscore + hyphen only
-			if id == "" {
-				return nil, fmt.Errorf("invalid box definition: empty ID in line '%s'", line)
+// parseContainerClose handles "]" and "] @GroupName"
+func (p *specParser) parseContainerClose(line string) error {
+	if !p.inContainer() {
+		return p.errAt(0, CodeContainer, "unexpected ']' outside container")
+	}
+	// The container ends even if the suffix is malformed, so later lines stay in scope
+	frame := p.containers[len(p.containers)-1]
+	p.containers = p.containers[:len(p.containers)-1]
+	p.spec.Containers[frame.defIndex].BoxIDs = frame.boxIDs
+	if p.inContainer() {
+		// Members of a nested container are members of its parent too
+		parent := p.currentContainer()
+		parent.boxIDs = append(parent.boxIDs, frame.boxIDs...)
+	}
+
+	// Check for @GroupName suffix on the closing line
+	if suffix, offset := trimOffset(strings.TrimPrefix(line, "]")); suffix != "" {
+		if !strings.HasPrefix(suffix, "@") {
+			return p.errAt(1+offset, CodeContainer, "invalid container closing syntax: '%s' (expected '] @GroupName')", line)
+		}
+		containerGroup := strings.TrimPrefix(suffix, "@")
+		for _, boxID := range frame.boxIDs {
+			// The innermost container's group wins over outer ones
+			if p.containerGrouped[boxID] {
+				continue
 			}
-			for _, ch := range id {
-				if (ch < 'a' || ch > 'z') && (ch < 'A' || ch > 'Z') &&
-					(ch < '0' || ch > '9') && ch != '_' && ch != '-' {
-					return nil, fmt.Errorf("invalid ID '%s': must contain only alphanumeric characters, underscore, or hyphen", id)
+			p.containerGrouped[boxID] = true
+			p.boxGroups[boxID] = containerGroup
+			// Update BoxSpec.Group field as well
+			for i := range p.spec.Boxes {
+				if p.spec.Boxes[i].ID == boxID {
+					p.spec.Boxes[i].Group = containerGroup
+					break
 				}
 			}
-			coordsAndLabelParts = parts[1:3]
-		} else if len(parts) == 2 {
-			// Format: "coords: label" (no ID)
-			id = "" // Will be assigned internal ID if needed
-			coordsAndLabelParts = parts
-		} else {
-			return nil, fmt.Errorf("invalid box definition: '%s'", line)
 		}
+	}
+	return nil
+}
 
-		// Generate internal ID for boxes without explicit IDs
-		if id == "" {
-			id = fmt.Sprintf("_box_%d", internalIDCounter)
-			internalIDCounter++
-		}
+// parseContainerHeader handles "ID: x,y [", "ID: x,y: Label [" and "ID: x,y: Label, style ["
+// Coordinates of a nested container are relative to its parent's origin.
+// A style part opts the container into a visual frame.
+func (p *specParser) parseContainerHeader(line string) error {
+	// Strip "[" and trim
+	headerStr := strings.TrimSpace(strings.TrimSuffix(line, "["))
+
+	// Parse: "ID: x,y [" or "ID: x,y: Label ["
+	headerParts := strings.SplitN(headerStr, ":", 3)
+	if len(headerParts) < 2 {
+		return p.errAt(0, CodeContainer, "invalid container definition: '%s'", line)
+	}
 
-		// Scope box IDs inside containers: "X" → "G.X"
-		if inContainer {
-			id = containerID + "." + id
+	// Enter the container even if its coordinates are invalid, so the matching
+	// "]" is not reported again; contents are then placed at the parent origin
+	originX, originY := 0, 0
+	if p.inContainer() {
+		originX, originY = p.currentContainer().baseX, p.currentContainer().baseY
+	}
+	localID, idOffset := trimOffset(headerParts[0])
+	def := ContainerDef{
+		ID:     p.scoped(localID),
+		Parent: p.scope(),
+	}
+	p.containers = append(p.containers, containerFrame{
+		id:       def.ID,
+		baseX:    max(originX, 1),
+		baseY:    max(originY, 1),
+		line:     p.lineNo,
+		column:   p.column(0),
+		defIndex: len(p.spec.Containers),
+	})
+	frame := p.currentContainer()
+	p.previousGridX, p.previousGridY = frame.baseX, frame.baseY
+
+	// Parse optional label and frame style: "Label, style"
+	coordsOffset := len(headerParts[0]) + 1
+	if len(headerParts) == 3 {
+		labelParts := strings.SplitN(headerParts[2], ",", 2)
+		def.Label = strings.TrimSpace(labelParts[0])
+		if len(labelParts) > 1 {
+			styleStr, styleOffset := trimOffset(labelParts[1])
+			styleOffset += coordsOffset + len(headerParts[1]) + 1 + len(labelParts[0]) + 1
+			styles, unknown := parseContainerStyles(styleStr, p.customColors)
+			for _, code := range unknown {
+				p.report(p.diagnosticAt(p.lineNo, p.column(styleOffset), SeverityWarning, CodeStyle,
+					"container '%s': unknown style code '%s' (ignored)", def.ID, code))
+			}
+			def.Framed = styleStr != ""
+			def.Styles = styles
 		}
+	}
+	p.spec.Containers = append(p.spec.Containers, def)
 
-		// Check for auto-arrow prefix ">" or touch-left prefix "|"
-		coordsStr := strings.TrimSpace(coordsAndLabelParts[0])
-		autoArrow := strings.HasPrefix(coordsStr, ">")
-		touchLeft := strings.HasPrefix(coordsStr, "|")
+	if localID == "" {
+		return p.errAt(0, CodeInvalidID, "invalid container definition: empty ID in line '%s'", line)
+	}
+	if !validBoxID(localID) {
+		return p.errAt(idOffset, CodeInvalidID, "invalid container ID '%s': must contain only alphanumeric characters, underscore, or hyphen", localID)
+	}
+	if err := p.defineID(def.ID, idOffset); err != nil {
+		return err
+	}
 
-		if autoArrow {
-			// Check if this is the first box
-			if previousBoxID == "" {
-				return nil, fmt.Errorf("first box (label '%s') cannot have auto-arrow prefix '>'", id)
-			}
-			// Strip the ">" prefix
-			coordsStr = strings.TrimPrefix(coordsStr, ">")
-		} else if touchLeft {
-			// Check if this is the first box
-			if previousBoxID == "" {
-				idStr := id
-				if idStr == "" {
-					idStr = "(unlabeled)"
-				}
-				return nil, fmt.Errorf("first box (label '%s') cannot have touch-left prefix '|'", idStr)
-			}
-			// Strip the "|" prefix
-			coordsStr = strings.TrimPrefix(coordsStr, "|")
-		}
+	coordsStr, offset := trimOffset(headerParts[1])
+	coordsOffset += offset
+	coords := strings.Split(coordsStr, ",")
+	if len(coords) != 2 {
+		return p.errAt(coordsOffset, CodeContainer, "invalid container coordinates: '%s'", line)
+	}
 
-		coords := strings.Split(coordsStr, ",")
-		if len(coords) != 2 && len(coords) != 3 && len(coords) != 4 {
-			return nil, fmt.Errorf("invalid coordinate definition: '%s'", line)
-		}
+	baseX, err := strconv.Atoi(strings.TrimSpace(coords[0]))
+	if err != nil {
+		return p.errAt(coordsOffset, CodeCoordinate, "invalid container X coordinate in line: '%s'", line)
+	}
+	baseY, err := strconv.Atoi(strings.TrimSpace(coords[1]))
+	if err != nil {
+		return p.errAt(coordsOffset, CodeCoordinate, "invalid container Y coordinate in line: '%s'", line)
+	}
 
-		// Parse GridX coordinate (may be relative or absolute)
-		coordX, err := parseCoordinate(coords[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid X coordinate in line: '%s'", line)
-		}
+	frame.baseX = originX + baseX
+	frame.baseY = originY + baseY
 
-		// Parse GridY coordinate (may be relative or absolute)
-		coordY, err := parseCoordinate(coords[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid Y coordinate in line: '%s'", line)
+	// Set previous position to container base so relative coords inside work
+	p.previousGridX = frame.baseX
+	p.previousGridY = frame.baseY
+	return nil
+}
+
+// parseArrow handles "from -> to[: label] [| options]", including chains
+// ("a -> b -> c"), fan-out ("a -> b, c") and fan-in ("a, b -> c").
+// Steps can be joined by "->", "<-" (reversed), "<->" (both heads) or "--" (no head).
+// Endpoints may carry a port suffix ("a.bottom -> b.left") that fixes the attachment side.
+// Label and options are written once at the end of the line and apply to every
+// generated arrow.
+// Returns handled=false if the line is not a well-formed arrow (it is then parsed as a box).
+func (p *specParser) parseArrow(line string) (bool, error) {
+	var parts, connectors []string
+	var starts []int // Offset of each part in the line
+	prev := 0
+	for _, loc := range arrowConnector.FindAllStringIndex(line, -1) {
+		parts = append(parts, line[prev:loc[0]])
+		starts = append(starts, prev)
+		connectors = append(connectors, strings.TrimSpace(line[loc[0]:loc[1]]))
+		prev = loc[1]
+	}
+	parts = append(parts, line[prev:])
+	starts = append(starts, prev)
+
+	// A colon before the first connector means a box line whose label contains an arrow
+	if strings.Contains(parts[0], ":") {
+		return false, nil
+	}
+
+	// Split off the optional "| options" suffix (e.g., "HE | down", "HE | dashed-red"),
+	// ": label" (e.g., "B: approves") and waypoints (e.g., "B via 4,3 via 6,3")
+	// of the last part; options and waypoints are parsed once the endpoints are known
+	last := parts[len(parts)-1]
+	lastStart := starts[len(starts)-1]
+	optStr, optOffset := "", 0
+	if pipeIdx := strings.Index(last, "|"); pipeIdx >= 0 {
+		optStr, optOffset = trimOffset(last[pipeIdx+1:])
+		optOffset += lastStart + pipeIdx + 1
+		last = last[:pipeIdx]
+	}
+	var label string
+	if colonIdx := strings.Index(last, ":"); colonIdx >= 0 {
+		label = strings.TrimSpace(last[colonIdx+1:])
+		last = last[:colonIdx]
+	}
+	clauses, clausesOffset := "", 0
+	if loc := arrowVia.FindStringIndex(last); loc != nil {
+		clauses, clausesOffset = last[loc[0]:], lastStart+loc[0]
+		last = last[:loc[0]]
+	}
+	parts[len(parts)-1] = last
+
+	// Split every chain step into its ID list, locating each ID in the line.
+	// An empty ID means the line is not an arrow after all.
+	type endpoint struct {
+		local  string
+		side   string // Port suffix ("A.bottom"), "" if none
+		column int
+	}
+	steps := make([][]endpoint, len(parts))
+	for i, part := range parts {
+		offset := starts[i]
+		for _, field := range strings.Split(part, ",") {
+			id, idOffset := trimOffset(field)
+			if id == "" {
+				return false, nil
+			}
+			local, side := splitPort(id)
+			steps[i] = append(steps[i], endpoint{local: local, side: side, column: p.column(offset + idOffset)})
+			offset += len(field) + 1
 		}
+	}
 
-		// Parse GridWidth and GridHeight (absolute only)
-		var gridWidth float64
-		var gridHeight int
-		if len(coords) == 4 {
-			gridWidth, err = parseNumberOrFraction(coords[2])
-			if err != nil {
-				return nil, fmt.Errorf("invalid width in line: '%s'", line)
+	var options ArrowSpec
+	for _, code := range parseArrowOptions(optStr, &options, p.customColors) {
+		p.report(p.diagnosticAt(p.lineNo, p.column(optOffset), SeverityWarning, CodeStyle,
+			"unknown arrow option '%s' (ignored)", code))
+	}
+
+	var via []int
+	if clauses != "" {
+		gap, gapOffset := "", 0 // First text between or after waypoints
+		end := 0
+		for _, m := range arrowVia.FindAllStringSubmatchIndex(clauses, -1) {
+			if gap == "" {
+				gap, gapOffset = trimOffset(clauses[end:m[0]])
+				gapOffset += end
 			}
-			gridHeight, err = strconv.Atoi(strings.TrimSpace(coords[3]))
-			if err != nil {
-				return nil, fmt.Errorf("invalid height in line: '%s'", line)
+			end = m[1]
+			clause, offset := trimOffset(clauses[m[0]:m[1]])
+			x, errX := strconv.Atoi(clauses[m[2]:m[3]])
+			y, errY := strconv.Atoi(clauses[m[4]:m[5]])
+			if errX != nil || errY != nil || x < 1 || y < 1 {
+				return true, p.errAt(clausesOffset+m[0]+offset, CodeCoordinate,
+					"invalid waypoint '%s' (expected 'via x,y' with grid coordinates >= 1)", clause)
 			}
-			// Validate dimensions
-			if gridWidth < 0.2 {
-				idStr := id
-				if idStr == "" {
-					idStr = "(unlabeled)"
-				}
-				return nil, fmt.Errorf("box '%s': GridWidth must be >= 0.2, got %.1f", idStr, gridWidth)
+			if p.inContainer() {
+				// Like box coordinates, waypoints in a container are relative to its origin
+				x += p.currentContainer().baseX
+				y += p.currentContainer().baseY
 			}
-			if gridHeight < 1 {
-				idStr := id
-				if idStr == "" {
-					idStr = "(unlabeled)"
+			via = append(via, x, y)
+		}
+		if gap == "" {
+			gap, gapOffset = trimOffset(clauses[end:])
+			gapOffset += end
+		}
+		if gap != "" {
+			return true, p.errAt(clausesOffset+gapOffset, CodeCoordinate, "unexpected '%s' after waypoints", gap)
+		}
+	}
+
+	var firstErr error
+	for i := 0; i+1 < len(steps); i++ {
+		connector, reversed, _ := parseConnector(connectors[i])
+		for _, leftEnd := range steps[i] {
+			for _, rightEnd := range steps[i+1] {
+				fromEnd, toEnd := leftEnd, rightEnd
+				if reversed {
+					fromEnd, toEnd = rightEnd, leftEnd
 				}
-				return nil, fmt.Errorf("box '%s': GridHeight must be >= 1, got %d", idStr, gridHeight)
-			}
-		} else if len(coords) == 3 {
-			// Custom width, default height
-			gridWidth, err = parseNumberOrFraction(coords[2])
-			if err != nil {
-				return nil, fmt.Errorf("invalid width in line: '%s'", line)
-			}
-			gridHeight = 1 // Default height
-			// Validate width
-			if gridWidth < 0.2 {
-				idStr := id
-				if idStr == "" {
-					idStr = "(unlabeled)"
+				// Auto-scope arrow IDs inside containers; resolved against outer scopes in finish()
+				from := p.scoped(fromEnd.local)
+				to := p.scoped(toEnd.local)
+				// Manual arrows cannot reference internal IDs
+				if err := p.checkArrowEndpoints(from, to); err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
 				}
-				return nil, fmt.Errorf("box '%s': GridWidth must be >= 0.2, got %.1f", idStr, gridWidth)
+				arrow := options
+				arrow.FromID, arrow.ToID = from, to
+				arrow.FromSide, arrow.ToSide = fromEnd.side, toEnd.side
+				arrow.Label = label
+				arrow.Via = via
+				arrow.Connector = connector
+				arrow.Line = p.lineNo
+				p.addArrow(arrow, arrowSource{
+					line:       p.lineNo,
+					fromColumn: fromEnd.column,
+					toColumn:   toEnd.column,
+					scope:      p.scope(),
+					fromLocal:  fromEnd.local,
+					toLocal:    toEnd.local,
+				})
 			}
-		} else {
-			// Use defaults (len == 2)
-			gridWidth = 2.0
-			gridHeight = 1
 		}
+	}
+	return true, firstErr
+}
 
-		// Check if first box tries to use relative coordinates
-		// Inside a container, previousGridX/Y are set to container base, so relative coords are OK
-		if previousBoxID == "" && !inContainer && (coordX.IsRelative || coordY.IsRelative) {
-			idStr := id
-			if idStr == "" {
-				idStr = "(unlabeled)"
-			}
-			return nil, fmt.Errorf("first box (label '%s') cannot use relative coordinates", idStr)
+// checkArrowEndpoints rejects arrows referencing internal IDs of unlabeled boxes
+func (p *specParser) checkArrowEndpoints(from, to string) error {
+	for _, id := range []string{from, to} {
+		if strings.HasPrefix(id, "_box_") || strings.Contains(id, "._box_") {
+			return p.errAt(0, CodeArrowReference, "arrow '%s -> %s' references box without explicit label (internal ID: %s)", from, to, id)
 		}
+	}
+	return nil
+}
 
-		// Validate touch-left requirements
-		if touchLeft {
-			idStr := id
-			if idStr == "" {
-				idStr = "(unlabeled)"
-			}
-			// Y coordinate must be 0 (relative, same row)
-			if !coordY.IsRelative || coordY.Value != 0 {
-				return nil, fmt.Errorf("box '%s': touch-left prefix '|' requires Y coordinate to be 0 (same row as previous box)", idStr)
-			}
-			// X coordinate must be relative with "+" prefix (positive relative)
-			if !coordX.IsRelative || coordX.Value <= 0 {
-				return nil, fmt.Errorf("box '%s': touch-left prefix '|' requires X coordinate to be relative with '+' prefix (e.g. '+2'), got relative=%v value=%d", idStr, coordX.IsRelative, coordX.Value)
-			}
-		}
+// addArrow appends an arrow together with its source position
+func (p *specParser) addArrow(arrow ArrowSpec, source arrowSource) {
+	p.spec.Arrows = append(p.spec.Arrows, arrow)
+	p.arrowSources = append(p.arrowSources, source)
+}
 
-		// Resolve coordinates
-		var gridX, gridY int
-		if coordX.IsRelative {
-			gridX = previousGridX + coordX.Value
-		} else if inContainer {
-			gridX = containerBaseX + coordX.Value
-		} else {
-			gridX = coordX.Value
-		}
+// parseGroupDef handles "@GroupName: Label"
+func (p *specParser) parseGroupDef(line string) {
+	groupLine := line[1:] // Strip "@"
+	groupParts := strings.SplitN(groupLine, ":", 2)
+	groupName := strings.TrimSpace(groupParts[0])
+	groupLabel := groupName // Default label is the group name
+	if len(groupParts) == 2 {
+		groupLabel = strings.TrimSpace(groupParts[1])
+	}
+	p.groupDefs[groupName] = groupLabel
+}
 
-		if coordY.IsRelative {
-			gridY = previousGridY + coordY.Value
-		} else if inContainer {
-			gridY = containerBaseY + coordY.Value
-		} else {
-			gridY = coordY.Value
+// parseBox handles "dev: 1,2: Sprint Planning" or ">3,2: Daily Standup" (no ID)
+func (p *specParser) parseBox(line string) error {
+	parts := strings.SplitN(line, ":", 3)
+
+	var id string
+	var coordsAndLabelParts []string
+	idOffset, coordsOffset := 0, 0 // Offsets of the ID and coordinates parts in the line
+
+	if len(parts) == 3 {
+		// Format: "ID: coords: label"
+		id, idOffset = trimOffset(parts[0])
+		// Validate ID: alphanumeric + underscore + hyphen only
+		if id == "" {
+			return p.errAt(0, CodeInvalidID, "invalid box definition: empty ID in line '%s'", line)
+		}
+		if !validBoxID(id) {
+			return p.errAt(idOffset, CodeInvalidID, "invalid ID '%s': must contain only alphanumeric characters, underscore, or hyphen", id)
 		}
+		coordsAndLabelParts = parts[1:3]
+		coordsOffset = len(parts[0]) + 1
+	} else if len(parts) == 2 {
+		// Format: "coords: label" (no ID)
+		id = "" // Will be assigned internal ID if needed
+		coordsAndLabelParts = parts
+	} else {
+		return p.errAt(0, CodeBoxSyntax, "invalid box definition: '%s'", line)
+	}
+
+	// Generate internal ID for boxes without explicit IDs
+	if id == "" {
+		id = fmt.Sprintf("_box_%d", p.internalIDCounter)
+		p.internalIDCounter++
+	}
+
+	// Scope box IDs inside containers: "X" → "G.X", "X" in nested "A.B" → "A.B.X"
+	id = p.scoped(id)
+	if err := p.defineID(id, idOffset); err != nil {
+		return err
+	}
+
+	// Any error below leaves the box undefined; remember its ID so arrows
+	// referencing it are not reported a second time
+	err := p.parseBoxBody(id, line, coordsAndLabelParts, coordsOffset)
+	if err != nil {
+		p.failedIDs[id] = true
+	}
+	return err
+}
+
+// defineID records the definition of a box or container ID on the current line.
+// Returns an error if the ID is already taken, positioned at offset.
+func (p *specParser) defineID(id string, offset int) error {
+	if line, ok := p.definedIDs[id]; ok {
+		return p.errAt(offset, CodeInvalidID, "duplicate ID '%s' (first defined on line %d)", id, line)
+	}
+	p.definedIDs[id] = p.lineNo
+	return nil
+}
 
-		// Validate that resulting coordinates are positive
-		if gridX < 1 {
-			idStr := id
-			if idStr == "" {
-				idStr = "(unlabeled)"
+// parseBoxBody parses the coordinates, label and style of a box with a resolved ID.
+// coordsOffset is the offset of the coordinates part in the line.
+func (p *specParser) parseBoxBody(id, line string, coordsAndLabelParts []string, coordsOffset int) error {
+	// Check for auto-arrow prefix ">" or touch-left prefix "|"
+	coordsStr, offset := trimOffset(coordsAndLabelParts[0])
+	coordsColumn := p.column(coordsOffset + offset)
+	autoArrow := strings.HasPrefix(coordsStr, ">")
+	touchLeft := strings.HasPrefix(coordsStr, "|")
+
+	// coordErr positions a diagnostic at the idx-th comma-separated coordinate
+	coordErr := func(idx int, code, format string, args ...any) error {
+		offset := 0
+		for j, c := range strings.Split(coordsStr, ",") {
+			if j == idx {
+				offset += len(c) - len(strings.TrimLeft(c, " \t"))
+				break
 			}
-			return nil, fmt.Errorf("box '%s': relative GridX coordinate resulted in invalid value %d (must be >= 1)", idStr, gridX)
+			offset += len(c) + 1
 		}
-		if gridY < 1 {
-			idStr := id
-			if idStr == "" {
-				idStr = "(unlabeled)"
-			}
-			return nil, fmt.Errorf("box '%s': relative GridY coordinate resulted in invalid value %d (must be >= 1)", idStr, gridY)
-		}
-		// Parse label and optional style attributes
-		labelAndStyle := strings.TrimSpace(coordsAndLabelParts[1])
-
-		// Extract @GroupName suffix (e.g., "Stefanie, p @Team" -> group="Team")
-		var groupName string
-		if atIdx := strings.LastIndex(labelAndStyle, " @"); atIdx >= 0 {
-			groupName = strings.TrimSpace(labelAndStyle[atIdx+2:])
-			labelAndStyle = strings.TrimSpace(labelAndStyle[:atIdx])
-		}
-
-		labelParts := strings.SplitN(labelAndStyle, ",", 2)
-		if len(labelParts) == 0 {
-			return nil, fmt.Errorf("invalid label format: %s", labelAndStyle)
-		}
-		label := strings.TrimSpace(labelParts[0])
-
-		// Parse optional styles (e.g., "rb-g" -> red border + gray background)
-		var styleStr string
-		if len(labelParts) == 2 {
-			styleStr = strings.TrimSpace(labelParts[1])
-		}
-		parsedStyles := parseBoxStyles(styleStr, customColors)
-
-		backgroundColor := parsedStyles.BackgroundColor
-		borderColor := parsedStyles.BorderColor
-		borderWidth := parsedStyles.BorderWidth
-		fontSize := parsedStyles.FontSize
-		textColor := parsedStyles.TextColor
-
-		spec.Boxes = append(spec.Boxes, BoxSpec{
-			ID:          id,
-			GridX:       gridX,
-			GridY:       gridY,
-			GridWidth:   gridWidth,
-			GridHeight:  gridHeight,
-			Label:       label,
-			Color:       backgroundColor,
-			BorderColor: borderColor,
-			BorderWidth: borderWidth,
-			FontSize:    fontSize,
-			TextColor:   textColor,
-			TouchLeft:   touchLeft,
-			Group:       groupName,
-		})
+		return p.diagnosticAt(p.lineNo, coordsColumn+offset, SeverityError, code, format, args...)
+	}
 
-		// Track box IDs inside the current container
-		if inContainer {
-			containerBoxIDs = append(containerBoxIDs, id)
+	if autoArrow {
+		// Check if this is the first box
+		if p.previousBoxID == "" {
+			return coordErr(0, CodePrefix, "first box (label '%s') cannot have auto-arrow prefix '>'", id)
 		}
+		// Strip the ">" prefix
+		coordsStr = strings.TrimPrefix(coordsStr, ">")
+		coordsColumn++
+	} else if touchLeft {
+		// Check if this is the first box
+		if p.previousBoxID == "" {
+			return coordErr(0, CodePrefix, "first box (label '%s') cannot have touch-left prefix '|'", id)
+		}
+		// Strip the "|" prefix
+		coordsStr = strings.TrimPrefix(coordsStr, "|")
+		coordsColumn++
+	}
+
+	coords := strings.Split(coordsStr, ",")
+	if len(coords) != 2 && len(coords) != 3 && len(coords) != 4 {
+		return coordErr(0, CodeCoordinate, "invalid coordinate definition: '%s'", line)
+	}
 
-		// Track box-to-group mapping
-		if groupName != "" {
-			boxGroups[id] = groupName
+	// Parse GridX coordinate (may be relative or absolute)
+	coordX, err := parseCoordinate(coords[0])
+	if err != nil {
+		return coordErr(0, CodeCoordinate, "invalid X coordinate in line: '%s'", line)
+	}
+
+	// Parse GridY coordinate (may be relative or absolute)
+	coordY, err := parseCoordinate(coords[1])
+	if err != nil {
+		return coordErr(1, CodeCoordinate, "invalid Y coordinate in line: '%s'", line)
+	}
+
+	// Parse GridWidth and GridHeight (absolute only)
+	var gridWidth float64
+	var gridHeight int
+	if len(coords) == 4 {
+		gridWidth, err = parseNumberOrFraction(coords[2])
+		if err != nil {
+			return coordErr(2, CodeSize, "invalid width in line: '%s'", line)
+		}
+		gridHeight, err = strconv.Atoi(strings.TrimSpace(coords[3]))
+		if err != nil {
+			return coordErr(3, CodeSize, "invalid height in line: '%s'", line)
+		}
+		// Validate dimensions
+		if gridWidth < 0.2 {
+			return coordErr(2, CodeSize, "box '%s': GridWidth must be >= 0.2, got %.1f", id, gridWidth)
+		}
+		if gridHeight < 1 {
+			return coordErr(3, CodeSize, "box '%s': GridHeight must be >= 1, got %d", id, gridHeight)
 		}
+	} else if len(coords) == 3 {
+		// Custom width, default height
+		gridWidth, err = parseNumberOrFraction(coords[2])
+		if err != nil {
+			return coordErr(2, CodeSize, "invalid width in line: '%s'", line)
+		}
+		gridHeight = 1 // Default height
+		// Validate width
+		if gridWidth < 0.2 {
+			return coordErr(2, CodeSize, "box '%s': GridWidth must be >= 0.2, got %.1f", id, gridWidth)
+		}
+	} else {
+		// Use defaults (len == 2)
+		gridWidth = 2.0
+		gridHeight = 1
+	}
 
-		// Create auto-arrow if prefix was present
-		if autoArrow {
-			spec.Arrows = append(spec.Arrows, ArrowSpec{
-				FromID: previousBoxID,
-				ToID:   id,
-			})
+	// Check if first box tries to use relative coordinates
+	// Inside a container, previousGridX/Y are set to container base, so relative coords are OK
+	if p.previousBoxID == "" && !p.inContainer() && (coordX.IsRelative || coordY.IsRelative) {
+		return coordErr(0, CodeCoordinate, "first box (label '%s') cannot use relative coordinates", id)
+	}
+
+	// Validate touch-left requirements
+	if touchLeft {
+		// Y coordinate must be 0 (relative, same row)
+		if !coordY.IsRelative || coordY.Value != 0 {
+			return coordErr(1, CodePrefix, "box '%s': touch-left prefix '|' requires Y coordinate to be 0 (same row as previous box)", id)
+		}
+		// X coordinate must be relative with "+" prefix (positive relative)
+		if !coordX.IsRelative || coordX.Value <= 0 {
+			return coordErr(0, CodePrefix, "box '%s': touch-left prefix '|' requires X coordinate to be relative with '+' prefix (e.g. '+2'), got relative=%v value=%d", id, coordX.IsRelative, coordX.Value)
 		}
+	}
+
+	// Resolve coordinates
+	var gridX, gridY int
+	if coordX.IsRelative {
+		gridX = p.previousGridX + coordX.Value
+	} else if p.inContainer() {
+		gridX = p.currentContainer().baseX + coordX.Value
+	} else {
+		gridX = coordX.Value
+	}
+
+	if coordY.IsRelative {
+		gridY = p.previousGridY + coordY.Value
+	} else if p.inContainer() {
+		gridY = p.currentContainer().baseY + coordY.Value
+	} else {
+		gridY = coordY.Value
+	}
+
+	// Validate that resulting coordinates are positive
+	if gridX < 1 {
+		return coordErr(0, CodeCoordinate, "box '%s': relative GridX coordinate resulted in invalid value %d (must be >= 1)", id, gridX)
+	}
+	if gridY < 1 {
+		return coordErr(1, CodeCoordinate, "box '%s': relative GridY coordinate resulted in invalid value %d (must be >= 1)", id, gridY)
+	}
+	// Parse label and optional style attributes
+	labelAndStyle, labelOffset := trimOffset(coordsAndLabelParts[1])
+	labelOffset += coordsOffset + len(coordsAndLabelParts[0]) + 1
+
+	// Extract @GroupName suffix (e.g., "Stefanie, p @Team" -> group="Team")
+	var groupName string
+	if atIdx := strings.LastIndex(labelAndStyle, " @"); atIdx >= 0 {
+		groupName = strings.TrimSpace(labelAndStyle[atIdx+2:])
+		labelAndStyle = strings.TrimSpace(labelAndStyle[:atIdx])
+	}
+
+	labelParts := strings.SplitN(labelAndStyle, ",", 2)
+	label := strings.TrimSpace(labelParts[0])
+
+	// Parse optional styles (e.g., "rb-g" -> red border + gray background)
+	var styleStr string
+	styleOffset := 0
+	if len(labelParts) == 2 {
+		styleStr, styleOffset = trimOffset(labelParts[1])
+		styleOffset += labelOffset + len(labelParts[0]) + 1
+	}
+	// Unknown codes are only a warning: commas are common in labels
+	for _, code := range unknownStyleCodes(styleStr, p.customColors) {
+		p.report(p.diagnosticAt(p.lineNo, p.column(styleOffset), SeverityWarning, CodeStyle,
+			"box '%s': unknown style code '%s' (ignored)", id, code))
+	}
+	parsedStyles := parseBoxStyles(styleStr, p.customColors)
+
+	backgroundColor := parsedStyles.BackgroundColor
+	borderColor := parsedStyles.BorderColor
+	borderWidth := parsedStyles.BorderWidth
+	fontSize := parsedStyles.FontSize
+	textColor := parsedStyles.TextColor
+
+	p.spec.Boxes = append(p.spec.Boxes, BoxSpec{
+		ID:          id,
+		GridX:       gridX,
+		GridY:       gridY,
+		GridWidth:   gridWidth,
+		GridHeight:  gridHeight,
+		Label:       label,
+		Color:       backgroundColor,
+		BorderColor: borderColor,
+		BorderWidth: borderWidth,
+		FontSize:    fontSize,
+		TextColor:   textColor,
+		TouchLeft:   touchLeft,
+		Group:       groupName,
+	})
+
+	// Track box IDs inside the current container
+	if p.inContainer() {
+		frame := p.currentContainer()
+		frame.boxIDs = append(frame.boxIDs, id)
+	}
 
-		// Update previous box tracking
-		previousBoxID = id
-		previousGridX = gridX
-		previousGridY = gridY
+	// Track box-to-group mapping
+	if groupName != "" {
+		p.boxGroups[id] = groupName
 	}
 
-	// Check for unclosed container
-	if inContainer {
-		return nil, fmt.Errorf("unclosed container '%s'", containerID)
+	// Create auto-arrow if prefix was present
+	if autoArrow {
+		p.addArrow(ArrowSpec{
+			FromID: p.previousBoxID,
+			ToID:   id,
+			Line:   p.lineNo,
+		}, arrowSource{line: p.lineNo, fromColumn: coordsColumn - 1, toColumn: coordsColumn - 1})
+	}
+
+	// Update previous box tracking
+	p.previousBoxID = id
+	p.previousGridX = gridX
+	p.previousGridY = gridY
+	return nil
+}
+
+// finish runs the checks that need the whole file and builds groups.
+// Returns true if the error limit was reached.
+func (p *specParser) finish() bool {
+	// Check for unclosed containers, innermost first
+	for i := len(p.containers) - 1; i >= 0; i-- {
+		frame := p.containers[i]
+		diag := p.diagnosticAt(frame.line, frame.column, SeverityError, CodeContainer, "unclosed container '%s'", frame.id)
+		if p.report(diag) {
+			return true
+		}
 	}
 
 	// Validate that all arrows reference existing boxes
 	validBoxIDs := make(map[string]bool)
-	for _, box := range spec.Boxes {
+	for _, box := range p.spec.Boxes {
 		// All boxes now have IDs (either explicit or internal)
 		validBoxIDs[box.ID] = true
 	}
 
-	for _, arrow := range spec.Arrows {
-		if !validBoxIDs[arrow.FromID] {
-			return nil, fmt.Errorf("arrow '%s -> %s' references non-existent box label '%s'", arrow.FromID, arrow.ToID, arrow.FromID)
+	for i := range p.spec.Arrows {
+		arrow := &p.spec.Arrows[i]
+		source := p.arrowSources[i]
+		// Resolve IDs written inside containers against enclosing scopes
+		if source.scope != "" {
+			arrow.FromID = resolveScopedID(source.scope, source.fromLocal, validBoxIDs)
+			arrow.ToID = resolveScopedID(source.scope, source.toLocal, validBoxIDs)
+		}
+		// A port suffix that is really part of a box ID ("G.top") belongs to the ID
+		if arrow.FromSide != "" && !validBoxIDs[arrow.FromID] {
+			if id := resolveScopedID(source.scope, source.fromLocal+"."+arrow.FromSide, validBoxIDs); validBoxIDs[id] {
+				arrow.FromID, arrow.FromSide = id, ""
+			}
 		}
-		if !validBoxIDs[arrow.ToID] {
-			return nil, fmt.Errorf("arrow '%s -> %s' references non-existent box label '%s'", arrow.FromID, arrow.ToID, arrow.ToID)
+		if arrow.ToSide != "" && !validBoxIDs[arrow.ToID] {
+			if id := resolveScopedID(source.scope, source.toLocal+"."+arrow.ToSide, validBoxIDs); validBoxIDs[id] {
+				arrow.ToID, arrow.ToSide = id, ""
+			}
+		}
+		if !validBoxIDs[arrow.FromID] && !p.failedIDs[arrow.FromID] {
+			diag := p.diagnosticAt(source.line, source.fromColumn, SeverityError, CodeArrowReference,
+				"arrow '%s -> %s' references non-existent box label '%s'", arrow.FromID, arrow.ToID, arrow.FromID)
+			if p.report(diag) {
+				return true
+			}
+		}
+		if !validBoxIDs[arrow.ToID] && !p.failedIDs[arrow.ToID] {
+			diag := p.diagnosticAt(source.line, source.toColumn, SeverityError, CodeArrowReference,
+				"arrow '%s -> %s' references non-existent box label '%s'", arrow.FromID, arrow.ToID, arrow.ToID)
+			if p.report(diag) {
+				return true
+			}
 		}
 	}
 
 	// Build groups from box assignments and group definitions
 	groupBoxIDs := make(map[string][]string) // group name -> list of box IDs
-	var groupOrder []string                  // preserve first-seen order
-	for boxID, gName := range boxGroups {
+	var groupOrder []string                  // preserve first-seen order, in box order
+	for _, box := range p.spec.Boxes {
+		gName, ok := p.boxGroups[box.ID]
+		if !ok {
+			continue
+		}
 		if _, seen := groupBoxIDs[gName]; !seen {
 			groupOrder = append(groupOrder, gName)
 		}
-		groupBoxIDs[gName] = append(groupBoxIDs[gName], boxID)
+		groupBoxIDs[gName] = append(groupBoxIDs[gName], box.ID)
 	}
 
 	for _, gName := range groupOrder {
 		label := gName // default label is the group name
-		if defLabel, ok := groupDefs[gName]; ok {
+		if defLabel, ok := p.groupDefs[gName]; ok {
 			label = defLabel
 		}
-		spec.Groups = append(spec.Groups, GroupDef{
+		p.spec.Groups = append(p.spec.Groups, GroupDef{
 			Name:   gName,
 			Label:  label,
 			BoxIDs: groupBoxIDs[gName],
 		})
 	}
 
-	return spec, nil
+	return false
+}
+
+// validBoxID reports whether id is a non-empty box ID of alphanumeric
+// characters, underscores and hyphens
+func validBoxID(id string) bool {
+	return id != "" && strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") == ""
+}
+
+// resolveScopedID looks up a local ID written in a container scope, trying the
+// innermost scope first and walking outwards to the top level ("A.B" → "A" → "").
+// Returns the innermost candidate if no scope defines the ID, for error reporting.
+func resolveScopedID(scope, id string, validBoxIDs map[string]bool) string {
+	for s := scope; ; {
+		if candidate := scopedID(s, id); validBoxIDs[candidate] {
+			return candidate
+		}
+		if s == "" {
+			break
+		}
+		if dot := strings.LastIndex(s, "."); dot >= 0 {
+			s = s[:dot]
+		} else {
+			s = ""
+		}
+	}
+	return scopedID(scope, id)
 }
@@ -1,6 +1,8 @@
-package main
+package control
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -129,7 +131,7 @@ func TestParseDiagramSpec_ValidInput(t *testing.T) {
 2 -> 3
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -170,7 +172,7 @@ func TestParseDiagramSpec_ValidInput(t *testing.T) {
 func TestParseDiagramSpec_EmptyInput(t *testing.T) {
 	text := ""
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -190,7 +192,7 @@ func TestParseDiagramSpec_OnlyBoxes(t *testing.T) {
 2: 3,4: Box Two
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -210,13 +212,16 @@ func TestParseDiagramSpec_OnlyArrows(t *testing.T) {
 2 -> 3
 `
 
-	_, err := ParseDiagramSpec(text, nil)
+	_, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatal("Expected error for arrows without boxes, got nil")
 	}
 
-	// Should fail on first arrow that references non-existent box
-	expected := "arrow '1 -> 2' references non-existent box label '1'"
+	// Every missing reference is reported in one pass
+	expected := "2:1: arrow '1 -> 2' references non-existent box label '1'\n" +
+		"2:6: arrow '1 -> 2' references non-existent box label '2'\n" +
+		"3:1: arrow '2 -> 3' references non-existent box label '2'\n" +
+		"3:6: arrow '2 -> 3' references non-existent box label '3'"
 	if err.Error() != expected {
 		t.Errorf("Error message = %q, want %q", err.Error(), expected)
 	}
@@ -230,7 +235,7 @@ invalid line
 1 -> 2
 `
 
-	_, err := ParseDiagramSpec(text, nil)
+	_, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatal("Expected error for malformed box, got nil")
 	}
@@ -246,7 +251,7 @@ invalid arrow
 2 -> 3
 `
 
-	_, err := ParseDiagramSpec(text, nil)
+	_, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatal("Expected error for malformed arrow, got nil")
 	}
@@ -263,7 +268,7 @@ func TestParseDiagramSpec_WithWhitespace(t *testing.T) {
 
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -296,7 +301,7 @@ func TestParseDiagramSpec_ComplexDiagram(t *testing.T) {
 5 -> 4
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -316,7 +321,7 @@ func TestParseDiagramSpec_AutoArrow_SingleChain(t *testing.T) {
 2: >3,2: Daily Standup
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -354,7 +359,7 @@ func TestParseDiagramSpec_AutoArrow_MultipleChain(t *testing.T) {
 4: >7,4: Sprint Review
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -389,7 +394,7 @@ func TestParseDiagramSpec_AutoArrow_FirstBoxError(t *testing.T) {
 1: >1,2: Sprint Planning
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for first box with auto-arrow prefix, got nil")
 	}
@@ -415,7 +420,7 @@ func TestParseDiagramSpec_AutoArrow_MixedWithManual(t *testing.T) {
 3 -> 4
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -443,7 +448,7 @@ func TestParseDiagramSpec_AutoArrow_AllowDuplicates(t *testing.T) {
 1 -> 2
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -483,7 +488,7 @@ func TestParseDiagramSpec_RelativeCoordinates_RelativeX(t *testing.T) {
 2: +2,4: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -508,7 +513,7 @@ func TestParseDiagramSpec_RelativeCoordinates_RelativeY(t *testing.T) {
 2: 5,+1: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -529,7 +534,7 @@ func TestParseDiagramSpec_RelativeCoordinates_BothRelative(t *testing.T) {
 3: +2,+1: Box C
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -555,7 +560,7 @@ func TestParseDiagramSpec_RelativeCoordinates_Negative(t *testing.T) {
 2: +1,-1: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -571,7 +576,7 @@ func TestParseDiagramSpec_RelativeCoordinates_FirstBoxError(t *testing.T) {
 1: +2,3: Box A
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for first box with relative coordinates, got nil")
 	}
@@ -591,7 +596,7 @@ func TestParseDiagramSpec_RelativeCoordinates_NegativeResultError(t *testing.T)
 2: -5,1: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for negative GridX result (2-5=-3), got nil")
 	}
@@ -611,7 +616,7 @@ func TestParseDiagramSpec_RelativeCoordinates_WithAutoArrow(t *testing.T) {
 2: >+2,+1: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -643,7 +648,7 @@ func TestParseDiagramSpec_RelativeCoordinates_MixedAbsoluteRelative(t *testing.T
 4: +1,+1: Box D
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -755,7 +760,7 @@ func TestParseDiagramSpec_ShorthandZero_XOnly(t *testing.T) {
 2: +2,0: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -776,7 +781,7 @@ func TestParseDiagramSpec_ShorthandZero_YOnly(t *testing.T) {
 2: 0,+1: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -793,7 +798,7 @@ func TestParseDiagramSpec_ShorthandZero_Both(t *testing.T) {
 2: 0,0: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -810,7 +815,7 @@ func TestParseDiagramSpec_ShorthandZero_WithAutoArrow(t *testing.T) {
 2: >+2,0: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -842,7 +847,7 @@ func TestParseDiagramSpec_ShorthandZero_MixedWithRelative(t *testing.T) {
 4: +1,+1: Box D
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -876,7 +881,7 @@ func TestParseDiagramSpec_ShorthandZero_FirstBoxError(t *testing.T) {
 1: 0,2: Box A
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for first box with relative coordinate (0), got nil")
 	}
@@ -897,7 +902,7 @@ func TestParseDiagramSpec_ShorthandZero_BackwardCompatibility(t *testing.T) {
 2: +2,+0: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -916,7 +921,7 @@ func TestParseDiagramSpec_Comments_Basic(t *testing.T) {
 2: 3,2: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -938,7 +943,7 @@ func TestParseDiagramSpec_Comments_WithWhitespace(t *testing.T) {
 1: 1,1: Box A
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -957,7 +962,7 @@ func TestParseDiagramSpec_Comments_InArrowSection(t *testing.T) {
 # Another comment
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -976,7 +981,7 @@ func TestParseDiagramSpec_Comments_WithAutoArrow(t *testing.T) {
 # End
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1001,7 +1006,7 @@ func TestParseDiagramSpec_Comments_MultipleConsecutive(t *testing.T) {
 2: 3,2: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1020,7 +1025,7 @@ func TestParseDiagramSpec_Comments_JustHashSymbol(t *testing.T) {
 #
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1040,7 +1045,7 @@ func TestParseDiagramSpec_Comments_WithRelativeCoordinates(t *testing.T) {
 3: 0,+1: Box C
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1064,7 +1069,7 @@ func TestParseDiagramSpec_VariableBoxSize_FourValues(t *testing.T) {
 1: 1,1,3,2: Large Box
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1093,7 +1098,7 @@ func TestParseDiagramSpec_VariableBoxSize_DefaultValues(t *testing.T) {
 1: 1,1: Default Size Box
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1117,7 +1122,7 @@ func TestParseDiagramSpec_VariableBoxSize_WithRelativePosition(t *testing.T) {
 2: +2,+1,3,2: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1145,7 +1150,7 @@ func TestParseDiagramSpec_VariableBoxSize_InvalidWidth(t *testing.T) {
 1: 1,1,0,1: Invalid Width
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for GridWidth=0, got nil")
 	}
@@ -1164,7 +1169,7 @@ func TestParseDiagramSpec_VariableBoxSize_InvalidHeight(t *testing.T) {
 1: 1,1,2,-1: Invalid Height
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for GridHeight=-1, got nil")
 	}
@@ -1184,7 +1189,7 @@ func TestParseDiagramSpec_VariableBoxSize_WithAutoArrow(t *testing.T) {
 2: >3,1,3,2: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1212,7 +1217,7 @@ func TestParseDiagramSpec_VariableBoxSize_Mixed(t *testing.T) {
 4: 4,3,4,3: Both Custom
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1247,7 +1252,7 @@ func TestParseDiagramSpec_ThreeValueFormat_Basic(t *testing.T) {
 1: 1,1,3: Wide Box
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1277,7 +1282,7 @@ func TestParseDiagramSpec_ThreeValueFormat_WithRelativeCoordinates(t *testing.T)
 2: +2,+1,3: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1306,7 +1311,7 @@ func TestParseDiagramSpec_ThreeValueFormat_WithAutoArrow(t *testing.T) {
 2: >+2,0,4: Box B
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1342,7 +1347,7 @@ func TestParseDiagramSpec_ThreeValueFormat_Mixed(t *testing.T) {
 4: 7,1,4,3: Four values (4,3)
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1377,7 +1382,7 @@ func TestParseDiagramSpec_ThreeValueFormat_InvalidWidth(t *testing.T) {
 1: 1,1,0: Invalid Width
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for GridWidth=0, got nil")
 	}
@@ -1396,7 +1401,7 @@ func TestParseDiagramSpec_ThreeValueFormat_NegativeWidth(t *testing.T) {
 1: 1,1,-2: Negative Width
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err == nil {
 		t.Fatalf("Expected error for negative GridWidth, got nil")
 	}
@@ -1418,7 +1423,7 @@ func TestParseDiagramSpec_ThreeValueFormat_AllSizes(t *testing.T) {
 4: 10,1,5: Width 5
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1443,7 +1448,7 @@ func TestParseDiagramSpec_Styles_RedBorder(t *testing.T) {
 1: 1,1: Task, rb
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1472,7 +1477,7 @@ func TestParseDiagramSpec_Styles_GrayBackground(t *testing.T) {
 1: 1,1: Task, g
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1501,7 +1506,7 @@ func TestParseDiagramSpec_Styles_PurpleBackground(t *testing.T) {
 1: 1,1: Task, p
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1524,7 +1529,7 @@ func TestParseDiagramSpec_Styles_Combined(t *testing.T) {
 1: 1,1: Task, rb-g
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1553,7 +1558,7 @@ func TestParseDiagramSpec_Styles_NoStyle(t *testing.T) {
 1: 1,1: Task
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1586,7 +1591,7 @@ func TestParseDiagramSpec_Styles_MultipleBoxes(t *testing.T) {
 5: 5,1: Task E
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1626,7 +1631,7 @@ func TestParseDiagramSpec_Styles_LightPurple(t *testing.T) {
 1: 1,1: Task, lp
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1649,7 +1654,7 @@ func TestParseDiagramSpec_Styles_LightPurpleCombined(t *testing.T) {
 1: 1,1: Task, lp-rb
 `
 
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -1671,7 +1676,7 @@ func TestTouchLeftConnector_Valid(t *testing.T) {
 	input := `1: 1,1: Box 1
 2: |+2,0: Box 2`
 
-	spec, err := ParseDiagramSpec(input, nil)
+	spec, err := parseDiagramSpec(input, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -1703,7 +1708,7 @@ func TestTouchLeftConnector_InvalidY(t *testing.T) {
 	input := `1: 1,1: Box 1
 2: |+2,+1: Box 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for Y coordinate != 0, got nil")
 	}
@@ -1718,7 +1723,7 @@ func TestTouchLeftConnector_InvalidY_Negative(t *testing.T) {
 	input := `1: 1,2: Box 1
 2: |+2,-1: Box 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for Y coordinate != 0, got nil")
 	}
@@ -1733,7 +1738,7 @@ func TestTouchLeftConnector_InvalidX_Absolute(t *testing.T) {
 	input := `1: 1,1: Box 1
 2: |2,0: Box 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for absolute X coordinate, got nil")
 	}
@@ -1748,7 +1753,7 @@ func TestTouchLeftConnector_InvalidX_Zero(t *testing.T) {
 	input := `1: 1,1: Box 1
 2: |0,0: Box 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for X coordinate '0', got nil")
 	}
@@ -1763,7 +1768,7 @@ func TestTouchLeftConnector_InvalidX_Negative(t *testing.T) {
 	input := `1: 1,1: Box 1
 2: |-2,0: Box 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for negative X coordinate, got nil")
 	}
@@ -1777,7 +1782,7 @@ func TestTouchLeftConnector_InvalidX_Negative(t *testing.T) {
 func TestTouchLeftConnector_FirstBox(t *testing.T) {
 	input := `1: |1,1: Box 1`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for first box with touch-left prefix, got nil")
 	}
@@ -1793,7 +1798,7 @@ func TestTouchLeftConnector_MultipleBoxes(t *testing.T) {
 2: |+2,0: Box 2
 3: |+2,0: Box 3`
 
-	spec, err := ParseDiagramSpec(input, nil)
+	spec, err := parseDiagramSpec(input, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -1824,12 +1829,12 @@ func TestArrowValidation_InvalidFromID(t *testing.T) {
 2: 2,1: Box 2
 3 -> 2`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for arrow with non-existent FromID, got nil")
 	}
 
-	expected := "arrow '3 -> 2' references non-existent box label '3'"
+	expected := "3:1: arrow '3 -> 2' references non-existent box label '3'"
 	if err.Error() != expected {
 		t.Errorf("Error message = %q, want %q", err.Error(), expected)
 	}
@@ -1840,12 +1845,12 @@ func TestArrowValidation_InvalidToID(t *testing.T) {
 2: 2,1: Box 2
 1 -> 5`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for arrow with non-existent ToID, got nil")
 	}
 
-	expected := "arrow '1 -> 5' references non-existent box label '5'"
+	expected := "3:6: arrow '1 -> 5' references non-existent box label '5'"
 	if err.Error() != expected {
 		t.Errorf("Error message = %q, want %q", err.Error(), expected)
 	}
@@ -1856,13 +1861,14 @@ func TestArrowValidation_BothInvalid(t *testing.T) {
 2: 2,1: Box 2
 10 -> 20`
 
-	_, err := ParseDiagramSpec(input, nil)
+	_, err := parseDiagramSpec(input, nil)
 	if err == nil {
 		t.Fatal("Expected error for arrow with both IDs non-existent, got nil")
 	}
 
-	// Should fail on FromID first
-	expected := "arrow '10 -> 20' references non-existent box label '10'"
+	// Both ends are reported
+	expected := "3:1: arrow '10 -> 20' references non-existent box label '10'\n" +
+		"3:7: arrow '10 -> 20' references non-existent box label '20'"
 	if err.Error() != expected {
 		t.Errorf("Error message = %q, want %q", err.Error(), expected)
 	}
@@ -1876,7 +1882,7 @@ func TestArrowValidation_ValidReferences(t *testing.T) {
 2 -> 3
 1 -> 3`
 
-	spec, err := ParseDiagramSpec(input, nil)
+	spec, err := parseDiagramSpec(input, nil)
 	if err != nil {
 		t.Fatalf("Expected no error for valid arrows, got: %v", err)
 	}
@@ -1891,7 +1897,7 @@ func TestArrowValidation_AutoArrowValid(t *testing.T) {
 2: >2,1: Box 2
 3: >3,1: Box 3`
 
-	spec, err := ParseDiagramSpec(input, nil)
+	spec, err := parseDiagramSpec(input, nil)
 	if err != nil {
 		t.Fatalf("Expected no error for valid auto-arrows, got: %v", err)
 	}
@@ -1915,7 +1921,7 @@ func TestArrowValidation_MixedManualAndAutoArrows(t *testing.T) {
 3: 3,1: Box 3
 1 -> 3`
 
-	spec, err := ParseDiagramSpec(input, nil)
+	spec, err := parseDiagramSpec(input, nil)
 	if err != nil {
 		t.Fatalf("Expected no error for mixed arrows, got: %v", err)
 	}
@@ -2014,7 +2020,7 @@ func TestParseFrontmatter_StrippedBeforeParsing(t *testing.T) {
 	}
 
 	// The remaining text should parse correctly as a diagram
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error parsing remaining text, got %v", err)
 	}
@@ -2047,7 +2053,7 @@ font: fonts/test.woff2
 	}
 
 	// Should still parse the box
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2070,7 +2076,7 @@ font: fonts/test.woff2
 		t.Errorf("Expected font 'fonts/test.woff2', got '%s'", fm.Font)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2110,7 +2116,7 @@ func TestParseFrontmatter_NoFrontmatter(t *testing.T) {
 	}
 
 	// All text should be in remaining
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2157,7 +2163,7 @@ func TestParseFrontmatter_OnlyComments(t *testing.T) {
 		t.Errorf("Expected empty font, got '%s'", fm.Font)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2185,7 +2191,7 @@ font: fonts/BerkeleyMono.woff2
 		t.Errorf("Expected remaining to not contain '---' delimiters, got: %s", remaining)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2214,7 +2220,7 @@ font: fonts/test.woff2
 		t.Errorf("Expected font 'fonts/test.woff2', got '%s'", fm.Font)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2239,7 +2245,7 @@ a -> b
 		t.Errorf("Expected font 'fonts/test.woff2', got '%s'", fm.Font)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2270,7 +2276,7 @@ y-label: Control
 		t.Errorf("Expected y-label 'Control', got '%s'", fm.YLabel)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2296,7 +2302,7 @@ y-label: Ownership
 		t.Errorf("Expected y-label 'Ownership', got '%s'", fm.YLabel)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2341,7 +2347,7 @@ y-label: Control
 		t.Errorf("Expected y-label 'Control', got '%s'", fm.YLabel)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2370,7 +2376,7 @@ legend: p = In Progress
 		t.Errorf("Expected label 'In Progress', got '%s'", fm.Legend[0].Label)
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2469,7 +2475,7 @@ legend: g = Completed
 		t.Fatalf("Expected 2 legend entries, got %d", len(fm.Legend))
 	}
 
-	spec, err := ParseDiagramSpec(remaining, nil)
+	spec, err := parseDiagramSpec(remaining, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2559,7 +2565,7 @@ color: blue = #0000FF
 		t.Errorf("Expected blue=#0000FF, got '%s'", fm.Colors["blue"])
 	}
 
-	spec, err := ParseDiagramSpec(remaining, fm.Colors)
+	spec, err := parseDiagramSpec(remaining, fm.Colors)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2571,7 +2577,7 @@ color: blue = #0000FF
 func TestParseDiagramSpec_CustomColorInBox(t *testing.T) {
 	colors := map[string]string{"green": "#00FF00"}
 	text := `1,1: Task A, green`
-	spec, err := ParseDiagramSpec(text, colors)
+	spec, err := parseDiagramSpec(text, colors)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2583,7 +2589,7 @@ func TestParseDiagramSpec_CustomColorInBox(t *testing.T) {
 func TestParseDiagramSpec_CustomColorTextInBox(t *testing.T) {
 	colors := map[string]string{"green": "#00FF00"}
 	text := `1,1: Warning, nbb-greent`
-	spec, err := ParseDiagramSpec(text, colors)
+	spec, err := parseDiagramSpec(text, colors)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2602,7 +2608,7 @@ func TestParseDiagramSpec_Group_BasicAssignment(t *testing.T) {
 1,2: Stephan @Team
 >+2,0: Stefanie @Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2649,7 +2655,7 @@ func TestParseDiagramSpec_Group_WithDefinitionLine(t *testing.T) {
 >+2,0: Stefanie @Team
 @Team: Our Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2668,7 +2674,7 @@ func TestParseDiagramSpec_Group_WithStyle(t *testing.T) {
 	text := `
 1,2: Stefanie, p @Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2694,7 +2700,7 @@ func TestParseDiagramSpec_Group_NoGroup(t *testing.T) {
 1,2: Stephan
 3,2: Stefanie
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2719,7 +2725,7 @@ func TestParseDiagramSpec_Group_MultipleGroups(t *testing.T) {
 @Dev: Development
 @Ops: Operations
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2756,7 +2762,7 @@ func TestParseDiagramSpec_Group_DefinitionWithoutBoxes(t *testing.T) {
 1,1: Alice
 @EmptyGroup: No boxes
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -2808,7 +2814,7 @@ c: 5,1: Box C
 a -> b | down
 b -> c
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -2828,13 +2834,36 @@ b -> c
 	}
 }
 
+func TestParseDiagramSpec_ArrowFlowHints(t *testing.T) {
+	text := `a: 1,1: A
+b: 3,2: B
+a -> b | up
+a -> b | left-dashed
+a -> b | right
+a -> b | horizontal, thick
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{FlowUp, FlowLeft, FlowRight, FlowHorizontal}
+	for i, flow := range want {
+		if spec.Arrows[i].Flow != flow {
+			t.Errorf("Arrow %d: expected Flow=%q, got %q", i, flow, spec.Arrows[i].Flow)
+		}
+	}
+	if len(spec.Diagnostics) != 0 {
+		t.Errorf("Expected no warnings, got %v", spec.Diagnostics)
+	}
+}
+
 func TestParseDiagramSpec_ArrowFlowPerArrowWithSpaces(t *testing.T) {
 	text := `
 a: 1,1: Box A
 b: 3,2: Box B
 a -> b | down
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -2850,6 +2879,312 @@ a -> b | down
 	}
 }
 
+func TestParseDiagramSpec_ArrowLabel(t *testing.T) {
+	text := `
+a: 1,1: Box A
+b: 3,2: Box B
+a -> b: approves
+b -> a: plan | down
+a -> b:
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Arrows) != 3 {
+		t.Fatalf("Expected 3 arrows, got %d", len(spec.Arrows))
+	}
+
+	tests := []struct {
+		from, to, label, flow string
+	}{
+		{"a", "b", "approves", ""},
+		{"b", "a", "plan", "down"},
+		{"a", "b", "", ""},
+	}
+	for i, tt := range tests {
+		got := spec.Arrows[i]
+		if got.FromID != tt.from || got.ToID != tt.to || got.Label != tt.label || got.Flow != tt.flow {
+			t.Errorf("Arrow %d: expected %s -> %s label=%q flow=%q, got %s -> %s label=%q flow=%q",
+				i, tt.from, tt.to, tt.label, tt.flow, got.FromID, got.ToID, got.Label, got.Flow)
+		}
+	}
+}
+
+func TestParseDiagramSpec_ArrowChainsAndFans(t *testing.T) {
+	text := `
+a: 1,1: A
+b: 3,1: B
+c: 5,1: C
+d: 7,1: D
+a -> b -> c -> d
+a -> b, c, d | down
+b, c -> d: reports
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []ArrowSpec{
+		{FromID: "a", ToID: "b", Line: 6},
+		{FromID: "b", ToID: "c", Line: 6},
+		{FromID: "c", ToID: "d", Line: 6},
+		{FromID: "a", ToID: "b", Flow: "down", Line: 7},
+		{FromID: "a", ToID: "c", Flow: "down", Line: 7},
+		{FromID: "a", ToID: "d", Flow: "down", Line: 7},
+		{FromID: "b", ToID: "d", Label: "reports", Line: 8},
+		{FromID: "c", ToID: "d", Label: "reports", Line: 8},
+	}
+	if len(spec.Arrows) != len(want) {
+		t.Fatalf("Expected %d arrows, got %d: %+v", len(want), len(spec.Arrows), spec.Arrows)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(spec.Arrows[i], want[i]) {
+			t.Errorf("Arrow %d: expected %+v, got %+v", i, want[i], spec.Arrows[i])
+		}
+	}
+}
+
+func TestParseDiagramSpec_ArrowChainDiagnosticColumns(t *testing.T) {
+	text := `a: 1,1: A
+b: 3,1: B
+a -> b -> x, b`
+	_, err := parseDiagramSpec(text, nil)
+	if err == nil {
+		t.Fatal("Expected error for missing chain target")
+	}
+	want := "3:11: arrow 'b -> x' references non-existent box label 'x'"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestParseDiagramSpec_ArrowChainInContainer(t *testing.T) {
+	text := `
+E: 8,1: External
+G: 1,1 [
+    X: 0,0: Alpha
+    Y: 2,0: Beta
+    X -> Y -> E
+]
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(spec.Arrows))
+	}
+	if spec.Arrows[0].FromID != "G.X" || spec.Arrows[0].ToID != "G.Y" {
+		t.Errorf("Expected G.X -> G.Y, got %s -> %s", spec.Arrows[0].FromID, spec.Arrows[0].ToID)
+	}
+	if spec.Arrows[1].FromID != "G.Y" || spec.Arrows[1].ToID != "E" {
+		t.Errorf("Expected G.Y -> E, got %s -> %s", spec.Arrows[1].FromID, spec.Arrows[1].ToID)
+	}
+}
+
+func TestParseDiagramSpec_ArrowConnectors(t *testing.T) {
+	text := `
+a: 1,1: A
+b-1: 3,1: B
+c: 5,1: C
+a <- b-1
+a <-> c: sync
+b-1 -- c
+a -> b-1 <- c, a
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []ArrowSpec{
+		{FromID: "b-1", ToID: "a", Line: 5},
+		{FromID: "a", ToID: "c", Label: "sync", Connector: ConnectorBidirectional, Line: 6},
+		{FromID: "b-1", ToID: "c", Connector: ConnectorUndirected, Line: 7},
+		{FromID: "a", ToID: "b-1", Line: 8},
+		{FromID: "c", ToID: "b-1", Line: 8},
+		{FromID: "a", ToID: "b-1", Line: 8},
+	}
+	if len(spec.Arrows) != len(want) {
+		t.Fatalf("Expected %d arrows, got %d: %+v", len(want), len(spec.Arrows), spec.Arrows)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(spec.Arrows[i], want[i]) {
+			t.Errorf("Arrow %d: expected %+v, got %+v", i, want[i], spec.Arrows[i])
+		}
+	}
+
+	if !spec.Arrows[1].HasStartHead() || !spec.Arrows[1].HasEndHead() {
+		t.Error("Expected '<->' to have heads at both ends")
+	}
+	if spec.Arrows[2].HasStartHead() || spec.Arrows[2].HasEndHead() {
+		t.Error("Expected '--' to have no heads")
+	}
+	if spec.Arrows[0].HasStartHead() || !spec.Arrows[0].HasEndHead() {
+		t.Error("Expected '<-' to have a single head at its (swapped) end")
+	}
+}
+
+func TestParseDiagramSpec_ReversedArrowDiagnosticColumn(t *testing.T) {
+	text := `a: 1,1: A
+a <- missing`
+	_, err := parseDiagramSpec(text, nil)
+	if err == nil {
+		t.Fatal("Expected error for missing box")
+	}
+	want := "2:6: arrow 'missing -> a' references non-existent box label 'missing'"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestParseDiagramSpec_ArrowLineStyles(t *testing.T) {
+	text := `
+a: 1,1: A
+b: 3,1: B
+c: 5,1: C
+a -> b | dashed-red
+b -> c: escalate | down, thick-blue
+a -> c | dotted
+`
+	spec, err := parseDiagramSpec(text, map[string]string{"blue": "#3B82F6"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []ArrowSpec{
+		{FromID: "a", ToID: "b", Dash: ArrowDashed, Color: "#FF0000", Line: 5},
+		{FromID: "b", ToID: "c", Label: "escalate", Flow: "down", StrokeWidth: thickArrowWidth, Color: "#3B82F6", Line: 6},
+		{FromID: "a", ToID: "c", Dash: ArrowDotted, Line: 7},
+	}
+	if len(spec.Arrows) != len(want) {
+		t.Fatalf("Expected %d arrows, got %d", len(want), len(spec.Arrows))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(spec.Arrows[i], want[i]) {
+			t.Errorf("Arrow %d: expected %+v, got %+v", i, want[i], spec.Arrows[i])
+		}
+	}
+}
+
+func TestParseDiagramSpec_ArrowheadOption(t *testing.T) {
+	text := `
+a: 1,1: A
+b: 3,1: B
+a -> b | hollow
+a <-> b | diamond-dashed
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.Arrows[0].Head != HeadHollow {
+		t.Errorf("Expected hollow head, got %q", spec.Arrows[0].Head)
+	}
+	if spec.Arrows[1].Head != HeadDiamond || spec.Arrows[1].Dash != ArrowDashed {
+		t.Errorf("Expected dashed arrow with diamond heads, got %+v", spec.Arrows[1])
+	}
+}
+
+func TestParseFrontmatter_Arrowhead(t *testing.T) {
+	fm, _ := ParseFrontmatter("---\narrowhead: triangle\n---\nA: 1,1: Box")
+	if fm.Arrowhead != "triangle" {
+		t.Errorf("Expected Arrowhead='triangle', got %q", fm.Arrowhead)
+	}
+}
+
+func TestParseDiagramSpec_UnknownArrowOptionIsWarning(t *testing.T) {
+	text := `a: 1,1: A
+b: 3,1: B
+a -> b | dashed-purpel`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", spec.Diagnostics)
+	}
+	want := "3:10: warning: unknown arrow option 'purpel' (ignored)"
+	if got := spec.Diagnostics[0].String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if spec.Arrows[0].Dash != ArrowDashed {
+		t.Errorf("Expected known options to still apply, got %+v", spec.Arrows[0])
+	}
+}
+
+func TestParseDiagramSpec_InvalidCustomColorRejected(t *testing.T) {
+	text := `a: 1,1: A, evil
+b: 3,1: B
+a -> b | evil
+a -> b | ok`
+	colors := map[string]string{"evil": `red" onload="alert(1)`, "ok": "#abc"}
+	spec, err := parseDiagramSpec(text, colors)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.Arrows[0].Color != "" || spec.Boxes[0].Color != "" {
+		t.Errorf("Expected the invalid color to be ignored, got arrow %q and box %q", spec.Arrows[0].Color, spec.Boxes[0].Color)
+	}
+	if spec.Arrows[1].Color != "#abc" {
+		t.Errorf("Expected the short hex color to apply, got %q", spec.Arrows[1].Color)
+	}
+	if len(spec.Diagnostics) != 3 || !strings.Contains(spec.Diagnostics[1].Message, "unknown arrow option 'evil'") ||
+		!strings.Contains(spec.Diagnostics[2].Message, "custom color 'evil'") {
+		t.Errorf("Expected warnings for the box style, the arrow option and the custom color, got %v", spec.Diagnostics)
+	}
+}
+
+func TestParseDiagramSpec_NamedArrowColors(t *testing.T) {
+	text := `a: 1,1: A
+b: 3,1: B
+a -> b | blue
+a -> b | dashed-rebeccapurple
+a -> b | accent
+a -> b | blu`
+	spec, err := parseDiagramSpec(text, map[string]string{"accent": "Teal", "blue": "#123456"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, want := range []string{"#123456", "#663399", "Teal", ""} {
+		if got := spec.Arrows[i].Color; got != want {
+			t.Errorf("Arrow %d: expected color %q, got %q", i, want, got)
+		}
+	}
+	if len(spec.Diagnostics) != 1 || spec.Diagnostics[0].String() != "6:10: warning: unknown arrow option 'blu' (ignored)" {
+		t.Errorf("Expected a warning for the misspelled color, got %v", spec.Diagnostics)
+	}
+}
+
+func TestValidColor(t *testing.T) {
+	tests := map[string]bool{
+		"#3B82F6": true, "#abc": true, "blue": true, "DarkSlateGray": true,
+		"blu": false, "": false, "#12345": false, "#ggg": false, "rgb(0,0,0)": false, `red"`: false, "<b>": false,
+	}
+	for value, want := range tests {
+		if got := validColor(value); got != want {
+			t.Errorf("validColor(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestParseDiagramSpec_BoxLabelWithArrow(t *testing.T) {
+	// A box whose label contains a connector is still a box, not an arrow
+	text := `a: 1,1: Input -> Output`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Boxes) != 1 || len(spec.Arrows) != 0 {
+		t.Fatalf("Expected 1 box and 0 arrows, got %d boxes and %d arrows", len(spec.Boxes), len(spec.Arrows))
+	}
+	if spec.Boxes[0].Label != "Input -> Output" {
+		t.Errorf("Expected label 'Input -> Output', got %q", spec.Boxes[0].Label)
+	}
+}
+
 // Container tests
 
 func TestParseDiagramSpec_ContainerBasic(t *testing.T) {
@@ -2861,7 +3196,7 @@ G: 1,1 [
     X -> Y
 ]
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -2909,13 +3244,13 @@ G: 1,1 [
 }
 
 func TestParseDiagramSpec_ContainerWithThirdField(t *testing.T) {
-	// Third field after ID and coords is accepted (ignored — containers have no label)
+	// Third field after ID and coords is the container label; without a style no frame is drawn
 	text := `
 G: 1,1: My Group [
     X: 0,0: Hello
 ]
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -2929,6 +3264,76 @@ G: 1,1: My Group [
 	if len(spec.Groups) != 0 {
 		t.Errorf("Expected 0 groups, got %d", len(spec.Groups))
 	}
+	if len(spec.Containers) != 1 {
+		t.Fatalf("Expected 1 container, got %d", len(spec.Containers))
+	}
+	if spec.Containers[0].Label != "My Group" {
+		t.Errorf("Expected label 'My Group', got '%s'", spec.Containers[0].Label)
+	}
+	if spec.Containers[0].Framed {
+		t.Error("Expected container without style to be unframed")
+	}
+}
+
+func TestParseDiagramSpec_ContainerFrameStyles(t *testing.T) {
+	text := `
+G: 1,1: Billing, frame-dash-tc-g-rb [
+    X: 0,0: Invoice
+    S: 2,0: Sub, blue-tr [
+        Y: 0,0: Ledger
+    ]
+]
+`
+	spec, err := parseDiagramSpec(text, map[string]string{"blue": "#3B82F6"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Containers) != 2 {
+		t.Fatalf("Expected 2 containers, got %d", len(spec.Containers))
+	}
+
+	outer := spec.Containers[0]
+	if outer.ID != "G" || outer.Label != "Billing" || outer.Parent != "" || !outer.Framed {
+		t.Errorf("Unexpected outer container: %+v", outer)
+	}
+	want := ContainerStyles{FillColor: "#D3D3D3", BorderColor: "#FF0000", BorderWidth: 3, Dashed: true, TitleAlign: "center"}
+	if outer.Styles != want {
+		t.Errorf("Expected styles %+v, got %+v", want, outer.Styles)
+	}
+	if strings.Join(outer.BoxIDs, ",") != "G.X,G.S.Y" {
+		t.Errorf("Expected outer members G.X,G.S.Y, got %v", outer.BoxIDs)
+	}
+
+	inner := spec.Containers[1]
+	if inner.ID != "G.S" || inner.Parent != "G" || !inner.Framed {
+		t.Errorf("Unexpected inner container: %+v", inner)
+	}
+	if inner.Styles.FillColor != "#3B82F6" || inner.Styles.TitleAlign != "right" {
+		t.Errorf("Expected blue fill and right title, got %+v", inner.Styles)
+	}
+	if strings.Join(inner.BoxIDs, ",") != "G.S.Y" {
+		t.Errorf("Expected inner members G.S.Y, got %v", inner.BoxIDs)
+	}
+}
+
+func TestParseDiagramSpec_ContainerUnknownStyleIsWarning(t *testing.T) {
+	text := `G: 1,1: Billing, frame-wobbly [
+    X: 0,0: Invoice
+]`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 warning, got %d: %v", len(spec.Diagnostics), spec.Diagnostics)
+	}
+	want := "1:18: warning: container 'G': unknown style code 'wobbly' (ignored)"
+	if got := spec.Diagnostics[0].String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if !spec.Containers[0].Framed {
+		t.Error("Expected container to stay framed")
+	}
 }
 
 func TestParseDiagramSpec_ContainerMoved(t *testing.T) {
@@ -2939,7 +3344,7 @@ G: 3,2 [
     4,4: C
 ]
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -2972,7 +3377,7 @@ G: 3,3 [
 ]
 B: 5,5: After
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3010,7 +3415,7 @@ G: 3,3 [
 ]
 A -> G.X
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3030,15 +3435,13 @@ func TestParseDiagramSpec_ContainerErrors(t *testing.T) {
 		wantErr string
 	}{
 		{
-			name: "nested container",
+			name: "unclosed nested container",
 			input: `
 G1: 1,1 [
     G2: 2,2 [
         0,0: A
-    ]
-]
 `,
-			wantErr: "nested containers not supported",
+			wantErr: "unclosed container 'G1.G2'",
 		},
 		{
 			name: "unclosed container",
@@ -3056,11 +3459,42 @@ G: 1,1 [
 `,
 			wantErr: "unexpected ']' outside container",
 		},
+		{
+			name: "invalid container ID",
+			input: `
+G$: 1,1 [
+    0,0: A
+]
+`,
+			wantErr: "invalid container ID 'G$'",
+		},
+		{
+			name: "duplicate container ID",
+			input: `
+G: 1,1 [
+    A: 0,0: A
+]
+G: 5,1 [
+    B: 0,0: B
+]
+`,
+			wantErr: "duplicate ID 'G' (first defined on line 2)",
+		},
+		{
+			name: "container ID taken by a box",
+			input: `
+G: 1,1: Box
+G: 5,1 [
+    B: 0,0: B
+]
+`,
+			wantErr: "duplicate ID 'G' (first defined on line 2)",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := ParseDiagramSpec(tt.input, nil)
+			_, err := parseDiagramSpec(tt.input, nil)
 			if err == nil {
 				t.Fatalf("Expected error containing %q, got nil", tt.wantErr)
 			}
@@ -3071,6 +3505,149 @@ G: 1,1 [
 	}
 }
 
+func TestParseDiagramSpec_DuplicateBoxID(t *testing.T) {
+	_, err := parseDiagramSpec("A: 1,1: First\nB: 3,1: Second\nA: 5,1: Third\n", nil)
+	var diags Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 1 {
+		t.Fatalf("Expected one diagnostic, got %v", err)
+	}
+	d := diags[0]
+	if d.Code != CodeInvalidID || d.Line != 3 || d.Column != 1 {
+		t.Errorf("Expected an invalid-id error at 3:1, got %s at %d:%d", d.Code, d.Line, d.Column)
+	}
+	if !strings.Contains(d.Message, "duplicate ID 'A' (first defined on line 1)") {
+		t.Errorf("Unexpected message %q", d.Message)
+	}
+
+	// The same ID in different containers is scoped apart
+	if _, err := parseDiagramSpec("G: 1,1 [\n    A: 0,0: A\n]\nH: 5,1 [\n    A: 0,0: A\n]\nA: 1,4: A\n", nil); err != nil {
+		t.Errorf("Expected scoped IDs to be distinct, got %v", err)
+	}
+}
+
+// Nested container tests
+
+func TestParseDiagramSpec_NestedContainers(t *testing.T) {
+	text := `
+S: 2,2 [
+    Sub: 1,1 [
+        C: 0,0: Component
+        D: 2,1: Detail
+        C -> D
+    ]
+    E: 1,3: Edge
+]
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(spec.Boxes) != 3 {
+		t.Fatalf("Expected 3 boxes, got %d", len(spec.Boxes))
+	}
+
+	// Sub at 1,1 inside S at 2,2 → origin (3,3); C at 0,0 → (3,3)
+	if spec.Boxes[0].ID != "S.Sub.C" || spec.Boxes[0].GridX != 3 || spec.Boxes[0].GridY != 3 {
+		t.Errorf("Expected S.Sub.C at (3,3), got %s at (%d,%d)", spec.Boxes[0].ID, spec.Boxes[0].GridX, spec.Boxes[0].GridY)
+	}
+	// D at 2,1 inside Sub → (5,4)
+	if spec.Boxes[1].ID != "S.Sub.D" || spec.Boxes[1].GridX != 5 || spec.Boxes[1].GridY != 4 {
+		t.Errorf("Expected S.Sub.D at (5,4), got %s at (%d,%d)", spec.Boxes[1].ID, spec.Boxes[1].GridX, spec.Boxes[1].GridY)
+	}
+	// E at 1,3 back in S → (3,5)
+	if spec.Boxes[2].ID != "S.E" || spec.Boxes[2].GridX != 3 || spec.Boxes[2].GridY != 5 {
+		t.Errorf("Expected S.E at (3,5), got %s at (%d,%d)", spec.Boxes[2].ID, spec.Boxes[2].GridX, spec.Boxes[2].GridY)
+	}
+
+	if len(spec.Arrows) != 1 || spec.Arrows[0].FromID != "S.Sub.C" || spec.Arrows[0].ToID != "S.Sub.D" {
+		t.Errorf("Expected arrow S.Sub.C->S.Sub.D, got %+v", spec.Arrows)
+	}
+}
+
+func TestParseDiagramSpec_NestedContainerArrowScopes(t *testing.T) {
+	text := `
+Top: 1,1: Top Level
+S: 2,2 [
+    E: 0,3: Edge
+    Sub: 1,1 [
+        C: 0,0: Component
+        C -> E
+        C -> Top
+    ]
+    E -> Sub.C
+]
+Top -> S.Sub.C
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := [][2]string{
+		{"S.Sub.C", "S.E"}, // sibling in parent scope
+		{"S.Sub.C", "Top"}, // top-level box
+		{"S.E", "S.Sub.C"}, // child container reference
+		{"Top", "S.Sub.C"}, // fully qualified from outside
+	}
+	if len(spec.Arrows) != len(want) {
+		t.Fatalf("Expected %d arrows, got %d", len(want), len(spec.Arrows))
+	}
+	for i, w := range want {
+		if spec.Arrows[i].FromID != w[0] || spec.Arrows[i].ToID != w[1] {
+			t.Errorf("Arrow %d: expected %s->%s, got %s->%s", i, w[0], w[1], spec.Arrows[i].FromID, spec.Arrows[i].ToID)
+		}
+	}
+}
+
+func TestParseDiagramSpec_NestedContainerUnknownArrowTarget(t *testing.T) {
+	text := `
+S: 2,2 [
+    Sub: 1,1 [
+        C: 0,0: Component
+        C -> Missing
+    ]
+]
+`
+	_, err := parseDiagramSpec(text, nil)
+	if err == nil {
+		t.Fatal("Expected error for unknown arrow target")
+	}
+	if !strings.Contains(err.Error(), "references non-existent box label 'S.Sub.Missing'") {
+		t.Errorf("Expected innermost scoped name in error, got %q", err.Error())
+	}
+}
+
+func TestParseDiagramSpec_NestedContainerGroups(t *testing.T) {
+	text := `
+S: 1,1 [
+    A: 0,0: Alpha
+    Sub: 0,1 [
+        B: 0,0: Beta
+    ] @Inner
+] @Outer
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	groups := map[string]string{}
+	for _, box := range spec.Boxes {
+		groups[box.ID] = box.Group
+	}
+	if groups["S.A"] != "Outer" {
+		t.Errorf("Expected S.A in group Outer, got %q", groups["S.A"])
+	}
+	// Innermost container group wins
+	if groups["S.Sub.B"] != "Inner" {
+		t.Errorf("Expected S.Sub.B in group Inner, got %q", groups["S.Sub.B"])
+	}
+	if len(spec.Groups) != 2 {
+		t.Errorf("Expected 2 groups, got %d", len(spec.Groups))
+	}
+}
+
 // Container @Group tests
 
 func TestParseDiagramSpec_ContainerGroupBasic(t *testing.T) {
@@ -3080,7 +3657,7 @@ G: 1,1 [
     B: 1,0: Bob
 ] @Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3120,7 +3697,7 @@ G: 1,1 [
     B: 1,0: Bob
 ] @Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3140,7 +3717,7 @@ G: 1,1 [
     A: 0,0: Alice
 ] @Solo
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3169,7 +3746,7 @@ G: 1,1 [
     B: 1,0: Bob
 ] @Team
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3198,7 +3775,7 @@ G: 1,1 [
     B: 1,0: Bob
 ]
 `
-	spec, err := ParseDiagramSpec(text, nil)
+	spec, err := parseDiagramSpec(text, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -3214,3 +3791,354 @@ G: 1,1 [
 		t.Errorf("Expected 0 groups, got %d", len(spec.Groups))
 	}
 }
+
+// Diagnostic position tests
+
+func TestParseFrontmatter_BodyOffset(t *testing.T) {
+	text := `---
+font: fonts/test.woff2
+arrow-flow: down
+---
+a: 1,1: Box A
+`
+	fm, _ := ParseFrontmatter(text)
+	if fm.BodyOffset != 4 {
+		t.Errorf("Expected BodyOffset 4, got %d", fm.BodyOffset)
+	}
+
+	fm, _ = ParseFrontmatter("font: fonts/test.woff2\n\na: 1,1: Box A\n")
+	if fm.BodyOffset != 2 {
+		t.Errorf("Expected BodyOffset 2 for undelimited frontmatter, got %d", fm.BodyOffset)
+	}
+}
+
+func TestParseDiagramSpec_DiagnosticPosition(t *testing.T) {
+	text := `---
+arrow-flow: down
+---
+a: 1,1: Box A
+  b: 3,x: Box B
+`
+	fm, remaining := ParseFrontmatter(text)
+	_, err := parseDiagramSpecWithOptions(remaining, nil, ParseOptions{File: "test.txt", LineOffset: fm.BodyOffset})
+	if err == nil {
+		t.Fatal("Expected error for invalid Y coordinate")
+	}
+
+	var diag Diagnostic
+	if !errors.As(err, &diag) {
+		t.Fatalf("Expected Diagnostic error, got %T", err)
+	}
+	if diag.Line != 5 || diag.Column != 8 {
+		t.Errorf("Expected position 5:8, got %d:%d", diag.Line, diag.Column)
+	}
+	if diag.Code != CodeCoordinate || diag.Severity != SeverityError {
+		t.Errorf("Expected coordinate error, got code=%q severity=%q", diag.Code, diag.Severity)
+	}
+	if !strings.HasPrefix(err.Error(), "test.txt:5:8: invalid Y coordinate") {
+		t.Errorf("Expected compiler-style message, got %q", err.Error())
+	}
+}
+
+func TestParseDiagramSpec_DiagnosticColumnOfRepeatedToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		column int
+	}{
+		{"box style", "A: 1,1: q q, q", 14},
+		{"container style", "G: 1,1: q q, q [\n]", 14},
+		{"arrow option", "A: 1,1: A\nB: 4,1: B\nA -> B: q | q", 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseDiagramSpec(tt.text, nil)
+			if err != nil {
+				t.Fatalf("ParseDiagramSpec failed: %v", err)
+			}
+			if len(spec.Diagnostics) != 1 || spec.Diagnostics[0].Column != tt.column {
+				t.Errorf("Expected one warning at column %d, got %v", tt.column, spec.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestParseDiagramSpec_NotAnArrowReportsOnlyBoxError(t *testing.T) {
+	_, err := parseDiagramSpec("A: 1,1: A\nA -> | wobbly\n", nil)
+	var diags Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 1 {
+		t.Fatalf("Expected only the box error, got %v", err)
+	}
+	if diags[0].Severity != SeverityError {
+		t.Errorf("Expected an error, got %v", diags[0])
+	}
+}
+
+func TestParseDiagramSpec_DiagnosticUnclosedContainerPosition(t *testing.T) {
+	text := `a: 1,1: Box A
+G: 3,3 [
+    X: 0,0: Inside
+`
+	_, err := parseDiagramSpec(text, nil)
+	var diag Diagnostic
+	if !errors.As(err, &diag) {
+		t.Fatalf("Expected Diagnostic error, got %v", err)
+	}
+	// Reported at the container header, not at end of file
+	if diag.Line != 2 || diag.Column != 1 {
+		t.Errorf("Expected position 2:1, got %d:%d", diag.Line, diag.Column)
+	}
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	tests := []struct {
+		diag Diagnostic
+		want string
+	}{
+		{Diagnostic{File: "d.txt", Line: 3, Column: 7, Severity: SeverityError, Message: "bad"}, "d.txt:3:7: bad"},
+		{Diagnostic{Line: 3, Severity: SeverityError, Message: "bad"}, "3: bad"},
+		{Diagnostic{File: "d.txt", Line: 2, Column: 1, Severity: SeverityWarning, Message: "odd"}, "d.txt:2:1: warning: odd"},
+		{Diagnostic{Severity: SeverityError, Message: "bad"}, "bad"},
+	}
+	for _, tt := range tests {
+		if got := tt.diag.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// Error recovery tests
+
+func TestParseDiagramSpec_CollectsAllErrors(t *testing.T) {
+	text := `a: 1,1: Box A
+b: 3,x: Box B
+c: 1,1,0: Box C
+d$: 1,1: Box D
+]
+a -> missing
+`
+	_, err := parseDiagramSpec(text, nil)
+	var diags Diagnostics
+	if !errors.As(err, &diags) {
+		t.Fatalf("Expected Diagnostics error, got %v", err)
+	}
+
+	wantCodes := []string{CodeCoordinate, CodeSize, CodeInvalidID, CodeContainer, CodeArrowReference}
+	wantLines := []int{2, 3, 4, 5, 6}
+	if len(diags) != len(wantCodes) {
+		t.Fatalf("Expected %d diagnostics, got %d:\n%v", len(wantCodes), len(diags), err)
+	}
+	for i, d := range diags {
+		if d.Code != wantCodes[i] || d.Line != wantLines[i] {
+			t.Errorf("Diagnostic %d: expected %s at line %d, got %s at line %d", i, wantCodes[i], wantLines[i], d.Code, d.Line)
+		}
+	}
+}
+
+func TestParseDiagramSpec_FailedBoxSuppressesArrowError(t *testing.T) {
+	text := `a: 1,1: Box A
+b: 3,x: Box B
+a -> b
+`
+	_, err := parseDiagramSpec(text, nil)
+	var diags Diagnostics
+	if !errors.As(err, &diags) {
+		t.Fatalf("Expected Diagnostics error, got %v", err)
+	}
+	if len(diags) != 1 {
+		t.Errorf("Expected only the coordinate error, got:\n%v", err)
+	}
+}
+
+func TestParseDiagramSpec_MaxErrors(t *testing.T) {
+	text := `a: x,1: A
+b: x,1: B
+c: x,1: C
+d: x,1: D
+`
+	_, err := parseDiagramSpecWithOptions(text, nil, ParseOptions{MaxErrors: 2})
+	var diags Diagnostics
+	if !errors.As(err, &diags) {
+		t.Fatalf("Expected Diagnostics error, got %v", err)
+	}
+	if len(diags) != 3 {
+		t.Fatalf("Expected 2 errors plus limit note, got %d:\n%v", len(diags), err)
+	}
+	if diags[2].Code != CodeTooManyErrors {
+		t.Errorf("Expected last diagnostic %q, got %q", CodeTooManyErrors, diags[2].Code)
+	}
+}
+
+func TestParseDiagramSpec_UnknownStyleIsWarning(t *testing.T) {
+	spec, err := parseDiagramSpec("a: 1,1: Hello, world\n", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(spec.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 warning, got %d", len(spec.Diagnostics))
+	}
+	d := spec.Diagnostics[0]
+	if d.Severity != SeverityWarning || d.Code != CodeStyle {
+		t.Errorf("Expected style warning, got %s %s", d.Severity, d.Code)
+	}
+	if !strings.Contains(d.Message, "'world'") {
+		t.Errorf("Expected warning to name the unknown code, got %q", d.Message)
+	}
+}
+
+func TestParseDiagramSpec_ArrowPorts(t *testing.T) {
+	text := `
+a: 1,1: A
+b: 3,3: B
+G: 5,1 [
+    top: 0,0: Top
+    x: 0,2: X
+    top.left -> x.top
+]
+a.bottom -> b.left
+b <- a.right: back
+a -> G.top.bottom
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []ArrowSpec{
+		{FromID: "G.top", ToID: "G.x", FromSide: SideLeft, ToSide: SideTop, Line: 7},
+		{FromID: "a", ToID: "b", FromSide: SideBottom, ToSide: SideLeft, Line: 9},
+		{FromID: "a", ToID: "b", FromSide: SideRight, Label: "back", Line: 10},
+		{FromID: "a", ToID: "G.top", ToSide: SideBottom, Line: 11},
+	}
+	if len(spec.Arrows) != len(want) {
+		t.Fatalf("Expected %d arrows, got %d: %+v", len(want), len(spec.Arrows), spec.Arrows)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(spec.Arrows[i], want[i]) {
+			t.Errorf("Arrow %d: expected %+v, got %+v", i, want[i], spec.Arrows[i])
+		}
+	}
+}
+
+func TestParseDiagramSpec_PortSuffixInBoxID(t *testing.T) {
+	// "G.top" names a box, so ".top" is not a port here
+	text := `
+a: 1,1: A
+G: 3,1 [
+    top: 0,0: Top
+]
+a -> G.top
+`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Arrows) != 1 {
+		t.Fatalf("Expected 1 arrow, got %d", len(spec.Arrows))
+	}
+	if spec.Arrows[0].ToID != "G.top" || spec.Arrows[0].ToSide != "" {
+		t.Errorf("Expected arrow to box G.top without port, got %+v", spec.Arrows[0])
+	}
+}
+
+func TestParseFrontmatter_Crossings(t *testing.T) {
+	fm, _ := ParseFrontmatter("---\ncrossings: hop\n---\nA: 1,1: Box")
+	if fm.Crossings != "hop" {
+		t.Errorf("Expected Crossings='hop', got %q", fm.Crossings)
+	}
+}
+
+func TestParseArrow_SelfLoop(t *testing.T) {
+	spec, err := parseDiagramSpec("A: 1,1: Alpha\nA -> A: review\nA.top -> A.top", nil)
+	if err != nil {
+		t.Fatalf("ParseDiagramSpec failed: %v", err)
+	}
+	if len(spec.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(spec.Arrows))
+	}
+	if a := spec.Arrows[0]; a.FromID != "A" || a.ToID != "A" || a.Label != "review" {
+		t.Errorf("Unexpected self-loop arrow: %+v", a)
+	}
+	if a := spec.Arrows[1]; a.FromSide != SideTop || a.ToSide != SideTop {
+		t.Errorf("Expected top ports on the self-loop, got %+v", a)
+	}
+}
+
+func TestParseDiagramSpec_ArrowShapeOption(t *testing.T) {
+	spec, err := parseDiagramSpec("a: 1,1: A\nb: 3,1: B\na -> b | rounded\nb -> a | curved-dashed\na -- b | sharp", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{ArrowRounded, ArrowCurved, ArrowSharp}
+	for i, shape := range want {
+		if spec.Arrows[i].Shape != shape {
+			t.Errorf("Arrow %d: expected shape %q, got %q", i, shape, spec.Arrows[i].Shape)
+		}
+	}
+	if spec.Arrows[1].Dash != ArrowDashed {
+		t.Errorf("Expected curved arrow to stay dashed, got %+v", spec.Arrows[1])
+	}
+}
+
+func TestParseFrontmatter_ArrowShape(t *testing.T) {
+	fm, _ := ParseFrontmatter("---\narrow-shape: curved\n---\nA: 1,1: Box")
+	if fm.ArrowShape != "curved" {
+		t.Errorf("Expected ArrowShape='curved', got %q", fm.ArrowShape)
+	}
+}
+
+func TestParseFrontmatter_ArrowMerge(t *testing.T) {
+	fm, _ := ParseFrontmatter("---\narrow-merge: none\n---\nA: 1,1: Box")
+	if fm.ArrowMerge != MergeNone {
+		t.Errorf("Expected ArrowMerge='none', got %q", fm.ArrowMerge)
+	}
+}
+
+func TestParseDiagramSpec_LoopOption(t *testing.T) {
+	spec, err := parseDiagramSpec("A: 1,1: A\nB: 3,1: B\nB -> A: retry | loop, dashed", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a := spec.Arrows[0]; a.Flow != FlowLoop || a.Dash != ArrowDashed || a.Label != "retry" {
+		t.Errorf("Unexpected arrow: %+v", a)
+	}
+}
+
+func TestParseDiagramSpec_ArrowWaypoints(t *testing.T) {
+	text := `a: 1,1: A
+b: 5,1: B
+a -> b via 2,3 via 5,3: around | dashed
+G: 1,4: Group [
+  c: 1,1: C
+  d: 3,1: D
+  c -> d via 2,2
+]`
+	spec, err := parseDiagramSpec(text, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(spec.Arrows) != 2 {
+		t.Fatalf("Expected 2 arrows, got %d", len(spec.Arrows))
+	}
+	a := spec.Arrows[0]
+	if a.FromID != "a" || a.ToID != "b" || a.Label != "around" || a.Dash != ArrowDashed {
+		t.Errorf("Unexpected arrow: %+v", a)
+	}
+	if !reflect.DeepEqual(a.Via, []int{2, 3, 5, 3}) {
+		t.Errorf("Expected waypoints [2 3 5 3], got %v", a.Via)
+	}
+	// Inside a container, waypoints are relative to its origin like box coordinates
+	if via := spec.Arrows[1].Via; !reflect.DeepEqual(via, []int{3, 6}) {
+		t.Errorf("Expected container waypoint [3 6], got %v", via)
+	}
+}
+
+func TestParseDiagramSpec_InvalidWaypoint(t *testing.T) {
+	for _, line := range []string{"a -> b via 0,3", "a -> b via x,3", "a -> b via 2,3 extra"} {
+		_, err := parseDiagramSpec("a: 1,1: A\nb: 3,1: B\n"+line, nil)
+		var diag Diagnostic
+		if !errors.As(err, &diag) || diag.Code != CodeCoordinate || diag.Line != 3 {
+			t.Errorf("%q: expected coordinate error on line 3, got %v", line, err)
+		}
+	}
+}
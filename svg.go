@@ -1,39 +1,193 @@
-package main
+package control
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/xml"
 	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
 	"strings"
 )
 
-// svgHeader returns the SVG opening tag with optional embedded font and arrowhead marker definition
-func svgHeader(width, height int, font *FontData) string {
-	header := fmt.Sprintf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, width, height) +
-		`<defs>`
+// svgWriter streams SVG elements to an io.Writer through a buffer. The first
+// write error is kept and later writes are skipped, so emitters can write
+// without checking every call; flush reports the error.
+type svgWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// newSVGWriter returns an svgWriter buffering writes to w
+func newSVGWriter(w io.Writer) *svgWriter {
+	return &svgWriter{w: bufio.NewWriter(w)}
+}
+
+// str writes text as is
+func (s *svgWriter) str(text string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(text)
+	}
+}
+
+// printf writes formatted text
+func (s *svgWriter) printf(format string, args ...any) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+// text writes text escaped for XML character data, so labels cannot inject markup
+func (s *svgWriter) text(text string) {
+	if s.err == nil {
+		s.err = xml.EscapeText(s.w, []byte(text))
+	}
+}
+
+// flush writes out the buffer and returns the first error of all writes
+func (s *svgWriter) flush() error {
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	return s.err
+}
+
+// writeSVGHeader writes the SVG opening tag with optional embedded font and arrowhead marker definitions.
+// The default chevron marker is always defined; markers lists the other shape/color
+// combinations used by arrows. The font is written straight from its base64 data.
+func writeSVGHeader(sw *svgWriter, width, height int, font *FontData, markers []arrowMarker) {
+	sw.printf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`, width, height)
+	sw.str(`<defs>`)
 
 	// Add @font-face only if custom font is provided
 	if font != nil {
-		safeName := sanitizeFontName(font.FontName)
-		header += `<style type="text/css">` +
-			`@font-face {` +
-			`font-family: '` + safeName + `';` +
-			`src: url(data:font/woff2;base64,` + font.Base64Data + `);` +
-			`}` +
-			`</style>`
+		sw.str(`<style type="text/css">@font-face {font-family: '`)
+		sw.str(sanitizeFontName(font.FontName))
+		sw.str(`';src: url(data:font/woff2;base64,`)
+		sw.str(font.Base64Data)
+		sw.str(`);}</style>`)
 	}
 
-	// Add arrowhead marker definition
-	header += `<marker id="arrowhead" markerWidth="12" markerHeight="13" refX="8" refY="5.5" orient="auto" viewBox="-1 -1 14 13"><polyline points="0 0.5, 8 5.5, 0 10.4" fill="none" stroke="#000" stroke-width="1.5" stroke-linejoin="miter"/></marker>` +
-		`</defs>` +
-		fmt.Sprintf(`<rect width="%d" height="%d" fill="#fff"/>`, width, height)
+	// Add arrowhead marker definitions, one pair (end and start) per shape and color
+	arrowMarker{}.defs(sw)
+	for _, marker := range markers {
+		if marker != (arrowMarker{}) {
+			marker.defs(sw)
+		}
+	}
+
+	sw.str(`</defs>`)
+	sw.printf(`<rect width="%d" height="%d" fill="#fff"/>`, width, height)
+}
+
+// Arrowhead shapes (ArrowSpec.Head)
+const (
+	HeadChevron  = "chevron"  // Open two-line chevron (default)
+	HeadTriangle = "triangle" // Filled triangle
+	HeadHollow   = "hollow"   // Hollow triangle (inheritance)
+	HeadDiamond  = "diamond"  // Filled diamond (composition)
+	HeadCircle   = "circle"   // Hollow circle
+	HeadBar      = "bar"      // Perpendicular bar
+)
+
+// arrowheadShape describes a marker drawing pointing right (+x) with its tip at x = tip.
+// draw receives a mapping for x coordinates so the same shape can be mirrored for start markers.
+type arrowheadShape struct {
+	tip  float64
+	draw func(sw *svgWriter, x func(float64) float64, color string)
+}
+
+// arrowheadShapes defines every supported arrowhead, in marker units (viewBox "-1 -1 14 13")
+var arrowheadShapes = map[string]arrowheadShape{
+	HeadChevron: {tip: 8, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<polyline points="%g 0.5, %g 5.5, %g 10.4" fill="none" stroke="%s" stroke-width="1.5" stroke-linejoin="miter"/>`,
+			x(0), x(8), x(0), color)
+	}},
+	HeadTriangle: {tip: 10, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<polygon points="%g 0.5, %g 5.5, %g 10.5" fill="%s" stroke="%s" stroke-width="1"/>`,
+			x(0), x(10), x(0), color, color)
+	}},
+	HeadHollow: {tip: 10, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<polygon points="%g 0.5, %g 5.5, %g 10.5" fill="#fff" stroke="%s" stroke-width="1.2"/>`,
+			x(0.6), x(10), x(0.6), color)
+	}},
+	HeadDiamond: {tip: 12, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<polygon points="%g 5.5, %g 1.5, %g 5.5, %g 9.5" fill="%s" stroke="%s" stroke-width="1"/>`,
+			x(0), x(6), x(12), x(6), color, color)
+	}},
+	HeadCircle: {tip: 10, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<circle cx="%g" cy="5.5" r="4.4" fill="#fff" stroke="%s" stroke-width="1.2"/>`,
+			x(5), color)
+	}},
+	HeadBar: {tip: 10, draw: func(sw *svgWriter, x func(float64) float64, color string) {
+		sw.printf(`<line x1="%g" y1="0.5" x2="%g" y2="10.5" stroke="%s" stroke-width="1.5"/>`,
+			x(9.25), x(9.25), color)
+	}},
+}
+
+// isArrowheadShape reports whether name is a supported arrowhead shape
+func isArrowheadShape(name string) bool {
+	_, ok := arrowheadShapes[name]
+	return ok
+}
+
+// arrowMarker identifies a marker pair by arrowhead shape and stroke color.
+// The zero value is the default black chevron.
+type arrowMarker struct {
+	shape string // Arrowhead shape ("" = HeadChevron)
+	color string // Stroke color ("" = black)
+}
 
-	return header
+// defs writes the end marker and the mirrored start marker. End markers use the
+// tip as refX, so the tip lands exactly on the path end point, which the router
+// places strokeAdjustment pixels before the target box.
+func (m arrowMarker) defs(sw *svgWriter) {
+	shape, ok := arrowheadShapes[m.shape]
+	if !ok {
+		shape = arrowheadShapes[HeadChevron]
+	}
+	stroke := m.color
+	if stroke == "" {
+		stroke = "#000"
+	}
+	identity := func(v float64) float64 { return v }
+	mirror := func(v float64) float64 { return shape.tip - v }
+	sw.printf(`<marker id="%s" markerWidth="12" markerHeight="13" refX="%g" refY="5.5" orient="auto" viewBox="-1 -1 14 13">`,
+		m.id(false), shape.tip)
+	shape.draw(sw, identity, stroke)
+	sw.str(`</marker>`)
+	sw.printf(`<marker id="%s" markerWidth="12" markerHeight="13" refX="0" refY="5.5" orient="auto" viewBox="-1 -1 14 13">`,
+		m.id(true))
+	shape.draw(sw, mirror, stroke)
+	sw.str(`</marker>`)
+}
+
+// id derives the marker ID: "arrowhead[-start][-shape][-color]", e.g.
+// "arrowhead", "arrowhead-start" or "arrowhead-diamond-FF0000"
+func (m arrowMarker) id(start bool) string {
+	id := "arrowhead"
+	if start {
+		id += "-start"
+	}
+	if m.shape != "" && m.shape != HeadChevron {
+		id += "-" + m.shape
+	}
+	var suffix strings.Builder
+	for _, r := range m.color {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			suffix.WriteRune(r)
+		}
+	}
+	if suffix.Len() > 0 {
+		id += "-" + suffix.String()
+	}
+	return id
 }
 
-// svgFooter returns the closing SVG tag
-func svgFooter() string {
-	return `</svg>`
+// writeSVGFooter writes the closing SVG tag
+func writeSVGFooter(sw *svgWriter) {
+	sw.str(`</svg>`)
 }
 
 // sanitizeFontName removes characters that could break CSS font-family declarations
@@ -59,63 +213,275 @@ func getFontFamily(customFont *FontData) string {
 	return fallbacks
 }
 
-// straightArrow generates a single-line arrow
-func straightArrow(fromX, fromY, toX, toY int) string {
-	return fmt.Sprintf(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#000" stroke-width="2" marker-end="url(#arrowhead)"/>`,
-		fromX, fromY, toX, toY)
+// arrowStyle holds the per-arrow rendering attributes shared by the arrow emitters
+type arrowStyle struct {
+	startHead bool   // Draw an arrowhead at the start point
+	endHead   bool   // Draw an arrowhead at the end point
+	color     string // Stroke color ("" = black)
+	width     int    // Stroke width (0 = 2)
+	dash      string // ArrowDashed, ArrowDotted or "" for solid
+	head      string // Arrowhead shape ("" = HeadChevron)
+	shape     string // ArrowRounded, ArrowCurved or "" for sharp corners
 }
 
-// oneBentArrow generates a 2-segment L-shaped arrow
+// defaultArrowStyle is a plain arrow with a head at its end
+var defaultArrowStyle = arrowStyle{endHead: true}
+
+// attrs writes the stroke and marker attributes for an arrow element
+func (s arrowStyle) attrs(sw *svgWriter) {
+	color := s.color
+	if color == "" {
+		color = "#000"
+	}
+	width := s.width
+	if width == 0 {
+		width = 2
+	}
+	sw.printf(`stroke="%s" stroke-width="%d"`, color, width)
+	switch s.dash {
+	case ArrowDashed:
+		sw.str(` stroke-dasharray="8,5"`)
+	case ArrowDotted:
+		sw.str(` stroke-dasharray="2,4" stroke-linecap="round"`)
+	}
+	marker := arrowMarker{shape: s.head, color: s.color}
+	if s.startHead {
+		sw.printf(` marker-start="url(#%s)"`, marker.id(true))
+	}
+	if s.endHead {
+		sw.printf(` marker-end="url(#%s)"`, marker.id(false))
+	}
+}
+
+// arrowCornerRadius is the largest radius of the bends of rounded arrows
+const arrowCornerRadius = 10
+
+// straightArrow writes a single-line arrow
+func straightArrow(sw *svgWriter, fromX, fromY, toX, toY int, style arrowStyle) {
+	arrowPath(sw, []int{fromX, fromY, toX, toY}, nil, style)
+}
+
+// oneBentArrow writes a 2-segment L-shaped arrow
 // If verticalFirst=true: vertical then horizontal
 // If verticalFirst=false: horizontal then vertical
-func oneBentArrow(fromX, fromY, toX, toY int, verticalFirst bool) string {
+func oneBentArrow(sw *svgWriter, fromX, fromY, toX, toY int, verticalFirst bool, style arrowStyle) {
 	if verticalFirst {
-		// Vertical then horizontal
-		return fmt.Sprintf(`<polyline points="%d,%d %d,%d %d,%d" fill="none" stroke="#000" stroke-width="2" marker-end="url(#arrowhead)"/>`,
-			fromX, fromY, // Start point
-			fromX, toY, // Vertical to target Y
-			toX, toY) // Horizontal to end point
+		// Vertical to target Y, then horizontal to end point
+		arrowPath(sw, []int{fromX, fromY, fromX, toY, toX, toY}, nil, style)
+		return
 	}
-	// Horizontal then vertical
-	return fmt.Sprintf(`<polyline points="%d,%d %d,%d %d,%d" fill="none" stroke="#000" stroke-width="2" marker-end="url(#arrowhead)"/>`,
-		fromX, fromY, // Start point
-		toX, fromY, // Horizontal to target X
-		toX, toY) // Vertical to end point
+	// Horizontal to target X, then vertical to end point
+	arrowPath(sw, []int{fromX, fromY, toX, fromY, toX, toY}, nil, style)
 }
 
-// twoBentArrow generates a 3-segment arrow (horizontal, vertical, horizontal)
-func twoBentArrow(fromX, fromY, toX, toY int) string {
+// twoBentArrow writes a 3-segment arrow (horizontal, vertical, horizontal)
+func twoBentArrow(sw *svgWriter, fromX, fromY, toX, toY int, style arrowStyle) {
 	midX := (fromX + toX) / 2
-	return fmt.Sprintf(`<polyline points="%d,%d %d,%d %d,%d %d,%d" fill="none" stroke="#000" stroke-width="2" marker-end="url(#arrowhead)"/>`,
-		fromX, fromY, // Start point
-		midX, fromY, // Horizontal to midpoint
-		midX, toY, // Vertical to target Y
-		toX, toY) // Horizontal to end point
+	arrowPath(sw, []int{fromX, fromY, midX, fromY, midX, toY, toX, toY}, nil, style)
 }
 
-// twoBentArrowVertical generates a 3-segment arrow (vertical, horizontal, vertical)
-func twoBentArrowVertical(fromX, fromY, toX, toY int) string {
+// twoBentArrowVertical writes a 3-segment arrow (vertical, horizontal, vertical)
+func twoBentArrowVertical(sw *svgWriter, fromX, fromY, toX, toY int, style arrowStyle) {
 	midY := (fromY + toY) / 2
-	return fmt.Sprintf(`<polyline points="%d,%d %d,%d %d,%d %d,%d" fill="none" stroke="#000" stroke-width="2" marker-end="url(#arrowhead)"/>`,
-		fromX, fromY, // Start point
-		fromX, midY, // Vertical to midpoint
-		toX, midY, // Horizontal to target X
-		toX, toY) // Vertical to end point
+	arrowPath(sw, []int{fromX, fromY, fromX, midY, toX, midY, toX, toY}, nil, style)
 }
 
-// drawGroup generates a dashed rounded rectangle with an optional label
-func drawGroup(x, y, width, height int, label string, font *FontData) string {
-	result := fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#000" stroke-width="1.5" stroke-dasharray="6,4" rx="8"/>`,
+// arrowPath writes an arrow along an orthogonal polyline [x0,y0,x1,y1,...]
+// in the style's line shape. Sharp and rounded arrows jump over the given
+// crossing points [x0,y0,...] with semicircular bridges.
+func arrowPath(sw *svgWriter, points, crossings []int, style arrowStyle) {
+	sw.str(`<path d="`)
+	if style.shape == ArrowCurved {
+		curvedPathData(sw, points)
+	} else {
+		radius := 0
+		if style.shape == ArrowRounded {
+			radius = arrowCornerRadius
+		}
+		orthogonalPathData(sw, points, crossings, radius)
+	}
+	sw.str(`" fill="none" `)
+	style.attrs(sw)
+	sw.str(`/>`)
+}
+
+// orthogonalPathData writes path data along an orthogonal polyline with bends
+// rounded up to radius (0 for sharp corners). Crossings become bridges bulging
+// upwards on horizontal and to the right on vertical segments; crossings too
+// close to a bend or to the previous bridge are drawn plain.
+func orthogonalPathData(sw *svgWriter, points, crossings []int, radius int) {
+	n := len(points) / 2
+	// Corner radius at each point: no larger than half of either adjacent segment
+	radii := make([]int, n)
+	for k := 1; k < n-1; k++ {
+		in := abs(points[2*k]-points[2*k-2]) + abs(points[2*k+1]-points[2*k-1])
+		out := abs(points[2*k+2]-points[2*k]) + abs(points[2*k+3]-points[2*k+1])
+		radii[k] = min(radius, in/2, out/2)
+	}
+
+	sw.printf("M%d,%d", points[0], points[1])
+	for i := 0; i+3 < len(points); i += 2 {
+		x1, y1, x2, y2 := points[i], points[i+1], points[i+2], points[i+3]
+		dx, dy := sign(x2-x1), sign(y2-y1)
+		length := abs(x2-x1) + abs(y2-y1)
+		startRadius, endRadius := radii[i/2], radii[i/2+1]
+
+		// Distances of the crossings on this segment from its start, in travel order
+		var hops []int
+		for j := 0; j+1 < len(crossings); j += 2 {
+			cx, cy := crossings[j], crossings[j+1]
+			if (dx == 0 && dy != 0 && cx == x1 && between(cy, y1, y2)) || (dy == 0 && dx != 0 && cy == y1 && between(cx, x1, x2)) {
+				hops = append(hops, abs(cx-x1)+abs(cy-y1))
+			}
+		}
+		sort.Ints(hops)
+
+		sweep := 0
+		if dx > 0 || dy > 0 {
+			sweep = 1
+		}
+		last := startRadius // End of the previous bridge or corner along the segment
+		for _, dist := range hops {
+			if dist-hopRadius < last || dist+hopRadius > length-endRadius {
+				continue
+			}
+			sw.printf(" L%d,%d A%d,%d 0 0 %d %d,%d",
+				x1+dx*(dist-hopRadius), y1+dy*(dist-hopRadius),
+				hopRadius, hopRadius, sweep,
+				x1+dx*(dist+hopRadius), y1+dy*(dist+hopRadius))
+			last = dist + hopRadius
+		}
+
+		if endRadius == 0 {
+			sw.printf(" L%d,%d", x2, y2)
+			continue
+		}
+		// Quarter circle around the bend, turning towards the next segment
+		nx, ny := sign(points[i+4]-x2), sign(points[i+5]-y2)
+		turn := 0
+		if dx*ny-dy*nx > 0 {
+			turn = 1 // clockwise on screen
+		}
+		if length-endRadius > last {
+			sw.printf(" L%d,%d", x2-dx*endRadius, y2-dy*endRadius)
+		}
+		sw.printf(" A%d,%d 0 0 %d %d,%d", endRadius, endRadius, turn, x2+nx*endRadius, y2+ny*endRadius)
+	}
+}
+
+// curvedPathData writes path data for a smooth curve along a polyline. The
+// curve runs from the start through the middle of every inner segment to the
+// end, with one cubic Bézier per bend that uses the bend as control point.
+// It leaves and enters the route's end segments in their direction, so
+// arrowheads keep pointing into the box.
+func curvedPathData(sw *svgWriter, points []int) {
+	n := len(points) / 2
+	sw.printf("M%d,%d", points[0], points[1])
+	if n < 3 {
+		sw.printf(" L%d,%d", points[2], points[3])
+		return
+	}
+	ax, ay := points[0], points[1]
+	for k := 1; k < n-1; k++ {
+		cx, cy := points[2*k], points[2*k+1]
+		bx, by := points[2*k+2], points[2*k+3]
+		if k < n-2 {
+			bx, by = (cx+bx)/2, (cy+by)/2
+		}
+		// The bend as quadratic control point, raised to a cubic curve
+		sw.printf(" C%d,%d %d,%d %d,%d",
+			ax+2*(cx-ax)/3, ay+2*(cy-ay)/3,
+			bx+2*(cx-bx)/3, by+2*(cy-by)/3,
+			bx, by)
+		ax, ay = bx, by
+	}
+}
+
+// sign returns -1, 0 or 1 according to the sign of v
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
+
+// drawJunction writes the dot where a merged arrow trunk splits
+func drawJunction(sw *svgWriter, x, y int, color string) {
+	if color == "" {
+		color = "#000"
+	}
+	sw.printf(`<circle cx="%d" cy="%d" r="%d" fill="%s"/>`, x, y, junctionRadius, color)
+}
+
+// drawArrowLabel writes centered arrow label text with a white halo,
+// so the label stays readable on top of the arrow line
+func drawArrowLabel(sw *svgWriter, x, y int, label string, font *FontData) {
+	attrs := map[string]string{
+		"text-anchor":       "middle",
+		"dominant-baseline": "middle",
+		"stroke":            "#fff",
+		"stroke-width":      "4",
+		"stroke-linejoin":   "round",
+		"paint-order":       "stroke",
+	}
+	drawText(sw, x, y, label, arrowLabelFontSize, attrs, font)
+}
+
+// drawGroup writes a dashed rounded rectangle with an optional label
+func drawGroup(sw *svgWriter, x, y, width, height int, label string, font *FontData) {
+	sw.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#000" stroke-width="1.5" stroke-dasharray="6,4" rx="8"/>`,
 		x, y, width, height)
 	if label != "" {
 		attrs := map[string]string{}
-		result += drawText(x+10, y+26, label, 24, attrs, font)
+		drawText(sw, x+10, y+26, label, 24, attrs, font)
 	}
-	return result
 }
 
-// drawBox generates an SVG rectangle
-func drawBox(x, y, width, height int, fillColor, strokeColor string, strokeWidth int) string {
+// drawContainer writes a container frame with an optional title
+func drawContainer(sw *svgWriter, x, y, width, height int, label string, styles ContainerStyles, font *FontData) {
+	// Use defaults if not specified
+	fillColor := styles.FillColor
+	if fillColor == "" {
+		fillColor = "none"
+	}
+	strokeColor := styles.BorderColor
+	if strokeColor == "" {
+		strokeColor = "#555"
+	}
+	strokeWidth := styles.BorderWidth
+	if strokeWidth == 0 {
+		strokeWidth = 2
+	}
+	dashAttr := ""
+	if styles.Dashed {
+		dashAttr = ` stroke-dasharray="8,4"`
+	}
+	sw.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="%d"%s rx="4"/>`,
+		x, y, width, height, fillColor, strokeColor, strokeWidth, dashAttr)
+
+	if label != "" {
+		attrs := map[string]string{}
+		textX := x + 10
+		switch styles.TitleAlign {
+		case "center":
+			textX = x + width/2
+			attrs["text-anchor"] = "middle"
+		case "right":
+			textX = x + width - 10
+			attrs["text-anchor"] = "end"
+		}
+		if styles.TextColor != "" {
+			attrs["fill"] = styles.TextColor
+		}
+		drawText(sw, textX, y+26, label, 24, attrs, font)
+	}
+}
+
+// drawBox writes an SVG rectangle
+func drawBox(sw *svgWriter, x, y, width, height int, fillColor, strokeColor string, strokeWidth int) {
 	// Use defaults if not specified
 	if strokeColor == "" {
 		strokeColor = "#000"
@@ -123,12 +489,12 @@ func drawBox(x, y, width, height int, fillColor, strokeColor string, strokeWidth
 	if strokeWidth == 0 {
 		strokeWidth = 2
 	}
-	return fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="%d"/>`,
+	sw.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="%d"/>`,
 		x, y, width, height, fillColor, strokeColor, strokeWidth)
 }
 
-// drawLine generates an SVG line, optionally dashed
-func drawLine(x1, y1, x2, y2 int, dashed bool, withArrow bool) string {
+// drawLine writes an SVG line, optionally dashed
+func drawLine(sw *svgWriter, x1, y1, x2, y2 int, dashed bool, withArrow bool) {
 	dashAttr := ""
 	if dashed {
 		dashAttr = ` stroke-dasharray="5,5"`
@@ -137,35 +503,24 @@ func drawLine(x1, y1, x2, y2 int, dashed bool, withArrow bool) string {
 	if withArrow {
 		arrowAttr = ` marker-end="url(#arrowhead)"`
 	}
-	return fmt.Sprintf(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#000" stroke-width="2"%s%s/>`,
+	sw.printf(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#000" stroke-width="2"%s%s/>`,
 		x1, y1, x2, y2, dashAttr, arrowAttr)
 }
 
-// drawText generates SVG text with optional attributes
-func drawText(x, y int, text string, fontSize int, attrs map[string]string, font *FontData) string {
-	attrStr := ""
-	for key, val := range attrs {
-		attrStr += fmt.Sprintf(` %s="%s"`, key, val)
-	}
-
-	fontFamily := getFontFamily(font)
-
-	// Sanitize text to prevent XSS
-	buffer := &bytes.Buffer{}
-	if err := xml.EscapeText(buffer, []byte(text)); err != nil {
-		// This should not happen with valid UTF-8 strings, but handle it defensively
-		// Replace with a placeholder to avoid injecting potentially broken XML
-		return fmt.Sprintf(`<text x="%d" y="%d" font-family="%s" font-size="%d"%s>[error]</text>`,
-			x, y, fontFamily, fontSize, attrStr)
+// drawText writes SVG text with optional attributes, in sorted order so the
+// output is reproducible
+func drawText(sw *svgWriter, x, y int, text string, fontSize int, attrs map[string]string, font *FontData) {
+	sw.printf(`<text x="%d" y="%d" font-family="%s" font-size="%d"`, x, y, getFontFamily(font), fontSize)
+	for _, key := range slices.Sorted(maps.Keys(attrs)) {
+		sw.printf(` %s="%s"`, key, attrs[key])
 	}
-	sanitizedText := buffer.String()
-
-	return fmt.Sprintf(`<text x="%d" y="%d" font-family="%s" font-size="%d"%s>%s</text>`,
-		x, y, fontFamily, fontSize, attrStr, sanitizedText)
+	sw.str(`>`)
+	sw.text(text) // Escaped to prevent XSS
+	sw.str(`</text>`)
 }
 
-// drawBoxText generates multi-line centered text for a box
-func drawBoxText(x, y, width, height, fontSize int, textColor string, lines []string, font *FontData) string {
+// drawBoxText writes multi-line centered text for a box
+func drawBoxText(sw *svgWriter, x, y, width, height, fontSize int, textColor string, lines []string, font *FontData) {
 	// Use default font size if not specified
 	if fontSize == 0 {
 		fontSize = 24
@@ -173,7 +528,6 @@ func drawBoxText(x, y, width, height, fontSize int, textColor string, lines []st
 	// Line height is proportional to font size (default: 28 for font size 24)
 	lineHeight := fontSize * 28 / 24
 	startY := y + height/2 - (len(lines)-1)*lineHeight/2
-	result := ""
 	for i, line := range lines {
 		attrs := map[string]string{
 			"font-weight":       "normal",
@@ -184,7 +538,6 @@ func drawBoxText(x, y, width, height, fontSize int, textColor string, lines []st
 		if textColor != "" {
 			attrs["fill"] = textColor
 		}
-		result += drawText(x+width/2, startY+i*lineHeight, line, fontSize, attrs, font)
+		drawText(sw, x+width/2, startY+i*lineHeight, line, fontSize, attrs, font)
 	}
-	return result
 }
@@ -1,12 +1,24 @@
-package main
+package control
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 )
 
+// emit returns what draw writes to an svgWriter
+func emit(draw func(sw *svgWriter)) string {
+	var out strings.Builder
+	sw := newSVGWriter(&out)
+	draw(sw)
+	_ = sw.flush() // Writing to a strings.Builder cannot fail
+	return out.String()
+}
+
 func TestSvgHeader(t *testing.T) {
-	header := svgHeader(800, 600, nil)
+	header := emit(func(sw *svgWriter) { writeSVGHeader(sw, 800, 600, nil, nil) })
 
 	if !strings.Contains(header, `width="800"`) {
 		t.Error("Header should contain width=\"800\"")
@@ -26,7 +38,7 @@ func TestSvgHeader(t *testing.T) {
 }
 
 func TestSvgHeader_ArrowheadStyle(t *testing.T) {
-	header := svgHeader(800, 600, nil)
+	header := emit(func(sw *svgWriter) { writeSVGHeader(sw, 800, 600, nil, nil) })
 
 	// Should use polyline instead of polygon for two-line arrowhead
 	if !strings.Contains(header, `<polyline`) {
@@ -55,7 +67,7 @@ func TestSvgHeader_ArrowheadStyle(t *testing.T) {
 }
 
 func TestSvgFooter(t *testing.T) {
-	footer := svgFooter()
+	footer := emit(func(sw *svgWriter) { writeSVGFooter(sw) })
 
 	if footer != `</svg>` {
 		t.Errorf("Footer should be </svg>, got %s", footer)
@@ -63,26 +75,14 @@ func TestSvgFooter(t *testing.T) {
 }
 
 func TestStraightArrow(t *testing.T) {
-	arrow := straightArrow(10, 20, 30, 40)
-
-	if !strings.Contains(arrow, `<line`) {
-		t.Error("Should be a line element")
-	}
-
-	if !strings.Contains(arrow, `x1="10"`) {
-		t.Error("Should have x1=\"10\"")
-	}
-
-	if !strings.Contains(arrow, `y1="20"`) {
-		t.Error("Should have y1=\"20\"")
-	}
+	arrow := emit(func(sw *svgWriter) { straightArrow(sw, 10, 20, 30, 40, defaultArrowStyle) })
 
-	if !strings.Contains(arrow, `x2="30"`) {
-		t.Error("Should have x2=\"30\"")
+	if !strings.Contains(arrow, `<path`) {
+		t.Error("Should be a path element")
 	}
 
-	if !strings.Contains(arrow, `y2="40"`) {
-		t.Error("Should have y2=\"40\"")
+	if !strings.Contains(arrow, `d="M10,20 L30,40"`) {
+		t.Errorf("Should run from 10,20 to 30,40, got %s", arrow)
 	}
 
 	if !strings.Contains(arrow, `marker-end="url(#arrowhead)"`) {
@@ -90,103 +90,202 @@ func TestStraightArrow(t *testing.T) {
 	}
 }
 
-func TestOneBentArrow(t *testing.T) {
-	// Test vertical-first (vertical then horizontal)
-	arrowVertFirst := oneBentArrow(10, 20, 30, 40, true)
-
-	if !strings.Contains(arrowVertFirst, `<polyline`) {
-		t.Error("Should be a polyline element")
+func TestArrowStyle_Markers(t *testing.T) {
+	tests := []struct {
+		name      string
+		style     arrowStyle
+		wantStart bool
+		wantEnd   bool
+	}{
+		{"forward", defaultArrowStyle, false, true},
+		{"bidirectional", arrowStyle{startHead: true, endHead: true}, true, true},
+		{"undirected", arrowStyle{}, false, false},
 	}
 
-	// Check for 3 points (vertical then horizontal)
-	if !strings.Contains(arrowVertFirst, `points="10,20`) {
-		t.Error("Should start at 10,20")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emitted := []string{
+				emit(func(sw *svgWriter) { straightArrow(sw, 10, 20, 30, 20, tt.style) }),
+				emit(func(sw *svgWriter) { oneBentArrow(sw, 10, 20, 30, 40, true, tt.style) }),
+				emit(func(sw *svgWriter) { twoBentArrow(sw, 10, 20, 50, 60, tt.style) }),
+				emit(func(sw *svgWriter) { twoBentArrowVertical(sw, 10, 20, 50, 60, tt.style) }),
+			}
+			for _, arrow := range emitted {
+				if got := strings.Contains(arrow, `marker-start="url(#arrowhead-start)"`); got != tt.wantStart {
+					t.Errorf("marker-start present=%v, want %v: %s", got, tt.wantStart, arrow)
+				}
+				if got := strings.Contains(arrow, `marker-end="url(#arrowhead)"`); got != tt.wantEnd {
+					t.Errorf("marker-end present=%v, want %v: %s", got, tt.wantEnd, arrow)
+				}
+			}
+		})
 	}
+}
 
-	if !strings.Contains(arrowVertFirst, `10,40`) {
-		t.Error("Should have vertical segment to 10,40")
+func TestArrowStyle_LineStyles(t *testing.T) {
+	arrow := emit(func(sw *svgWriter) {
+		straightArrow(sw, 10, 20, 30, 20, arrowStyle{endHead: true, color: "#FF0000", width: 4, dash: ArrowDashed})
+	})
+	for _, want := range []string{
+		`stroke="#FF0000"`,
+		`stroke-width="4"`,
+		`stroke-dasharray="8,5"`,
+		`marker-end="url(#arrowhead-FF0000)"`,
+	} {
+		if !strings.Contains(arrow, want) {
+			t.Errorf("Expected %s in %s", want, arrow)
+		}
+	}
+
+	dotted := emit(func(sw *svgWriter) { straightArrow(sw, 10, 20, 30, 20, arrowStyle{dash: ArrowDotted}) })
+	if !strings.Contains(dotted, `stroke-dasharray="2,4"`) || !strings.Contains(dotted, `stroke="#000"`) {
+		t.Errorf("Expected dotted black line, got %s", dotted)
 	}
+}
 
-	if !strings.Contains(arrowVertFirst, `30,40"`) {
-		t.Error("Should end at 30,40")
+func TestSvgHeader_ColoredMarkers(t *testing.T) {
+	header := emit(func(sw *svgWriter) { writeSVGHeader(sw, 800, 600, nil, []arrowMarker{{color: "#FF0000"}}) })
+	for _, want := range []string{
+		`<marker id="arrowhead"`,
+		`<marker id="arrowhead-FF0000"`,
+		`<marker id="arrowhead-start-FF0000"`,
+		`stroke="#FF0000" stroke-width="1.5"`,
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("Expected %s in header", want)
+		}
 	}
+}
 
-	if !strings.Contains(arrowVertFirst, `marker-end="url(#arrowhead)"`) {
-		t.Error("Should have arrowhead marker")
+func TestGenerateSVG_OneMarkerPerColor(t *testing.T) {
+	d := newDiagram(400, 300)
+	for i := 0; i < 3; i++ {
+		d.AddArrow(10, 20+i*50, 200, 20+i*50, false, 1, "a", "b", "straight", nil).Color = "#FF0000"
 	}
+	d.AddArrow(10, 250, 200, 250, false, 1, "a", "b", "straight", nil).Color = "#3B82F6"
 
-	// Test horizontal-first (horizontal then vertical)
-	arrowHorizFirst := oneBentArrow(10, 20, 30, 40, false)
-
-	if !strings.Contains(arrowHorizFirst, `<polyline`) {
-		t.Error("Should be a polyline element")
+	svg := d.GenerateSVG()
+	if n := strings.Count(svg, `<marker id="arrowhead-FF0000"`); n != 1 {
+		t.Errorf("Expected 1 red marker, got %d", n)
+	}
+	if n := strings.Count(svg, `<marker id="arrowhead-3B82F6"`); n != 1 {
+		t.Errorf("Expected 1 blue marker, got %d", n)
 	}
+	if n := strings.Count(svg, `marker-end="url(#arrowhead-FF0000)"`); n != 3 {
+		t.Errorf("Expected 3 arrows using the red marker, got %d", n)
+	}
+}
 
-	// Check for 3 points (horizontal then vertical)
-	if !strings.Contains(arrowHorizFirst, `points="10,20`) {
-		t.Error("Should start at 10,20")
+func TestArrowMarker_Shapes(t *testing.T) {
+	tests := []struct {
+		shape   string
+		id      string
+		refX    string
+		element string
+	}{
+		{HeadChevron, "arrowhead", `refX="8"`, "<polyline"},
+		{HeadTriangle, "arrowhead-triangle", `refX="10"`, "<polygon"},
+		{HeadHollow, "arrowhead-hollow", `refX="10"`, `fill="#fff"`},
+		{HeadDiamond, "arrowhead-diamond", `refX="12"`, "<polygon"},
+		{HeadCircle, "arrowhead-circle", `refX="10"`, "<circle"},
+		{HeadBar, "arrowhead-bar", `refX="10"`, "<line"},
 	}
 
-	if !strings.Contains(arrowHorizFirst, `30,20`) {
-		t.Error("Should have horizontal segment to 30,20")
+	for _, tt := range tests {
+		t.Run(tt.shape, func(t *testing.T) {
+			defs := emit(arrowMarker{shape: tt.shape}.defs)
+			end, start, found := strings.Cut(defs, "</marker>")
+			if !found {
+				t.Fatalf("Expected two markers, got %s", defs)
+			}
+			if !strings.Contains(end, `<marker id="`+tt.id+`"`) || !strings.Contains(end, tt.refX) {
+				t.Errorf("End marker should have id %s and %s: %s", tt.id, tt.refX, end)
+			}
+			if !strings.Contains(end, tt.element) {
+				t.Errorf("End marker should contain %s: %s", tt.element, end)
+			}
+			startID := strings.Replace(tt.id, "arrowhead", "arrowhead-start", 1)
+			if !strings.Contains(start, `<marker id="`+startID+`"`) || !strings.Contains(start, `refX="0"`) {
+				t.Errorf("Start marker should have id %s and refX=0: %s", startID, start)
+			}
+		})
 	}
 
-	if !strings.Contains(arrowHorizFirst, `30,40"`) {
-		t.Error("Should end at 30,40")
+	if got := (arrowMarker{shape: HeadDiamond, color: "#FF0000"}).id(true); got != "arrowhead-start-diamond-FF0000" {
+		t.Errorf("Expected arrowhead-start-diamond-FF0000, got %s", got)
 	}
 }
 
-func TestTwoBentArrow(t *testing.T) {
-	arrow := twoBentArrow(10, 20, 50, 60)
+func TestGenerateSVG_ArrowheadShapes(t *testing.T) {
+	d := newDiagram(400, 300)
+	d.AddArrow(10, 20, 200, 20, false, 1, "a", "b", "straight", nil).Head = HeadDiamond
+	d.AddArrow(10, 70, 200, 70, false, 1, "a", "b", "straight", nil).Head = HeadDiamond
+	undirected := d.AddArrow(10, 120, 200, 120, false, 1, "a", "b", "straight", nil)
+	undirected.Head, undirected.EndHead = HeadBar, false
 
-	if !strings.Contains(arrow, `<polyline`) {
-		t.Error("Should be a polyline element")
+	svg := d.GenerateSVG()
+	if n := strings.Count(svg, `<marker id="arrowhead-diamond"`); n != 1 {
+		t.Errorf("Expected 1 diamond marker, got %d", n)
 	}
-
-	// Check for 4 points
-	midX := (10 + 50) / 2 // 30
-
-	if !strings.Contains(arrow, `points="10,20`) {
-		t.Error("Should start at 10,20")
+	if strings.Contains(svg, `arrowhead-bar`) {
+		t.Error("Arrows without heads should not define markers")
 	}
-
-	if !strings.Contains(arrow, `30,20`) {
-		t.Errorf("Should have horizontal segment to %d,20", midX)
+	if n := strings.Count(svg, `marker-end="url(#arrowhead-diamond)"`); n != 2 {
+		t.Errorf("Expected 2 arrows using the diamond marker, got %d", n)
 	}
+}
 
-	if !strings.Contains(arrow, `30,60`) {
-		t.Errorf("Should have vertical segment to %d,60", midX)
+func TestSvgHeader_StartMarker(t *testing.T) {
+	header := emit(func(sw *svgWriter) { writeSVGHeader(sw, 800, 600, nil, nil) })
+	if !strings.Contains(header, `<marker id="arrowhead-start"`) {
+		t.Error("Header should define the mirrored start marker")
 	}
-
-	if !strings.Contains(arrow, `50,60"`) {
-		t.Error("Should end at 50,60")
+	if !strings.Contains(header, `points="8 0.5, 0 5.5, 8 10.4"`) {
+		t.Error("Start marker should point backwards")
 	}
 }
 
-func TestTwoBentArrowVertical(t *testing.T) {
-	arrow := twoBentArrowVertical(10, 20, 50, 60)
+func TestOneBentArrow(t *testing.T) {
+	// Test vertical-first (vertical then horizontal)
+	arrowVertFirst := emit(func(sw *svgWriter) { oneBentArrow(sw, 10, 20, 30, 40, true, defaultArrowStyle) })
 
-	if !strings.Contains(arrow, `<polyline`) {
-		t.Error("Should be a polyline element")
+	if !strings.Contains(arrowVertFirst, `<path`) {
+		t.Error("Should be a path element")
 	}
 
-	// Check for 4 points (V-H-V path)
-	midY := (20 + 60) / 2 // 40
+	// Vertical segment to 10,40, then horizontal to 30,40
+	if !strings.Contains(arrowVertFirst, `d="M10,20 L10,40 L30,40"`) {
+		t.Errorf("Should bend at 10,40, got %s", arrowVertFirst)
+	}
 
-	if !strings.Contains(arrow, `points="10,20`) {
-		t.Error("Should start at 10,20")
+	if !strings.Contains(arrowVertFirst, `marker-end="url(#arrowhead)"`) {
+		t.Error("Should have arrowhead marker")
 	}
 
-	if !strings.Contains(arrow, `10,40`) {
-		t.Errorf("Should have vertical segment to 10,%d", midY)
+	// Test horizontal-first (horizontal then vertical)
+	arrowHorizFirst := emit(func(sw *svgWriter) { oneBentArrow(sw, 10, 20, 30, 40, false, defaultArrowStyle) })
+
+	// Horizontal segment to 30,20, then vertical to 30,40
+	if !strings.Contains(arrowHorizFirst, `d="M10,20 L30,20 L30,40"`) {
+		t.Errorf("Should bend at 30,20, got %s", arrowHorizFirst)
 	}
+}
+
+func TestTwoBentArrow(t *testing.T) {
+	arrow := emit(func(sw *svgWriter) { twoBentArrow(sw, 10, 20, 50, 60, defaultArrowStyle) })
 
-	if !strings.Contains(arrow, `50,40`) {
-		t.Errorf("Should have horizontal segment to 50,%d", midY)
+	// Horizontal to midX 30, vertical to 60, horizontal to the end
+	if !strings.Contains(arrow, `d="M10,20 L30,20 L30,60 L50,60"`) {
+		t.Errorf("Should bend at the middle X, got %s", arrow)
 	}
+}
 
-	if !strings.Contains(arrow, `50,60"`) {
-		t.Error("Should end at 50,60")
+func TestTwoBentArrowVertical(t *testing.T) {
+	arrow := emit(func(sw *svgWriter) { twoBentArrowVertical(sw, 10, 20, 50, 60, defaultArrowStyle) })
+
+	// Vertical to midY 40, horizontal to 50, vertical to the end
+	if !strings.Contains(arrow, `d="M10,20 L10,40 L50,40 L50,60"`) {
+		t.Errorf("Should bend at the middle Y, got %s", arrow)
 	}
 
 	if !strings.Contains(arrow, `marker-end="url(#arrowhead)"`) {
@@ -195,7 +294,7 @@ func TestTwoBentArrowVertical(t *testing.T) {
 }
 
 func TestDrawBox(t *testing.T) {
-	box := drawBox(10, 20, 100, 50, "#FFCE33", "", 0)
+	box := emit(func(sw *svgWriter) { drawBox(sw, 10, 20, 100, 50, "#FFCE33", "", 0) })
 
 	if !strings.Contains(box, `<rect`) {
 		t.Error("Should be a rect element")
@@ -222,6 +321,64 @@ func TestDrawBox(t *testing.T) {
 	}
 }
 
+func TestDrawContainer(t *testing.T) {
+	frame := emit(func(sw *svgWriter) { drawContainer(sw, 10, 20, 300, 200, "Billing", ContainerStyles{}, nil) })
+	if !strings.Contains(frame, `fill="none"`) || !strings.Contains(frame, `stroke="#555"`) {
+		t.Errorf("Expected default unfilled gray frame, got %s", frame)
+	}
+	if strings.Contains(frame, "stroke-dasharray") {
+		t.Error("Default frame should be solid")
+	}
+	if !strings.Contains(frame, `<text x="20" y="46"`) || !strings.Contains(frame, ">Billing</text>") {
+		t.Errorf("Expected left-aligned title, got %s", frame)
+	}
+
+	styled := emit(func(sw *svgWriter) {
+		drawContainer(sw, 10, 20, 300, 200, "Billing", ContainerStyles{
+			FillColor:  "#D3D3D3",
+			Dashed:     true,
+			TitleAlign: "right",
+		}, nil)
+	})
+	if !strings.Contains(styled, `fill="#D3D3D3"`) || !strings.Contains(styled, `stroke-dasharray="8,4"`) {
+		t.Errorf("Expected filled dashed frame, got %s", styled)
+	}
+	if !strings.Contains(styled, `<text x="300" y="46"`) || !strings.Contains(styled, `text-anchor="end"`) {
+		t.Errorf("Expected right-aligned title, got %s", styled)
+	}
+
+	untitled := emit(func(sw *svgWriter) { drawContainer(sw, 10, 20, 300, 200, "", ContainerStyles{}, nil) })
+	if strings.Contains(untitled, "<text") {
+		t.Error("Frame without label should have no text")
+	}
+}
+
+func TestDrawArrowLabel(t *testing.T) {
+	label := emit(func(sw *svgWriter) { drawArrowLabel(sw, 200, 150, "feedback & plan", nil) })
+
+	if !strings.Contains(label, `x="200"`) || !strings.Contains(label, `y="150"`) {
+		t.Errorf("Expected label at (200,150), got %s", label)
+	}
+	if !strings.Contains(label, `text-anchor="middle"`) {
+		t.Error("Label should be centered")
+	}
+	if !strings.Contains(label, `stroke="#fff"`) || !strings.Contains(label, `paint-order="stroke"`) {
+		t.Error("Label should have a white halo painted below the fill")
+	}
+	if !strings.Contains(label, ">feedback &amp; plan</text>") {
+		t.Errorf("Label text should be escaped, got %s", label)
+	}
+}
+
+func TestDrawJunction(t *testing.T) {
+	if got, want := emit(func(sw *svgWriter) { drawJunction(sw, 120, 80, "") }), `<circle cx="120" cy="80" r="4" fill="#000"/>`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if got := emit(func(sw *svgWriter) { drawJunction(sw, 120, 80, "#ff0000") }); !strings.Contains(got, `fill="#ff0000"`) {
+		t.Errorf("Expected the arrow color as fill, got %s", got)
+	}
+}
+
 func TestDrawLine(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -251,7 +408,7 @@ func TestDrawLine(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			line := drawLine(10, 20, 30, 40, tt.dashed, tt.withArrow)
+			line := emit(func(sw *svgWriter) { drawLine(sw, 10, 20, 30, 40, tt.dashed, tt.withArrow) })
 
 			for _, s := range tt.shouldContain {
 				if !strings.Contains(line, s) {
@@ -268,7 +425,7 @@ func TestDrawText(t *testing.T) {
 		"text-anchor": "middle",
 	}
 
-	text := drawText(100, 200, "Hello", 24, attrs, nil)
+	text := emit(func(sw *svgWriter) { drawText(sw, 100, 200, "Hello", 24, attrs, nil) })
 
 	if !strings.Contains(text, `<text`) {
 		t.Error("Should be a text element")
@@ -301,7 +458,7 @@ func TestDrawText(t *testing.T) {
 
 func TestDrawBoxText(t *testing.T) {
 	lines := []string{"Line 1", "Line 2"}
-	text := drawBoxText(100, 100, 200, 100, 0, "", lines, nil) // 0 means use default font size, "" means default color
+	text := emit(func(sw *svgWriter) { drawBoxText(sw, 100, 100, 200, 100, 0, "", lines, nil) }) // 0 means use default font size, "" means default color
 
 	// Should contain two text elements
 	count := strings.Count(text, "<text")
@@ -329,3 +486,141 @@ func TestDrawBoxText(t *testing.T) {
 		t.Error("Should have dominant-baseline=\"middle\" for vertical centering")
 	}
 }
+
+func TestArrowPath(t *testing.T) {
+	arrow := emit(func(sw *svgWriter) { arrowPath(sw, []int{10, 20, 10, 0, 50, 0, 50, 38}, nil, defaultArrowStyle) })
+
+	if !strings.Contains(arrow, `d="M10,20 L10,0 L50,0 L50,38"`) {
+		t.Errorf("Should draw all route points, got: %s", arrow)
+	}
+	if !strings.Contains(arrow, `fill="none"`) || !strings.Contains(arrow, `marker-end="url(#arrowhead)"`) {
+		t.Errorf("Should be an unfilled line with an end marker, got: %s", arrow)
+	}
+}
+
+func TestArrowPath_Shapes(t *testing.T) {
+	tests := []struct {
+		name      string
+		shape     string
+		points    []int
+		crossings []int
+		want      string
+	}{
+		{"sharp", ArrowSharp, []int{0, 0, 100, 0, 100, 100}, nil, `d="M0,0 L100,0 L100,100"`},
+		{"rounded clockwise bend", ArrowRounded, []int{0, 0, 100, 0, 100, 100}, nil, `d="M0,0 L90,0 A10,10 0 0 1 100,10 L100,100"`},
+		{"rounded counterclockwise bend", ArrowRounded, []int{0, 100, 100, 100, 100, 0}, nil, `d="M0,100 L90,100 A10,10 0 0 0 100,90 L100,0"`},
+		{"rounded radius limited by short segment", ArrowRounded, []int{0, 0, 100, 0, 100, 8, 200, 8}, nil, `d="M0,0 L96,0 A4,4 0 0 1 100,4 A4,4 0 0 0 104,8 L200,8"`},
+		{"rounded straight", ArrowRounded, []int{0, 0, 100, 0}, nil, `d="M0,0 L100,0"`},
+		{"rounded with hop", ArrowRounded, []int{0, 0, 100, 0, 100, 100}, []int{50, 0}, `d="M0,0 L44,0 A6,6 0 0 1 56,0 L90,0 A10,10 0 0 1 100,10 L100,100"`},
+		{"rounded hop inside corner skipped", ArrowRounded, []int{0, 0, 100, 0, 100, 100}, []int{88, 0}, `d="M0,0 L90,0 A10,10 0 0 1 100,10 L100,100"`},
+		{"curved L-shape", ArrowCurved, []int{0, 0, 90, 0, 90, 90}, nil, `d="M0,0 C60,0 90,30 90,90"`},
+		{"curved Z-shape", ArrowCurved, []int{0, 0, 60, 0, 60, 90, 120, 90}, nil, `d="M0,0 C40,0 60,15 60,45 C60,75 80,90 120,90"`},
+		{"curved straight", ArrowCurved, []int{0, 0, 100, 0}, nil, `d="M0,0 L100,0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := emit(func(sw *svgWriter) {
+				arrowPath(sw, tt.points, tt.crossings, arrowStyle{endHead: true, shape: tt.shape})
+			})
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("arrowPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrowPath_Hops(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    []int
+		crossings []int
+		want      string
+	}{
+		{"rightwards hop bulges up", []int{0, 50, 100, 50}, []int{40, 50}, `d="M0,50 L34,50 A6,6 0 0 1 46,50 L100,50"`},
+		{"upwards hop bulges right", []int{50, 100, 50, 0}, []int{50, 40}, `d="M50,100 L50,46 A6,6 0 0 0 50,34 L50,0"`},
+		{"hop too close to the bend is skipped", []int{0, 0, 100, 0, 100, 100}, []int{97, 0}, `d="M0,0 L100,0 L100,100"`},
+		{"two hops in travel order", []int{100, 0, 0, 0}, []int{20, 0, 70, 0}, `d="M100,0 L76,0 A6,6 0 0 0 64,0 L26,0 A6,6 0 0 0 14,0 L0,0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := emit(func(sw *svgWriter) { arrowPath(sw, tt.points, tt.crossings, defaultArrowStyle) })
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("arrowPath() = %s, want %s", got, tt.want)
+			}
+			if !strings.Contains(got, `marker-end="url(#arrowhead)"`) {
+				t.Errorf("Expected end marker, got %s", got)
+			}
+		})
+	}
+}
+
+func TestWriteSVG_MatchesGenerateSVG(t *testing.T) {
+	spec, err := parseDiagramSpec("A: 1,1: Alpha\nB: 4,1: Beta\nA -> B: next", nil)
+	if err != nil {
+		t.Fatalf("ParseDiagramSpec failed: %v", err)
+	}
+	diagram, _ := layout(spec, newDefaultConfig(), []LegendEntry{{Style: "g", Label: "Done"}}, nil, "")
+	diagram.Font = &FontData{Base64Data: "AAAA", FontName: "Test"}
+
+	var buf bytes.Buffer
+	if err := diagram.WriteSVG(&buf); err != nil {
+		t.Fatalf("WriteSVG failed: %v", err)
+	}
+	if buf.String() != diagram.GenerateSVG() {
+		t.Error("Expected WriteSVG to write the same bytes GenerateSVG returns")
+	}
+}
+
+// failingWriter accepts limit bytes, then fails every write
+type failingWriter struct{ limit int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteSVG_WriteError(t *testing.T) {
+	d := newDiagram(400, 300)
+	d.Font = &FontData{Base64Data: strings.Repeat("A", 64<<10), FontName: "Big"} // More than one buffer
+	d.AddBox(10, 10, 100, 50, "Box", "", "", 0, 0, "")
+
+	err := d.WriteSVG(&failingWriter{limit: 100})
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected the write error to be returned, got %v", err)
+	}
+}
+
+// benchmarkDiagram lays out a synthetic 1k-box diagram with an embedded font
+// of about 1 MB, the size of a typical WOFF2 file in base64
+func benchmarkDiagram(b *testing.B) *diagram {
+	diagram, _ := layout(syntheticSpec(b, 1000), newDefaultConfig(), nil, nil, "")
+	diagram.Font = &FontData{Base64Data: strings.Repeat("A", 1<<20), FontName: "Bench"}
+	return diagram
+}
+
+func BenchmarkGenerateSVG(b *testing.B) {
+	diagram := benchmarkDiagram(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.WriteString(io.Discard, diagram.GenerateSVG()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteSVG(b *testing.B) {
+	diagram := benchmarkDiagram(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := diagram.WriteSVG(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,11 +1,11 @@
-package main
+package control
 
 import "strings"
 
-// WrapText splits text into multiple lines based on max characters per line.
+// wrapText splits text into multiple lines based on max characters per line.
 // It tries to break at word boundaries when possible and respects existing newlines.
 // Returns at most maxLines lines (default 3 if maxLines <= 0).
-func WrapText(text string, maxCharsPerLine int, maxLines int) []string {
+func wrapText(text string, maxCharsPerLine int, maxLines int) []string {
 	if maxLines <= 0 {
 		maxLines = 3
 	}
@@ -1,4 +1,4 @@
-package main
+package control
 
 import (
 	"reflect"
@@ -7,7 +7,7 @@ import (
 )
 
 func TestWrapText_ShortText(t *testing.T) {
-	result := WrapText("Hello", 20, 3)
+	result := wrapText("Hello", 20, 3)
 
 	expected := []string{"Hello"}
 	if !reflect.DeepEqual(result, expected) {
@@ -16,7 +16,7 @@ func TestWrapText_ShortText(t *testing.T) {
 }
 
 func TestWrapText_ExactFit(t *testing.T) {
-	result := WrapText("Hello World", 11, 3)
+	result := wrapText("Hello World", 11, 3)
 
 	expected := []string{"Hello World"}
 	if !reflect.DeepEqual(result, expected) {
@@ -25,7 +25,7 @@ func TestWrapText_ExactFit(t *testing.T) {
 }
 
 func TestWrapText_NeedsWrapping(t *testing.T) {
-	result := WrapText("Hello World This Is Long", 15, 3)
+	result := wrapText("Hello World This Is Long", 15, 3)
 
 	// Should break at word boundaries
 	if len(result) < 2 {
@@ -41,7 +41,7 @@ func TestWrapText_NeedsWrapping(t *testing.T) {
 }
 
 func TestWrapText_ExistingNewlines(t *testing.T) {
-	result := WrapText("Line One\nLine Two", 20, 3)
+	result := wrapText("Line One\nLine Two", 20, 3)
 
 	expected := []string{"Line One", "Line Two"}
 	if !reflect.DeepEqual(result, expected) {
@@ -50,7 +50,7 @@ func TestWrapText_ExistingNewlines(t *testing.T) {
 }
 
 func TestWrapText_LongWord(t *testing.T) {
-	result := WrapText("Supercalifragilisticexpialidocious", 10, 3)
+	result := wrapText("Supercalifragilisticexpialidocious", 10, 3)
 
 	// Should break the word
 	if len(result) < 2 {
@@ -66,7 +66,7 @@ func TestWrapText_LongWord(t *testing.T) {
 }
 
 func TestWrapText_EmptyText(t *testing.T) {
-	result := WrapText("", 20, 3)
+	result := wrapText("", 20, 3)
 
 	if len(result) != 0 {
 		t.Errorf("Expected empty result, got %v", result)
@@ -77,7 +77,7 @@ func TestWrapText_MaxLinesLimit(t *testing.T) {
 	// Text that would wrap to many lines
 	longText := "This is a very long text that will definitely need more than three lines to display properly"
 
-	result := WrapText(longText, 15, 3)
+	result := wrapText(longText, 15, 3)
 
 	if len(result) > 3 {
 		t.Errorf("Expected max 3 lines, got %d", len(result))
@@ -93,7 +93,7 @@ func TestWrapText_MaxLinesLimit(t *testing.T) {
 }
 
 func TestWrapText_MultipleWords(t *testing.T) {
-	result := WrapText("Sprint Planning Daily Standup", 15, 3)
+	result := wrapText("Sprint Planning Daily Standup", 15, 3)
 
 	// Check that words are preserved
 	combined := ""
@@ -112,7 +112,7 @@ func TestWrapText_DefaultMaxLines(t *testing.T) {
 	longText := "Line1 Line2 Line3 Line4 Line5 Line6 Line7 Line8"
 
 	// maxLines = 0 should default to 3
-	result := WrapText(longText, 10, 0)
+	result := wrapText(longText, 10, 0)
 
 	if len(result) > 3 {
 		t.Errorf("Expected default max 3 lines, got %d", len(result))
@@ -120,7 +120,7 @@ func TestWrapText_DefaultMaxLines(t *testing.T) {
 }
 
 func TestWrapText_WithLeadingTrailingSpaces(t *testing.T) {
-	result := WrapText("  Hello World  ", 20, 3)
+	result := wrapText("  Hello World  ", 20, 3)
 
 	expected := []string{"Hello World"}
 	if !reflect.DeepEqual(result, expected) {
@@ -129,7 +129,7 @@ func TestWrapText_WithLeadingTrailingSpaces(t *testing.T) {
 }
 
 func TestWrapText_MultipleSpaces(t *testing.T) {
-	result := WrapText("Hello    World", 20, 3)
+	result := wrapText("Hello    World", 20, 3)
 
 	// strings.Fields collapses multiple spaces, which is expected behavior
 	if len(result) != 1 {
@@ -182,7 +182,7 @@ func TestWrapText_RealWorldExample(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := WrapText(tt.text, tt.maxChars, tt.maxLines)
+			result := wrapText(tt.text, tt.maxChars, tt.maxLines)
 
 			if len(result) > tt.wantMax {
 				t.Errorf("Expected at most %d lines, got %d: %v", tt.wantMax, len(result), result)
@@ -0,0 +1,178 @@
+package control
+
+// maxWaypointLegs limits the legs between waypoints whose bend direction is
+// searched; later legs bend horizontally first
+const maxWaypointLegs = 6
+
+// routeArrowVia routes an arrow from box1 through the waypoints [x0,y0,...]
+// (in pixels) to box2 as an orthogonal polyline. Every combination of sides
+// and of bend directions between waypoints is tried; the route avoiding boxes
+// with the fewest reversals, bends and pixels wins. A route through boxes is
+// still returned, with its only candidate marked as rejected, so the caller
+// can warn instead of dropping the arrow.
+func routeArrowVia(
+	box1, box2 boxCoords,
+	waypoints []int,
+	allBoxes []placedBox,
+	fromID, toID string,
+	ports arrowPorts,
+) *routingPlan {
+	n := len(waypoints) / 2
+	legs := min(n-1, maxWaypointLegs)
+
+	// The route may not pass through its own boxes between the end segments
+	ends := []placedBox{boxCoordsData(box1), boxCoordsData(box2)}
+	ends[0].ID, ends[1].ID = fromID, toID
+
+	var best []int
+	bestCost := [4]int{}
+	for _, fromSide := range viaSides(box1, waypoints[0], waypoints[1], ports.From) {
+		for _, toSide := range viaSides(box2, waypoints[2*n-2], waypoints[2*n-1], ports.To) {
+			for mask := 0; mask < 1<<legs; mask++ {
+				points := viaPolyline(box1, box2, waypoints, fromSide, toSide, mask)
+				cost := [4]int{0, reversals(points), 0, polylineLength(points)}
+				if checkPathCollision(points, allBoxes, fromID, toID) ||
+					checkPathCollision(points[2:len(points)-2], ends, "", "") {
+					cost[0] = 1
+				}
+				points = simplifyPolyline(points)
+				cost[2] = len(points)
+				if best == nil || lessCost(cost, bestCost) {
+					best, bestCost = points, cost
+				}
+			}
+		}
+	}
+
+	last := len(best) - 2
+	candidate := routeCandidate{
+		startX: best[0], startY: best[1],
+		endX: best[last], endY: best[last+1],
+		strategy: "waypoints", verticalFirst: best[0] == best[2],
+		segments: best, selected: true,
+	}
+	if bestCost[0] != 0 {
+		candidate.rejected = true
+		candidate.rejectReason = "collision_detected"
+	}
+	return &routingPlan{
+		StartX: best[0], StartY: best[1],
+		EndX: best[last], EndY: best[last+1],
+		Strategy:      "waypoints",
+		VerticalFirst: candidate.verticalFirst,
+		NumSegments:   len(best)/2 - 1,
+		Points:        best,
+		AllCandidates: []routeCandidate{candidate},
+	}
+}
+
+// lessCost compares route costs lexicographically
+func lessCost(a, b [4]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// viaSides returns the sides of box a route to or from point (x, y) may use:
+// the requested port, or every side the point lies beyond
+func viaSides(box boxCoords, x, y int, port string) []string {
+	if port != "" {
+		return []string{port}
+	}
+	var sides []string
+	if y < box.Y1 {
+		sides = append(sides, SideTop)
+	}
+	if y > box.Y2 {
+		sides = append(sides, SideBottom)
+	}
+	if x < box.X1 {
+		sides = append(sides, SideLeft)
+	}
+	if x > box.X2 {
+		sides = append(sides, SideRight)
+	}
+	if len(sides) == 0 {
+		return sidesOrAll("") // The point lies inside the box
+	}
+	return sides
+}
+
+// viaPolyline builds the unsimplified route from a side of box1 through the
+// waypoints to a side of box2. Bit k of mask makes the leg from waypoint k to
+// waypoint k+1 (counting from 0) bend vertically first. End segments leave and enter their sides at a right
+// angle, in line with the nearest waypoint where the side allows; a waypoint
+// behind a side is reached through a portStubLength stub.
+func viaPolyline(box1, box2 boxCoords, waypoints []int, fromSide, toSide string, mask int) []int {
+	n := len(waypoints) / 2
+	wx, wy := waypoints[0], waypoints[1]
+	sx, sy, dx, dy := viaAnchor(box1, fromSide, wx, wy, 0)
+	points := []int{sx, sy}
+	facing := (dx != 0 && sign(wx-sx) == dx) || (dy != 0 && sign(wy-sy) == dy)
+	if !facing {
+		points = append(points, sx+dx*portStubLength, sy+dy*portStubLength)
+	}
+	// Leave the side at a right angle, or turn right after the stub
+	points = appendLeg(points, wx, wy, (dx != 0) == facing)
+
+	for k := 1; k < n; k++ {
+		points = appendLeg(points, waypoints[2*k], waypoints[2*k+1], mask&(1<<(k-1)) == 0)
+	}
+
+	wx, wy = waypoints[2*n-2], waypoints[2*n-1]
+	ex, ey, dx, dy := viaAnchor(box2, toSide, wx, wy, strokeAdjustment)
+	if (dx != 0 && sign(wx-ex) == dx) || (dy != 0 && sign(wy-ey) == dy) {
+		// Enter the side at a right angle
+		return appendLeg(points, ex, ey, dx == 0)
+	}
+	points = appendLeg(points, ex+dx*portStubLength, ey+dy*portStubLength, dx != 0)
+	return append(points, ex, ey)
+}
+
+// viaAnchor returns where a route to point (x, y) attaches to a box side,
+// moved outwards by offset: in line with the point if it lies within the
+// side's span, else the side's middle. Also returns the outward direction.
+func viaAnchor(box boxCoords, side string, x, y, offset int) (ax, ay, dx, dy int) {
+	ax, ay, dx, dy = sideAnchor(box, side, offset)
+	if dx == 0 && x > box.X1 && x < box.X2 {
+		ax = x
+	}
+	if dy == 0 && y > box.Y1 && y < box.Y2 {
+		ay = y
+	}
+	return ax, ay, dx, dy
+}
+
+// appendLeg appends an orthogonal leg from the last point of a polyline to
+// (x, y), with a bend unless both lie on one line
+func appendLeg(points []int, x, y int, horizontalFirst bool) []int {
+	px, py := points[len(points)-2], points[len(points)-1]
+	if px != x && py != y {
+		if horizontalFirst {
+			points = append(points, x, py)
+		} else {
+			points = append(points, px, y)
+		}
+	}
+	return append(points, x, y)
+}
+
+// reversals counts the places where a polyline doubles back on itself
+func reversals(points []int) int {
+	count := 0
+	prevDX, prevDY := 0, 0
+	for i := 0; i+3 < len(points); i += 2 {
+		dx, dy := sign(points[i+2]-points[i]), sign(points[i+3]-points[i+1])
+		if dx == 0 && dy == 0 {
+			continue
+		}
+		if dx == -prevDX && dy == -prevDY {
+			count++
+		}
+		prevDX, prevDY = dx, dy
+	}
+	return count
+}